@@ -35,7 +35,7 @@ def emit_archive(go, source = None):
     if source == None:
         fail("source is a required parameter")
 
-    covered = bool(go.cover and go.coverdata and source.cover)
+    covered = bool(go.cover and go.coverdata and (source.cover or getattr(source, "cover_main", False)))
     if covered:
         source = go.cover(go, source)
     split = split_srcs(source.srcs)
@@ -112,6 +112,7 @@ def emit_archive(go, source = None):
         orig_srcs = as_tuple(source.orig_srcs),
         data_files = as_tuple(data_files),
         searchpath = searchpath,
+        deps = tuple([a.data.label for a in direct]),
     )
     x_defs = dict(source.x_defs)
     for a in direct:
@@ -32,7 +32,11 @@ def emit_binary(
         gc_linkopts = [],
         version_file = None,
         info_file = None,
-        executable = None):
+        executable = None,
+        debug_info = None,
+        sbom = None,
+        sbom_format = "spdx",
+        licenses = None):
     """See go/toolchains.rst#binary for full documentation."""
 
     if name == "" and executable == None:
@@ -57,6 +61,10 @@ def emit_binary(
         gc_linkopts = gc_linkopts,
         version_file = version_file,
         info_file = info_file,
+        debug_info = debug_info,
+        sbom = sbom,
+        sbom_format = sbom_format,
+        licenses = licenses,
     )
     cgo_dynamic_deps = [
         d
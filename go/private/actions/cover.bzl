@@ -21,6 +21,10 @@ load(
     "@io_bazel_rules_go//go/private:common.bzl",
     "structs",
 )
+load(
+    "@io_bazel_rules_go//go/private:mode.bzl",
+    "LINKMODE_NORMAL",
+)
 
 def _sanitize(s):
     """Replaces /, -, and . with _."""
@@ -31,13 +35,19 @@ def emit_cover(go, source):
 
     if source == None:
         fail("source is a required parameter")
-    if not source.cover:
+
+    # Coverage is written by a binary when its main function returns, so
+    # main is wrapped in binaries linked normally. Files that aren't
+    # instrumented are only processed to find main.
+    binary_main = getattr(source, "cover_main", False) and go.mode.link == LINKMODE_NORMAL
+    if not source.cover and not binary_main:
         return source
 
     covered = []
     covered_src_map = dict(source.orig_src_map)
     for src in source.srcs:
-        if not src.basename.endswith(".go") or src not in source.cover:
+        instrument = src in source.cover
+        if not src.basename.endswith(".go") or not (instrument or binary_main):
             covered.append(src)
             continue
         orig = covered_src_map.get(src, src)
@@ -54,9 +64,14 @@ def emit_cover(go, source):
         args.add("-o", out)
         args.add("-var", cover_var)
         args.add("-src", src)
+        args.add("-pkgpath", source.library.importmap)
         args.add("-srcname", srcname)
-        args.add("--")
-        args.add("-mode", "set")
+        args.add("-srcpath", orig.path)
+        args.add("-mode", go.cover_mode)
+        if binary_main:
+            args.add("-main")
+        if not instrument:
+            args.add("-wrap_main_only")
         go.actions.run(
             inputs = [src] + go.sdk.tools,
             outputs = [out],
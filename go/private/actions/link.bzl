@@ -24,6 +24,7 @@ load(
     "LINKMODE_PLUGIN",
     "extld_from_cc_toolchain",
     "extldflags_from_cc_toolchain",
+    "mode_string",
 )
 load(
     "@io_bazel_rules_go//go/private:skylib/lib/shell.bzl",
@@ -47,6 +48,9 @@ def _map_archive(x):
         if not any([d.importmap == t.importmap for t in x.test_archives])
     ]
 
+def _format_archive_deps(d):
+    return "=".join([str(d.label)] + [str(l) for l in d.deps])
+
 def emit_link(
         go,
         archive = None,
@@ -54,7 +58,11 @@ def emit_link(
         executable = None,
         gc_linkopts = [],
         version_file = None,
-        info_file = None):
+        info_file = None,
+        debug_info = None,
+        sbom = None,
+        sbom_format = "spdx",
+        licenses = None):
     """See go/toolchains.rst#link for full documentation."""
 
     if archive == None:
@@ -99,6 +107,16 @@ def emit_link(
         map_each = _map_archive,
     )
     builder_args.add_all(test_archives, before_each = "-arc", map_each = _format_archive)
+
+    # Dependencies of each library are used to explain why packages provided
+    # by more than one library are both linked.
+    builder_args.add_all(
+        depset(test_archives, transitive = [archive.transitive]),
+        before_each = "-arcdeps",
+        map_each = _format_archive_deps,
+    )
+    if "go_allow_duplicate_packages" in go._ctx.features:
+        builder_args.add("-allow_duplicate_packages")
     builder_args.add("-package_list", go.package_list)
 
     # Build a list of rpaths for dynamic libraries we need to find.
@@ -135,18 +153,41 @@ def emit_link(
 
     # Stamping support
     stamp_inputs = []
-    if stamp_x_defs:
+    if stamp_x_defs or (go.stamp and info_file and version_file):
         stamp_inputs = [info_file, version_file]
         builder_args.add_all(stamp_inputs, before_each = "-stamp")
 
+    # Information for the buildinfo package. The builder only uses this if
+    # the package is linked into the binary. Stamped values are only set
+    # with --stamp, so unstamped binaries aren't re-linked when they change.
+    builder_args.add("-label", str(go._ctx.label))
+    builder_args.add("-mode", mode_string(go.mode))
+    if go.stamp:
+        builder_args.add("-stamp_buildinfo")
+
     builder_args.add("-o", executable)
     builder_args.add("-main", archive.data.file)
+    outputs = [executable]
+    if debug_info:
+        if go.mode.goos in ("darwin", "windows"):
+            fail("debug information can only be split from ELF binaries")
+        if not go.cgo_tools.objcopy_path:
+            fail("debug information can't be split without objcopy in the C/C++ toolchain")
+        builder_args.add("-debug_info", debug_info)
+        builder_args.add("-objcopy", go.cgo_tools.objcopy_path)
+        outputs.append(debug_info)
+    if sbom:
+        builder_args.add("-sbom", sbom)
+        builder_args.add("-sbom_format", sbom_format)
+        if licenses:
+            builder_args.add_all(licenses, before_each = "-license")
+        outputs.append(sbom)
     tool_args.add_all(gc_linkopts)
     tool_args.add_all(go.toolchain.flags.link)
 
     # Do not remove, somehow this is needed when building for darwin/arm only.
     tool_args.add("-buildid=redacted")
-    if go.mode.strip:
+    if go.mode.strip and not debug_info:
         tool_args.add("-w")
     tool_args.add_joined("-extldflags", extldflags, join_with = " ")
 
@@ -160,7 +201,7 @@ def emit_link(
             [go.sdk.package_list],
             go.stdlib.libs,
         ),
-        outputs = [executable],
+        outputs = outputs,
         mnemonic = "GoLink",
         executable = go.builders.link,
         arguments = [builder_args, "--", tool_args],
@@ -0,0 +1,51 @@
+# Copyright 2018 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+def _format_package(d):
+    return "{}={}".format(d.importmap, d.label)
+
+def emit_size_report(go, archive = None, executable = None):
+    """Emits an action that breaks down the size of a linked binary.
+
+    The report is written as JSON and as a human-readable summary. It's only
+    built when requested through the size_report output group.
+
+    Args:
+      go: the GoContext.
+      archive: the GoArchive of the binary's main package.
+      executable: the linked binary.
+
+    Returns:
+      A list containing the JSON report and the summary.
+    """
+    json_report = go.declare_file(go, ext = ".size.json")
+    summary = go.declare_file(go, ext = ".size.txt")
+
+    args = go.builder_args(go)
+    args.add("-binary", executable)
+    args.add("-label", str(go._ctx.label))
+    args.add("-package_list", go.package_list)
+    args.add_all(archive.transitive, before_each = "-pkg", map_each = _format_package)
+    args.add("-json", json_report)
+    args.add("-summary", summary)
+
+    go.actions.run(
+        inputs = [executable, go.package_list] + go.sdk.tools,
+        outputs = [json_report, summary],
+        mnemonic = "GoSizeReport",
+        executable = go.builders.size_report,
+        arguments = [args],
+        env = go.env,
+    )
+    return [json_report, summary]
@@ -162,6 +162,27 @@ def goos_to_shared_extension(goos):
 
 MINIMUM_BAZEL_VERSION = "0.8.0"
 
+def go_minor_version(version):
+    """Returns the minor version number of a Go version like "1.11.2" or
+    "1.12rc1".
+
+    Development versions are treated as newer than any release. 0 is returned
+    if the version is empty or can't be parsed.
+    """
+    if version == "devel":
+        return 1000
+    parts = version.split(".")
+    if len(parts) < 2 or parts[0] != "1":
+        return 0
+    digits = ""
+    for i in range(len(parts[1])):
+        if not parts[1][i].isdigit():
+            break
+        digits += parts[1][i]
+    if not digits:
+        return 0
+    return int(digits)
+
 def as_list(v):
     if type(v) == "list":
         return v
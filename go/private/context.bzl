@@ -80,6 +80,10 @@ _LINKER_OPTIONS_BLACKLIST = {
     "-Wl,--gc-sections": None,
 }
 
+# Modes supported by go tool cover. The mode may be set with
+# --define gocovermode=<mode>.
+_COVER_MODES = ["set", "count", "atomic"]
+
 def _filter_options(options, blacklist):
     return [option for option in options if option not in blacklist]
 
@@ -305,6 +309,10 @@ def go_context(ctx, attr = None):
         tags.append("race")
     if mode.msan:
         tags.append("msan")
+
+    # Coverage counters are updated concurrently in race mode, so they must
+    # be updated atomically.
+    cover_mode = "atomic" if mode.race else context_data.cover_mode
     binary = toolchain.sdk.go
 
     stdlib = getattr(attr, "_stdlib", None)
@@ -322,6 +330,8 @@ def go_context(ctx, attr = None):
         "GOROOT_FINAL": "GOROOT",
         "CGO_ENABLED": "0" if mode.pure else "1",
     })
+    if toolchain.goexperiment:
+        env["GOEXPERIMENT"] = ",".join(toolchain.goexperiment)
 
     # TODO(jayconrod): remove this. It's way too broad. Everything should
     # depend on more specific lists.
@@ -357,6 +367,8 @@ def go_context(ctx, attr = None):
         coverdata = coverdata,
         coverage_enabled = ctx.configuration.coverage_enabled,
         coverage_instrumented = ctx.coverage_instrumented(),
+        cover_mode = cover_mode,
+        stamp = context_data.stamp,
         env = env,
         tags = tags,
         # Action generators
@@ -503,6 +515,9 @@ def _go_context_data_impl(ctx):
     tags = []
     if "gotags" in ctx.var:
         tags = ctx.var["gotags"].split(",")
+    cover_mode = ctx.var.get("gocovermode", "set")
+    if cover_mode not in _COVER_MODES:
+        fail("invalid gocovermode {}: must be one of {}".format(cover_mode, ", ".join(_COVER_MODES)))
     apple_ensure_options(
         ctx,
         env,
@@ -536,8 +551,10 @@ def _go_context_data_impl(ctx):
 
     return [_GoContextData(
         strip = ctx.attr.strip,
+        stamp = ctx.attr.stamp,
         crosstool = ctx.files._cc_toolchain,
         tags = tags,
+        cover_mode = cover_mode,
         env = env,
         cgo_tools = struct(
             c_compiler_path = c_compiler_path,
@@ -548,6 +565,7 @@ def _go_context_data_impl(ctx):
             ld_static_lib_path = ld_static_lib_path,
             ld_dynamic_lib_path = ld_dynamic_lib_path,
             ld_dynamic_lib_options = ld_dynamic_lib_options,
+            objcopy_path = cc_toolchain.objcopy_executable,
         ),
     )]
 
@@ -555,6 +573,7 @@ go_context_data = rule(
     _go_context_data_impl,
     attrs = {
         "strip": attr.string(mandatory = True),
+        "stamp": attr.bool(),
         "_cc_toolchain": attr.label(default = "@bazel_tools//tools/cpp:current_cc_toolchain"),
         "_xcode_config": attr.label(
             default = "@bazel_tools//tools/osx:current_xcode_config",
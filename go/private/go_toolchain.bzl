@@ -46,7 +46,11 @@ def _go_toolchain_impl(ctx):
             compile = (),
             link = ctx.attr.link_flags,
             link_cgo = ctx.attr.cgo_link_flags,
+            stdlib_compile = ctx.attr.stdlib_gc_flags,
+            stdlib_asm = ctx.attr.stdlib_asm_flags,
         ),
+        goexperiment = ctx.attr.goexperiment,
+        stdlib_patches = ctx.files.stdlib_patches,
         sdk = sdk,
     )]
 
@@ -74,6 +78,19 @@ _go_toolchain = rule(
         "cgo_link_flags": attr.string_list(
             doc = "Flags passed to the external linker (if it is used)",
         ),
+        "stdlib_gc_flags": attr.string_list(
+            doc = "Flags passed to the compiler when building the standard library",
+        ),
+        "stdlib_asm_flags": attr.string_list(
+            doc = "Flags passed to the assembler when building the standard library",
+        ),
+        "goexperiment": attr.string_list(
+            doc = "Experiments enabled with GOEXPERIMENT in all Go actions",
+        ),
+        "stdlib_patches": attr.label_list(
+            allow_files = True,
+            doc = "Patches applied to the standard library sources before building",
+        ),
     },
     doc = "Defines a Go toolchain based on an SDK",
     provides = [platform_common.ToolchainInfo],
@@ -66,6 +66,12 @@ GoSDK = provider(
         "tools": ("List of executable files from pkg/tool " +
                   "built for the execution platform."),
         "go": "The go binary file",
+        "std_packages": ("Dict mapping platforms and variants like " +
+                         "linux_amd64_pure to packages in the standard " +
+                         "library, as listed by the SDK repository rule. " +
+                         "May be empty."),
+        "version": ("The Go version of the SDK, like 1.11.2, or devel. " +
+                    "May be empty."),
     },
 )
 
@@ -72,6 +72,7 @@ go_archive_aspect = aspect(
         "compilers",
         "_stdlib",
         "_coverdata",
+        "_testmain_additional_deps",
     ],
     attrs = {
         "pure": attr.string(values = [
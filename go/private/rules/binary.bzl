@@ -20,11 +20,23 @@ load(
     "@io_bazel_rules_go//go/private:common.bzl",
     "asm_exts",
     "go_exts",
+    "structs",
+)
+load(
+    "@io_bazel_rules_go//go/private:actions/size_report.bzl",
+    "emit_size_report",
 )
 load(
     "@io_bazel_rules_go//go/private:rules/aspect.bzl",
     "go_archive_aspect",
 )
+load(
+    "@io_bazel_rules_go//go/private:rules/license.bzl",
+    "GoLicenses",
+    "go_license_aspect",
+    "license_entry",
+    "license_kinds",
+)
 load(
     "@io_bazel_rules_go//go/private:rules/rule.bzl",
     "go_rule",
@@ -32,6 +44,7 @@ load(
 load(
     "@io_bazel_rules_go//go/private:providers.bzl",
     "GoLibrary",
+    "GoSource",
 )
 load(
     "@io_bazel_rules_go//go/platform:list.bzl",
@@ -44,6 +57,11 @@ load(
     "LINKMODE_NORMAL",
 )
 
+_SBOM_EXTENSIONS = {
+    "spdx": ".spdx.json",
+    "cyclonedx": ".cdx.json",
+}
+
 _SHARED_ATTRS = {
     "basename": attr.string(),
     "data": attr.label_list(allow_files = True),
@@ -59,8 +77,13 @@ def _go_binary_impl(ctx):
     """go_binary_impl emits actions for compiling and linking a go executable."""
     go = go_context(ctx)
 
-    library = go.new_library(go, importable = False)
+    library = go.new_library(go, importable = False, is_main = True)
     source = go.library_to_source(go, ctx.attr, library, ctx.coverage_instrumented())
+    if go.coverage_enabled and go.coverdata:
+        # Coverage is written when the main function returns, so main is
+        # wrapped even if the main package isn't instrumented. See
+        # coverdata.BinaryMain.
+        source = _wrap_main_for_coverage(source)
     name = ctx.attr.basename
     if not name:
         name = ctx.label.name
@@ -70,6 +93,15 @@ def _go_binary_impl(ctx):
         # directly, Bazel warns them not to use the same name as the rule, which is
         # the common case with go_binary.
         executable = ctx.actions.declare_file(ctx.attr.out)
+    debug_info = None
+    if getattr(ctx.attr, "split_debug_info", False):
+        debug_info = go.declare_file(go, name = name, ext = ".debug")
+    sbom = None
+    sbom_format = getattr(ctx.attr, "sbom", "")
+    licenses = None
+    if sbom_format:
+        sbom = go.declare_file(go, name = name, ext = _SBOM_EXTENSIONS[sbom_format])
+        licenses = _collect_licenses(ctx)
     archive, executable, runfiles = go.binary(
         go,
         name = name,
@@ -78,7 +110,17 @@ def _go_binary_impl(ctx):
         version_file = ctx.version_file,
         info_file = ctx.info_file,
         executable = executable,
+        debug_info = debug_info,
+        sbom = sbom,
+        sbom_format = sbom_format,
+        licenses = licenses,
     )
+    # The report is only built when the size_report output group is requested.
+    # Bootstrap binaries (the builders themselves) don't have one.
+    size_report = []
+    if go.builders and go.mode.link == LINKMODE_NORMAL:
+        size_report = emit_size_report(go, archive = archive, executable = executable)
+
     return [
         library,
         source,
@@ -86,6 +128,9 @@ def _go_binary_impl(ctx):
         OutputGroupInfo(
             cgo_exports = archive.cgo_exports,
             compilation_outputs = [archive.data.file],
+            size_report = size_report,
+            debug_info = [debug_info] if debug_info else [],
+            sbom = [sbom] if sbom else [],
         ),
         DefaultInfo(
             files = depset([executable]),
@@ -94,16 +139,33 @@ def _go_binary_impl(ctx):
         ),
     ]
 
+def _collect_licenses(ctx):
+    direct = []
+    kinds = license_kinds(ctx.attr)
+    if kinds:
+        direct.append(license_entry(ctx.label, kinds))
+    transitive = [
+        d[GoLicenses].licenses
+        for d in ctx.attr.deps + ctx.attr.embed
+        if GoLicenses in d
+    ]
+    return depset(direct, transitive = transitive)
+
+def _wrap_main_for_coverage(source):
+    members = structs.to_dict(source)
+    members["cover_main"] = True
+    return GoSource(**members)
+
 go_binary = go_rule(
     _go_binary_impl,
     attrs = dict({
         "deps": attr.label_list(
             providers = [GoLibrary],
-            aspects = [go_archive_aspect],
+            aspects = [go_archive_aspect, go_license_aspect],
         ),
         "embed": attr.label_list(
             providers = [GoLibrary],
-            aspects = [go_archive_aspect],
+            aspects = [go_archive_aspect, go_license_aspect],
         ),
         "importpath": attr.string(),
         "pure": attr.string(
@@ -146,6 +208,9 @@ go_binary = go_rule(
             values = GOARCH.keys() + ["auto"],
             default = "auto",
         ),
+        "split_debug_info": attr.bool(),
+        "sbom": attr.string(values = ["", "spdx", "cyclonedx"]),
+        "license_kinds": attr.string_list(),
     }.items() + _SHARED_ATTRS.items()),
     executable = True,
 )
@@ -25,6 +25,7 @@ def _builders_impl(ctx):
             nogo_generator = ctx.executable._nogo_generator,
             test_generator = ctx.executable._test_generator,
             cover = ctx.executable._cover,
+            size_report = ctx.executable._size_report,
         ),
         DefaultInfo(
             files = depset([
@@ -36,6 +37,7 @@ def _builders_impl(ctx):
                 ctx.executable._nogo_generator,
                 ctx.executable._test_generator,
                 ctx.executable._cover,
+                ctx.executable._size_report,
             ]),
         ),
     ]
@@ -83,5 +85,10 @@ builders = rule(
             cfg = "host",
             default = "//go/tools/builders:cover",
         ),
+        "_size_report": attr.label(
+            executable = True,
+            cfg = "host",
+            default = "//go/tools/builders:size_report",
+        ),
     },
 )
@@ -59,6 +59,7 @@ go_library = go_rule(
         "embed": attr.label_list(providers = [GoLibrary]),
         "gc_goopts": attr.string_list(),
         "x_defs": attr.string_dict(),
+        "license_kinds": attr.string_list(),
     },
 )
 """See go/core.rst#go_library for full documentation."""
@@ -0,0 +1,100 @@
+# Copyright 2018 The Bazel Authors. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#    http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+load(
+    "@io_bazel_rules_go//go/private:providers.bzl",
+    "GoLibrary",
+)
+
+GoLicenses = provider()
+"""
+The license kinds of Go libraries linked into a binary. licenses is a depset
+of strings. Each string is the label of a library followed by its license
+kinds, separated by '=' and ','. This is the format expected by the -license
+flag of the link builder.
+"""
+
+# License kinds recognized by Bazel's licenses() function.
+_LICENSE_KINDS = [
+    "by_exception_only",
+    "notice",
+    "permissive",
+    "reciprocal",
+    "restricted",
+    "unencumbered",
+]
+
+_LICENSE_ATTRS = [
+    "deps",
+    "embed",
+    "compiler",
+    "compilers",
+    "_coverdata",
+    "_testmain_additional_deps",
+]
+
+def license_kinds(attr):
+    """Returns the license kinds declared by a rule.
+
+    Args:
+      attr: the attributes of the rule (ctx.attr or ctx.rule.attr).
+
+    Returns:
+      A sorted list of license kinds like "notice", taken from the rule's
+      license_kinds attribute.
+    """
+    kinds = getattr(attr, "license_kinds", None)
+    if not kinds:
+        return []
+    return sorted({k: None for k in kinds}.keys())
+
+def license_kinds_from_licenses(licenses):
+    """Returns the license kinds in the value of a licenses attribute.
+
+    The go_library and go_binary macros use this to set license_kinds, since
+    Bazel doesn't expose the licenses attribute to rule implementations.
+    Exceptions and unknown kinds are dropped.
+    """
+    if type(licenses) != "list":
+        return []
+    return [k for k in licenses if k in _LICENSE_KINDS]
+
+def license_entry(label, kinds):
+    """Formats a -license argument for the link builder."""
+    return "{}={}".format(label, ",".join(kinds))
+
+def _go_license_aspect_impl(target, ctx):
+    transitive = []
+    for name in _LICENSE_ATTRS:
+        deps = getattr(ctx.rule.attr, name, None)
+        if not deps:
+            continue
+        if type(deps) != "list":
+            deps = [deps]
+        transitive.extend([d[GoLicenses].licenses for d in deps if GoLicenses in d])
+    direct = []
+    if GoLibrary in target:
+        kinds = license_kinds(ctx.rule.attr)
+        if kinds:
+            direct.append(license_entry(target.label, kinds))
+    return [GoLicenses(licenses = depset(direct, transitive = transitive))]
+
+go_license_aspect = aspect(
+    _go_license_aspect_impl,
+    attr_aspects = _LICENSE_ATTRS,
+)
+"""Collects license kinds from Go libraries for bills of materials.
+
+See go/core.rst#bills-of-materials for more information.
+"""
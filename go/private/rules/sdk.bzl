@@ -32,6 +32,8 @@ def _go_sdk_impl(ctx):
         srcs = ctx.files.srcs,
         tools = ctx.files.tools,
         go = ctx.executable.go,
+        std_packages = ctx.attr.std_packages,
+        version = ctx.attr.version,
     )]
 
 go_sdk = rule(
@@ -82,6 +84,17 @@ go_sdk = rule(
             cfg = "host",
             doc = "The go binary",
         ),
+        "std_packages": attr.string_list_dict(
+            doc = ("Packages in the standard library for each platform " +
+                   "and variant (like linux_amd64_pure or " +
+                   "linux_amd64_race), as listed when the SDK repository " +
+                   "was created. Used to build the standard library with " +
+                   "one action per package."),
+        ),
+        "version": attr.string(
+            doc = ("The Go version of the SDK, like 1.11.2, or devel for " +
+                   "a development version. May be empty if unknown."),
+        ),
     },
     doc = ("Collects information about a Go SDK. The SDK must have a normal " +
            "GOROOT directory structure."),
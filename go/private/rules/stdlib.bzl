@@ -28,12 +28,19 @@ load(
     "@io_bazel_rules_go//go/private:mode.bzl",
     "LINKMODE_NORMAL",
     "extldflags_from_cc_toolchain",
+    "installsuffix",
     "link_mode_args",
 )
+load(
+    "@io_bazel_rules_go//go/private:common.bzl",
+    "go_minor_version",
+)
 
 def _stdlib_library_to_source(go, attr, source, merge):
     if _should_use_sdk_stdlib(go):
         source["stdlib"] = _sdk_stdlib(go)
+    elif _should_build_stdlib_packages(go):
+        source["stdlib"] = _build_stdlib_packages(go)
     else:
         source["stdlib"] = _build_stdlib(go, attr)
 
@@ -43,7 +50,11 @@ def _should_use_sdk_stdlib(go):
             not go.mode.race and  # TODO(jayconrod): use precompiled race
             not go.mode.msan and
             not go.mode.pure and
-            go.mode.link == LINKMODE_NORMAL)
+            go.mode.link == LINKMODE_NORMAL and
+            not go.toolchain.flags.stdlib_compile and
+            not go.toolchain.flags.stdlib_asm and
+            not go.toolchain.goexperiment and
+            not go.toolchain.stdlib_patches)
 
 def _sdk_stdlib(go):
     return GoStdLib(
@@ -51,7 +62,369 @@ def _sdk_stdlib(go):
         libs = go.sdk.libs,
     )
 
+def _should_build_stdlib_packages(go):
+    # Packages are listed for the platform the SDK runs on when the SDK
+    # repository is created. Patches may add or remove files, and build tags,
+    # experiments, and link modes other than the default change which files
+    # are built and how, so those cases are built with "go install".
+    return (go.mode.link == LINKMODE_NORMAL and
+            not [tag for tag in go.tags if tag not in ("race", "msan")] and
+            not go.toolchain.goexperiment and
+            not go.toolchain.stdlib_patches and
+            go_minor_version(getattr(go.sdk, "version", "")) > 0 and
+            _std_variant(go) in (getattr(go.sdk, "std_packages", None) or {}))
+
+def _std_variant(go):
+    """Returns the key in go.sdk.std_packages for the mode being built."""
+    if go.mode.race:
+        variant = "race"
+    elif go.mode.msan:
+        variant = "msan"
+    elif go.mode.pure:
+        variant = "pure"
+    else:
+        variant = "cgo"
+    return "{}_{}_{}".format(go.mode.goos, go.mode.goarch, variant)
+
+def _build_stdlib_packages(go):
+    """Builds the standard library with one action per package.
+
+    Packages are compiled and assembled with the same builders as other
+    libraries, so they can be cached and built in parallel. Archives are
+    written to pkg/<installsuffix> below the ROOT file, like "go install".
+    """
+    root_file = go.declare_file(go, "ROOT")
+    go.actions.write(root_file, "")
+    src_prefix = go.sdk.root_file.dirname + "/src/"
+    srcs = {
+        f.path[len(src_prefix):]: f
+        for f in go.sdk.srcs
+        if f.path.startswith(src_prefix)
+    }
+    libdir = "pkg/" + installsuffix(go.mode)
+    minor = go_minor_version(go.sdk.version)
+    archives = {}
+    sysos = {}
+    for pkg in _sort_std_packages(_parse_std_packages(go.sdk.std_packages[_std_variant(go)])):
+        archives[pkg.path] = _emit_std_package(go, pkg, srcs, archives, sysos, libdir, minor)
+    return GoStdLib(
+        root_file = root_file,
+        libs = archives.values(),
+    )
+
+def _parse_std_packages(lines):
+    """Parses packages listed by _list_std_packages in sdk.bzl."""
+    pkgs = []
+    for line in lines:
+        (path, go_files, cgo_files, s_files, h_files, c_files, syso_files,
+         cppflags, cflags, ldflags, imports, import_map) = line.split(";")
+        if path == "unsafe":
+            # unsafe is built into the compiler.
+            continue
+        src_imports = {}
+        for entry in _split_list(import_map):
+            src, _, resolved = entry.partition("=")
+            src_imports[resolved] = src
+        imports = [imp for imp in _split_list(imports) if imp not in ("C", "unsafe")]
+        if cgo_files:
+            # Files generated by cgo import runtime/cgo and syscall, except
+            # in the packages cgo is told not to import them in.
+            if path != "runtime/cgo":
+                imports.append("runtime/cgo")
+            if path not in ("runtime/cgo", "runtime/race", "runtime/msan", "runtime/asan"):
+                imports.append("syscall")
+        pkgs.append(struct(
+            path = path,
+            go_files = _split_list(go_files),
+            cgo_files = _split_list(cgo_files),
+            s_files = _split_list(s_files),
+            h_files = _split_list(h_files),
+            c_files = _split_list(c_files),
+            syso_files = _split_list(syso_files),
+            cppflags = _split_list(cppflags, " "),
+            cflags = _split_list(cflags, " "),
+            ldflags = _split_list(ldflags, " "),
+            imports = [
+                struct(path = src_imports.get(imp, imp), resolved = imp)
+                for imp in _unique(imports)
+            ],
+        ))
+    return pkgs
+
+def _split_list(s, sep = ","):
+    return [e for e in s.split(sep) if e]
+
+def _unique(l):
+    seen = {}
+    result = []
+    for e in l:
+        if e not in seen:
+            seen[e] = True
+            result.append(e)
+    return result
+
+def _sort_std_packages(pkgs):
+    """Returns pkgs ordered so that each package comes after its imports.
+
+    Packages that import something that isn't listed can't be built, so
+    they're left out.
+    """
+    done = {}
+    order = []
+    pending = pkgs
+    for _ in range(len(pkgs)):
+        remaining = []
+        for pkg in pending:
+            ready = True
+            for imp in pkg.imports:
+                if imp.resolved not in done:
+                    ready = False
+                    break
+            if ready:
+                done[pkg.path] = True
+                order.append(pkg)
+            else:
+                remaining.append(pkg)
+        if len(remaining) == len(pending):
+            break
+        pending = remaining
+    return order
+
+def _emit_std_package(go, pkg, srcs, archives, sysos, libdir, minor):
+    """Emits actions to build one package in the standard library.
+
+    This follows "go build". cgo packages are run through cgo and the C
+    compiler first. Go assembly files are scanned for symbol ABIs, the
+    package is compiled with -asmhdr so the assembler can include go_asm.h,
+    then the assembly files are assembled. Objects from assembly, C files,
+    and the package's system objects are packed into the archive.
+    """
+    path = pkg.path
+    out_lib = go.declare_file(go, path = "{}/{}.a".format(libdir, path))
+    go_srcs = [_std_src(srcs, path, name) for name in pkg.go_files]
+    hdrs = [_std_src(srcs, path, name) for name in pkg.h_files]
+    own_sysos = [_std_src(srcs, path, name) for name in pkg.syso_files]
+    deps = [archives[imp.resolved] for imp in pkg.imports]
+
+    # System objects are linked into the program like C objects, so linking
+    # for cgo's dynamic import check needs those of dependencies, too.
+    pkg_sysos = list(own_sysos)
+    for imp in pkg.imports:
+        pkg_sysos.extend([f for f in sysos[imp.resolved] if f not in pkg_sysos])
+    sysos[path] = pkg_sysos
+
+    # In cgo packages, assembly files are compiled with the C compiler,
+    # except in runtime/cgo, where only the gcc_ files are.
+    asm_files = pkg.s_files
+    gcc_files = pkg.c_files
+    if pkg.cgo_files:
+        if path == "runtime/cgo":
+            asm_files = [name for name in pkg.s_files if not name.startswith("gcc_")]
+            gcc_files = gcc_files + [name for name in pkg.s_files if name.startswith("gcc_")]
+        else:
+            asm_files = []
+            gcc_files = gcc_files + pkg.s_files
+
+    gen_go_srcs = []
+    cgo_objs = []
+    if pkg.cgo_files:
+        gen_go_srcs, cgo_objs = _emit_std_cgo(go, pkg, srcs, hdrs, gcc_files, pkg_sysos)
+
+    asm_args = _std_asm_args(go, pkg, minor)
+    symabis = None
+    if asm_files and minor >= 12:
+        symabis = _emit_std_symabis(go, pkg, srcs, hdrs, asm_files, asm_args)
+
+    if asm_files or cgo_objs or own_sysos:
+        go_lib = go.declare_file(go, path = "obj/{}/_go_.a".format(path))
+    else:
+        go_lib = out_lib
+    asmhdr = None
+    if asm_files:
+        asmhdr = go.declare_file(go, path = "obj/{}/go_asm.h".format(path))
+
+    builder_args = go.builder_args(go)
+    builder_args.add_all(go_srcs + gen_go_srcs, before_each = "-src")
+    builder_args.add_all([
+        "{}={}={}".format(imp.path, imp.resolved, archives[imp.resolved].path)
+        for imp in pkg.imports
+    ], before_each = "-arc")
+    builder_args.add("-o", go_lib)
+    builder_args.add("-p", path)
+    tool_args = go.tool_args(go)
+    tool_args.add("-std")
+    if minor < 22 and _is_runtime_package(path):
+        tool_args.add("-+")
+    if go.mode.race:
+        tool_args.add("-race")
+    if go.mode.msan:
+        tool_args.add("-msan")
+    outputs = [go_lib]
+    inputs = go_srcs + gen_go_srcs + deps + go.sdk.tools
+    if asmhdr:
+        tool_args.add("-asmhdr", asmhdr)
+        outputs.append(asmhdr)
+    if symabis:
+        tool_args.add("-symabis", symabis)
+        inputs.append(symabis)
+    tool_args.add("-trimpath", ".")
+    tool_args.add_all(go.toolchain.flags.stdlib_compile)
+    go.actions.run(
+        inputs = inputs,
+        outputs = outputs,
+        mnemonic = "GoCompile",
+        executable = go.builders.compile,
+        arguments = [builder_args, "--", tool_args],
+        env = go.env,
+    )
+    if go_lib == out_lib:
+        return out_lib
+
+    asm_objs = []
+    for name in asm_files:
+        src = _std_src(srcs, path, name)
+        obj = go.declare_file(go, path = "obj/{}/{}.o".format(path, name[:-len(".s")]))
+        args = go.builder_args(go)
+        args.add(src)
+        args.add("--")
+        args.add("-I", asmhdr.dirname)
+        args.add_all(asm_args)
+        args.add("-o", obj)
+        go.actions.run(
+            inputs = [src, asmhdr] + hdrs + go.sdk.headers + go.sdk.tools,
+            outputs = [obj],
+            mnemonic = "GoAsm",
+            executable = go.builders.asm,
+            arguments = [args],
+            env = go.env,
+        )
+        asm_objs.append(obj)
+    go.pack(
+        go,
+        in_lib = go_lib,
+        out_lib = out_lib,
+        objects = asm_objs + cgo_objs + own_sysos,
+    )
+    return out_lib
+
+def _std_src(srcs, path, name):
+    return srcs["{}/{}".format(path, name)]
+
+def _is_runtime_package(path):
+    # Before Go 1.22, "go build" compiled these packages with -+. Newer
+    # compilers tell from -std and -p which packages are part of the runtime.
+    return (path in ("runtime", "internal/abi", "internal/bytealg", "internal/cpu") or
+            path.startswith("runtime/internal/"))
+
+def _is_runtime_asm_package(path):
+    # From Go 1.16 until Go 1.22, "go build" assembled these packages with
+    # -compiling-runtime. Newer assemblers tell from -p.
+    return (path in ("runtime", "syscall", "internal/bytealg") or
+            path.startswith("runtime/internal/"))
+
+def _std_asm_args(go, pkg, minor):
+    """Returns assembler flags for a package, after the -I flag for the
+    directory containing go_asm.h."""
+    args = [
+        "-I",
+        go.sdk.root_file.dirname + "/pkg/include",
+        "-trimpath",
+        ".",
+        "-D",
+        "GOOS_" + go.mode.goos,
+        "-D",
+        "GOARCH_" + go.mode.goarch,
+    ]
+    if minor >= 19:
+        args.extend(["-p", pkg.path])
+    if minor >= 23:
+        args.append("-std")
+    if minor >= 16 and minor < 22 and _is_runtime_asm_package(pkg.path):
+        args.append("-compiling-runtime")
+    return args + go.toolchain.flags.stdlib_asm
+
+def _emit_std_symabis(go, pkg, srcs, hdrs, asm_files, asm_args):
+    """Emits an action that writes the ABIs of symbols defined in assembly
+    files, so the compiler can generate wrappers for them.
+
+    Like "go build", this runs before go_asm.h exists, so an empty go_asm.h
+    is included instead.
+    """
+    empty_asmhdr = go.declare_file(go, path = "obj/{}/symabis_hdr/go_asm.h".format(pkg.path))
+    go.actions.write(empty_asmhdr, "")
+    symabis = go.declare_file(go, path = "obj/{}/symabis".format(pkg.path))
+    asm_srcs = [_std_src(srcs, pkg.path, name) for name in asm_files]
+    args = go.builder_args(go)
+    args.add_all(asm_srcs)
+    args.add("--")
+    args.add("-I", empty_asmhdr.dirname)
+    args.add_all(asm_args)
+    args.add("-gensymabis")
+    args.add("-o", symabis)
+    go.actions.run(
+        inputs = asm_srcs + [empty_asmhdr] + hdrs + go.sdk.headers + go.sdk.tools,
+        outputs = [symabis],
+        mnemonic = "GoSymabis",
+        executable = go.builders.asm,
+        arguments = [args],
+        env = go.env,
+    )
+    return symabis
+
+def _emit_std_cgo(go, pkg, srcs, hdrs, gcc_files, pkg_sysos):
+    """Emits an action that runs cgo and the C compiler on a package.
+
+    Returns the generated Go files and the C objects to pack into the
+    archive.
+    """
+    path = pkg.path
+    objdir = "obj/{}/cgo".format(path)
+    gen_go_srcs = [go.declare_file(go, path = objdir + "/_cgo_gotypes.go")]
+    gen_go_srcs.append(go.declare_file(go, path = objdir + "/_cgo_import.go"))
+    objs = [go.declare_file(go, path = objdir + "/_cgo_export.o")]
+    for name in pkg.cgo_files:
+        stem = name[:-len(".go")]
+        gen_go_srcs.append(go.declare_file(go, path = "{}/{}.cgo1.go".format(objdir, stem)))
+        objs.append(go.declare_file(go, path = "{}/{}.cgo2.o".format(objdir, stem)))
+    for name in gcc_files:
+        stem = name[:name.rindex(".")]
+        objs.append(go.declare_file(go, path = "{}/{}.o".format(objdir, stem)))
+
+    cgo_srcs = [_std_src(srcs, path, name) for name in pkg.cgo_files]
+    gcc_srcs = [_std_src(srcs, path, name) for name in gcc_files]
+    cflags = list(pkg.cflags)
+    ldflags = list(pkg.ldflags)
+    if go.mode.msan:
+        cflags.append("-fsanitize=memory")
+        ldflags.append("-fsanitize=memory")
+    args = go.builder_args(go)
+    args.add("-stdlib", path)
+    args.add("-objdir", objs[0].dirname)
+    args.add_all(cgo_srcs, before_each = "-cgo")
+    args.add_all(gcc_srcs, before_each = "-gcc")
+    args.add_all(pkg_sysos, before_each = "-syso")
+    args.add_all(pkg.cppflags, before_each = "-cppflag")
+    args.add_all(cflags, before_each = "-cflag")
+    args.add_all(ldflags, before_each = "-ldflag")
+    env = dict(go.env)
+    env.update({
+        "CC": go.cgo_tools.c_compiler_path,
+        "CGO_CFLAGS": " ".join(go.cgo_tools.c_compile_options),
+        "CGO_LDFLAGS": " ".join(extldflags_from_cc_toolchain(go)),
+    })
+    go.actions.run(
+        inputs = cgo_srcs + gcc_srcs + hdrs + pkg_sysos + go.sdk.tools + go.crosstool,
+        outputs = gen_go_srcs + objs,
+        mnemonic = "CGoCodeGen",
+        executable = go.builders.cgo,
+        arguments = [args],
+        env = env,
+    )
+    return gen_go_srcs, objs
+
 def _build_stdlib(go, attr):
+    # "go install" builds the whole standard library in one action. This is
+    # used when the packages to build aren't known during analysis.
     pkg = go.declare_directory(go, "pkg")
     src = go.declare_directory(go, "src")
     root_file = go.declare_file(go, "ROOT")
@@ -62,6 +435,9 @@ def _build_stdlib(go, attr):
         args.add("-race")
     args.add_all(link_mode_args(go.mode))
     args.add("-filter_buildid", filter_buildid)
+    args.add_all(go.toolchain.flags.stdlib_compile, before_each = "-gcflag")
+    args.add_all(go.toolchain.flags.stdlib_asm, before_each = "-asmflag")
+    args.add_all(go.toolchain.stdlib_patches, before_each = "-patch")
     go.actions.write(root_file, "")
     env = go.env
     env.update({
@@ -73,6 +449,7 @@ def _build_stdlib(go, attr):
               go.sdk.headers +
               go.sdk.tools +
               [go.sdk.go, filter_buildid, go.sdk.package_list, go.sdk.root_file] +
+              go.toolchain.stdlib_patches +
               go.crosstool)
     outputs = [pkg, src]
     go.actions.run(
@@ -96,7 +473,7 @@ def _stdlib_impl(ctx):
 
 stdlib = go_rule(
     _stdlib_impl,
-    bootstrap = True,
+    bootstrap_attrs = ["_builders"],
     attrs = {
         "_stdlib_builder": attr.label(
             executable = True,
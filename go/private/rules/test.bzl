@@ -54,6 +54,16 @@ load(
 def _testmain_library_to_source(go, attr, source, merge):
     source["deps"] = source["deps"] + [attr.library]
 
+def _check_coverage_threshold(name, value):
+    """Fails unless value is a percentage like "80" or "72.5"."""
+    whole, dot, frac = value.partition(".")
+    valid = whole.isdigit() and (not dot or frac.isdigit())
+    if valid:
+        percent = int(whole.lstrip("0") or "0")
+        valid = percent < 100 or (percent == 100 and not frac.strip("0"))
+    if not valid:
+        fail("%s must be a number between 0 and 100; got %r" % (name, value))
+
 def _go_test_impl(ctx):
     """go_test_impl implements go testing.
 
@@ -96,6 +106,7 @@ def _go_test_impl(ctx):
     arguments = go.builder_args(go)
     arguments.add("-rundir", run_dir)
     arguments.add("-output", main_go)
+    arguments.add("-pkgname", internal_source.library.importpath)
     if ctx.configuration.coverage_enabled:
         arguments.add("-coverage")
     arguments.add(
@@ -109,8 +120,28 @@ def _go_test_impl(ctx):
         "l_test=" + external_source.library.importpath,
     )
     arguments.add_all(go_srcs, before_each = "-src", format_each = "l=%s")
+
+    if ctx.attr.compose_test_main:
+        arguments.add("-compose_test_main")
+    arguments.add_all(ctx.attr.quarantine, before_each = "-quarantine")
+    quarantine_files = []
+    if ctx.file.quarantine_file:
+        quarantine_files.append(ctx.file.quarantine_file)
+        arguments.add("-quarantine_file", ctx.file.quarantine_file)
+    if ctx.attr.quarantine or quarantine_files:
+        arguments.add("-quarantine_retries", str(ctx.attr.quarantine_retries))
+    if ctx.attr.coverage_threshold:
+        _check_coverage_threshold("coverage_threshold", ctx.attr.coverage_threshold)
+        arguments.add("-coverage_threshold", ctx.attr.coverage_threshold)
+    for pkg, threshold in sorted(ctx.attr.package_coverage_thresholds.items()):
+        _check_coverage_threshold("package_coverage_thresholds[%r]" % pkg, threshold)
+        arguments.add("-package_coverage_threshold", "%s=%s" % (pkg, threshold))
+
+    # The compiled archives are used to check the signatures of test functions.
+    arguments.add("-archive", "l=" + internal_archive.data.file.path)
+    arguments.add("-archive", "l_test=" + external_archive.data.file.path)
     ctx.actions.run(
-        inputs = go_srcs,
+        inputs = go_srcs + quarantine_files + [internal_archive.data.file, external_archive.data.file],
         outputs = [main_go],
         mnemonic = "GoTestGenTest",
         executable = go.builders.test_generator,
@@ -129,7 +160,10 @@ def _go_test_impl(ctx):
         pathtype = INFERRED_PATH,
         resolve = None,
     )
-    test_deps = external_archive.direct + [external_archive]
+    test_deps = external_archive.direct + [external_archive] + [
+        get_archive(dep)
+        for dep in ctx.attr._testmain_additional_deps
+    ]
     if ctx.configuration.coverage_enabled:
         test_deps.append(go.coverdata)
     test_source = go.library_to_source(go, struct(
@@ -232,6 +266,17 @@ go_test = go_rule(
         "rundir": attr.string(),
         "x_defs": attr.string_dict(),
         "linkmode": attr.string(default = LINKMODE_NORMAL),
+        "compose_test_main": attr.bool(),
+        "quarantine": attr.string_list(),
+        "quarantine_file": attr.label(allow_single_file = True),
+        "quarantine_retries": attr.int(default = 2),
+        "coverage_threshold": attr.string(),
+        "package_coverage_thresholds": attr.string_dict(),
+        "_testmain_additional_deps": attr.label_list(
+            providers = [GoLibrary],
+            aspects = [go_archive_aspect],
+            default = ["@io_bazel_rules_go//go/tools/bzltestutil"],
+        ),
         # Workaround for bazelbuild/bazel#6293. See comment in lcov_merger.sh.
         "_lcov_merger": attr.label(
             executable = True,
@@ -15,6 +15,10 @@
 load("@io_bazel_rules_go//go/private:rules/binary.bzl", "go_binary")
 load("@io_bazel_rules_go//go/private:rules/library.bzl", "go_library")
 load("@io_bazel_rules_go//go/private:rules/test.bzl", "go_test")
+load(
+    "@io_bazel_rules_go//go/private:rules/license.bzl",
+    "license_kinds_from_licenses",
+)
 load(
     "@io_bazel_rules_go//go/private:rules/cgo.bzl",
     "go_binary_c_archive_shared",
@@ -84,14 +88,20 @@ def _cgo(name, kwargs):
     cgo_embed = setup_cgo_library(**cgo_attrs)
     kwargs["embed"] = kwargs.get("embed", []) + [cgo_embed]
 
+def _license_kinds(kwargs):
+    if "licenses" in kwargs and "license_kinds" not in kwargs:
+        kwargs["license_kinds"] = license_kinds_from_licenses(kwargs["licenses"])
+
 def go_library_macro(name, **kwargs):
     """See go/core.rst#go_library for full documentation."""
     _cgo(name, kwargs)
+    _license_kinds(kwargs)
     go_library(name = name, **kwargs)
 
 def go_binary_macro(name, **kwargs):
     """See go/core.rst#go_binary for full documentation."""
     _cgo(name, kwargs)
+    _license_kinds(kwargs)
     go_binary(name = name, **kwargs)
     go_binary_c_archive_shared(name, kwargs)
 
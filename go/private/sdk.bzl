@@ -15,7 +15,9 @@
 load(
     "@io_bazel_rules_go//go/private:common.bzl",
     "env_execute",
+    "executable_extension",
     "executable_path",
+    "go_minor_version",
 )
 load(
     "@io_bazel_rules_go//go/private:go_toolchain.bzl",
@@ -28,6 +30,7 @@ def _go_host_sdk_impl(ctx):
     platform = _detect_sdk_platform(ctx, goroot)
     _sdk_build_file(ctx, platform)
     _local_sdk(ctx, goroot)
+    _list_std_packages(ctx)
 
 _go_host_sdk = repository_rule(
     _go_host_sdk_impl,
@@ -49,6 +52,7 @@ def _go_download_sdk_impl(ctx):
     filename, sha256 = ctx.attr.sdks[platform]
     _sdk_build_file(ctx, platform)
     _remote_sdk(ctx, [url.format(filename) for url in ctx.attr.urls], ctx.attr.strip_prefix, sha256)
+    _list_std_packages(ctx)
 
 _go_download_sdk = repository_rule(
     _go_download_sdk_impl,
@@ -70,6 +74,7 @@ def _go_local_sdk_impl(ctx):
     platform = _detect_sdk_platform(ctx, goroot)
     _sdk_build_file(ctx, platform)
     _local_sdk(ctx, goroot)
+    _list_std_packages(ctx)
 
 _go_local_sdk = repository_rule(
     _go_local_sdk_impl,
@@ -87,6 +92,7 @@ def _go_wrap_sdk_impl(ctx):
     platform = _detect_sdk_platform(ctx, goroot)
     _sdk_build_file(ctx, platform)
     _local_sdk(ctx, goroot)
+    _list_std_packages(ctx)
 
 _go_wrap_sdk = repository_rule(
     _go_wrap_sdk_impl,
@@ -134,6 +140,105 @@ def _sdk_build_file(ctx, platform):
         },
     )
 
+# Each package in the standard library is listed on one line, with fields
+# separated by ';': the package path; Go files; Go files that import "C";
+# assembly files; header files; C files; system object files; C preprocessor,
+# compiler, and linker flags from #cgo directives; resolved imports; and a map
+# from import paths in source files to resolved imports (for vendored
+# packages). Flags are separated by spaces, other lists by ','. Packages that
+# can't be built are skipped, as are packages that embed files (which only
+# the go command knows how to build).
+_STD_PACKAGE_FIELDS = (
+    "{{.ImportPath}};" +
+    "{{join .GoFiles \",\"}};" +
+    "{{join .CgoFiles \",\"}};" +
+    "{{join .SFiles \",\"}};" +
+    "{{join .HFiles \",\"}};" +
+    "{{join .CFiles \",\"}};" +
+    "{{join .SysoFiles \",\"}};" +
+    "{{join .CgoCPPFLAGS \" \"}};" +
+    "{{join .CgoCFLAGS \" \"}};" +
+    "{{join .CgoLDFLAGS \" \"}};" +
+    "{{join .Imports \",\"}};" +
+    "{{range $src, $pkg := .ImportMap}}{{$src}}={{$pkg}},{{end}}"
+)
+
+# Standard library packages are listed once for each way they may be built.
+# The stdlib rule picks the list for the mode it's building.
+_STD_PACKAGE_VARIANTS = [
+    ("pure", "0", []),
+    ("cgo", "1", []),
+    ("race", "1", ["-race"]),
+    ("msan", "1", ["-msan"]),
+]
+
+def _list_std_packages(ctx):
+    """Lists the packages in the standard library.
+
+    Packages are only listed for the platform the SDK runs on, once for each
+    variant in _STD_PACKAGE_VARIANTS, so the stdlib rule can build them with
+    one action per package. The lists and the SDK's Go version are written to
+    std_packages.bzl. Variants the SDK doesn't support are left out, and
+    nothing is listed if the SDK can't run on the host.
+    """
+    go = str(ctx.path("bin/go" + executable_extension(ctx)))
+    goroot = str(ctx.path("."))
+    version = _detect_sdk_version(ctx, go, goroot)
+    platform = _detect_sdk_platform(ctx, goroot)
+    goos, _, goarch = platform.partition("_")
+    condition = "and (or .GoFiles .CgoFiles) (not .Error)"
+    if go_minor_version(version) >= 16:
+        condition += " (not .EmbedPatterns)"
+    template = "{{if " + condition + "}}" + _STD_PACKAGE_FIELDS + "{{end}}"
+    lines = [
+        "GO_VERSION = \"{}\"".format(version),
+        "",
+        "STD_PACKAGES = {",
+    ]
+    for variant, cgo_enabled, flags in _STD_PACKAGE_VARIANTS:
+        if not version:
+            break
+        res = env_execute(
+            ctx,
+            [go, "list", "-e"] + flags + ["-f", template, "std"],
+            environment = {
+                "GOROOT": goroot,
+                "GOOS": goos,
+                "GOARCH": goarch,
+                "CGO_ENABLED": cgo_enabled,
+                "GO111MODULE": "off",
+                "GOCACHE": str(ctx.path("std_packages.cache")),
+            },
+        )
+        if res.return_code:
+            continue
+        lines.append("    \"{}_{}\": [".format(platform, variant))
+        for line in res.stdout.split("\n"):
+            if line:
+                line = line.replace("\\", "\\\\").replace("\"", "\\\"")
+                lines.append("        \"{}\",".format(line))
+        lines.append("    ],")
+    lines.append("}")
+    ctx.file("std_packages.bzl", "\n".join(lines) + "\n")
+
+def _detect_sdk_version(ctx, go, goroot):
+    """Returns the version of the SDK, like "1.11.2" or "devel", or "" if the
+    go command can't be run."""
+    res = env_execute(ctx, [go, "version"], environment = {"GOROOT": goroot})
+    if res.return_code:
+        return ""
+
+    # The output looks like "go version go1.11.2 linux/amd64" for releases
+    # and "go version devel +abcdef ..." for development versions.
+    fields = res.stdout.split(" ")
+    if len(fields) < 3:
+        return ""
+    if fields[2] == "devel":
+        return "devel"
+    if fields[2].startswith("go"):
+        return fields[2][len("go"):].strip()
+    return ""
+
 def _detect_host_platform(ctx):
     if ctx.os.name == "linux":
         host = "linux_amd64"
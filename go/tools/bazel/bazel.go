@@ -29,22 +29,26 @@ const TEST_WORKSPACE = "TEST_WORKSPACE"
 var defaultTestWorkspace = ""
 
 // Runfile returns an absolute path to the specified file in the runfiles directory of the running target.
-// It searches the current working directory, RunfilesPath() directory, and RunfilesPath()/TestWorkspace().
-// Returns an error if unable to locate RunfilesPath() or if the file does not exist.
+// It searches the current working directory, then the runfiles of the running target (see NewRunfiles)
+// for path and TestWorkspace()/path. Returns an error if unable to locate the runfiles or if the file
+// does not exist.
+//
+// New code should use Runfiles.Rlocation, which does not search the current directory or guess the
+// workspace name.
 func Runfile(path string) (string, error) {
+	path = filepath.Clean(path)
 	if _, err := os.Stat(path); err == nil {
 		// absolute path or found in current working directory
-		return path, nil
+		return filepath.Abs(path)
 	}
 
-	runfiles, err := RunfilesPath()
+	r, err := NewRunfiles()
 	if err != nil {
 		return "", err
 	}
-
-	filename := filepath.Join(runfiles, path)
-	if _, err := os.Stat(filename); err == nil {
-		// found at RunfilesPath()/path
+	rpath := filepath.ToSlash(path)
+	if filename, err := r.Rlocation(rpath); err == nil {
+		// found at runfiles/path
 		return filename, nil
 	}
 
@@ -52,10 +56,8 @@ func Runfile(path string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
-	filename = filepath.Join(runfiles, workspace, path)
-	if _, err := os.Stat(filename); err == nil {
-		// found at RunfilesPath()/TestWorkspace()/path
+	if filename, err := r.Rlocation(workspace + "/" + rpath); err == nil {
+		// found at runfiles/TestWorkspace()/path
 		return filename, nil
 	}
 
@@ -124,6 +126,10 @@ func getCandidates(prefix string, suffix string) []string {
 //
 // "pkg" indicates the relative path to the build package that contains the binary target, and
 // "binary" indicates the basename of the binary searched for.
+//
+// Deprecated: FindBinary guesses where bazel-bin and configuration-specific
+// output directories are. Add the binary to the data attribute of the test
+// and use ResolveLabel instead.
 func FindBinary(pkg string, binary string) (string, bool) {
 	candidates := getCandidates(filepath.Join("bazel-bin", pkg), binary)
 	candidates = append(candidates, getCandidates(pkg, binary)...)
@@ -161,6 +167,10 @@ func findRunfiles(workspace string, pkg string, binary string, cookie string) (s
 // build package that contains the binary target, "binary" indicates the basename of the binary
 // searched for, and "cookie" indicates an arbitrary data file that we expect to find within the
 // runfiles tree.
+//
+// Deprecated: EnterRunfiles guesses where the runfiles tree is. Use
+// NewRunfiles to find runfiles, and Runfiles.Rlocation or ResolveLabel to
+// locate files in them.
 func EnterRunfiles(workspace string, pkg string, binary string, cookie string) error {
 	runfiles, ok := findRunfiles(workspace, pkg, binary, cookie)
 	if !ok {
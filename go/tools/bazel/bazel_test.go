@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -70,15 +71,24 @@ func createPaths(paths []string) error {
 }
 
 func TestRunfile(t *testing.T) {
-	file := "go/tools/bazel/README.md"
-	runfile, err := Runfile(file)
-	if err != nil {
-		t.Errorf("When reading file %s got error %s", file, err)
+	for _, file := range []string{"go/tools/bazel/README.md", "./go/tools/bazel/README.md"} {
+		runfile, err := Runfile(file)
+		if err != nil {
+			t.Errorf("When reading file %s got error %s", file, err)
+			continue
+		}
+
+		// Check that the file actually exist
+		if !filepath.IsAbs(runfile) {
+			t.Errorf("Runfile(%q) = %q is not absolute", file, runfile)
+		}
+		if _, err := os.Stat(runfile); err != nil {
+			t.Errorf("File found by runfile doesn't exist")
+		}
 	}
 
-	// Check that the file actually exist
-	if _, err := os.Stat(runfile); err != nil {
-		t.Errorf("File found by runfile doesn't exist")
+	if runfile, err := Runfile("go/tools/bazel/missing.txt"); err == nil {
+		t.Errorf("Runfile found missing file at %q", runfile)
 	}
 }
 
@@ -0,0 +1,341 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Workspace is a Bazel workspace created by a test, usually to test rules or
+// code generators by running Bazel on a small set of files. Each Workspace
+// has its own output base, so builds don't share state with each other or
+// with the Bazel running the test.
+type Workspace struct {
+	// Dir is the root directory of the workspace.
+	Dir string
+
+	// OutputBase is passed to Bazel with --output_base.
+	OutputBase string
+
+	// Bazel is the path to the Bazel binary. NewWorkspace sets it to "bazel",
+	// which is looked up in PATH.
+	Bazel string
+
+	// StartupArgs are passed to Bazel before the command.
+	StartupArgs []string
+
+	// Env is the environment Bazel is run in, in "key=value" form. If nil,
+	// the test's environment is used.
+	Env []string
+
+	// root is the directory created by NewWorkspace, deleted by Close.
+	root string
+
+	// ran is set when a command has been run, so Close knows whether Bazel
+	// needs to be shut down.
+	ran bool
+}
+
+// NewWorkspace creates a workspace in a new directory in TestTmpDir() and
+// writes files into it. files is a txtar archive: each file starts with a
+// line like "-- WORKSPACE --" naming it, followed by its contents. Text
+// before the first file is a comment and is ignored. For example:
+//
+//	-- WORKSPACE --
+//	local_repository(name = "io_bazel_rules_go", path = "...")
+//	-- BUILD.bazel --
+//	load("@io_bazel_rules_go//go:def.bzl", "go_binary")
+//	go_binary(name = "hello", srcs = ["hello.go"])
+//	-- hello.go --
+//	package main
+//	func main() {}
+//
+// Close should be called when the workspace is no longer needed.
+func NewWorkspace(files string) (*Workspace, error) {
+	root, err := NewTmpDir("bazel_workspace")
+	if err != nil {
+		return nil, err
+	}
+	w := &Workspace{
+		Dir:        filepath.Join(root, "workspace"),
+		OutputBase: filepath.Join(root, "output_base"),
+		Bazel:      "bazel",
+		root:       root,
+	}
+	if err := os.Mkdir(w.Dir, 0777); err != nil {
+		os.RemoveAll(root)
+		return nil, err
+	}
+	if err := w.WriteFiles(files); err != nil {
+		os.RemoveAll(root)
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteFiles writes files from a txtar archive into the workspace, replacing
+// files that already exist. See NewWorkspace for the format.
+func (w *Workspace) WriteFiles(files string) error {
+	for _, f := range parseTxtar(files) {
+		if err := checkRelativeName(f.name); err != nil {
+			return fmt.Errorf("invalid file name in workspace archive: %v", err)
+		}
+		path := filepath.Join(w.Dir, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(f.data), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Result holds the output of a Bazel command run in a Workspace.
+type Result struct {
+	// Args are the arguments Bazel was run with, not including the path to
+	// the Bazel binary.
+	Args []string
+
+	Stdout, Stderr []byte
+
+	// ExitCode is the exit status of Bazel, or -1 if it could not be
+	// determined.
+	ExitCode int
+
+	// BuildEvents are the events written by Bazel with
+	// --build_event_json_file, for commands that build targets.
+	BuildEvents []BuildEvent
+}
+
+// BuildEvent is an event from the Build Event Protocol, decoded from JSON.
+// See build_event_stream.proto in the Bazel repository for the fields.
+type BuildEvent map[string]interface{}
+
+// Kind returns the kind of the event, which is the name of the only field
+// of its id, for example, "targetCompleted".
+func (e BuildEvent) Kind() string {
+	if id, ok := e["id"].(map[string]interface{}); ok {
+		for kind := range id {
+			return kind
+		}
+	}
+	return ""
+}
+
+// Label returns the label of the target the event is about, or "" if the
+// event is not about a target.
+func (e BuildEvent) Label() string {
+	if id, ok := e["id"].(map[string]interface{}); ok {
+		for _, v := range id {
+			if fields, ok := v.(map[string]interface{}); ok {
+				if label, ok := fields["label"].(string); ok {
+					return label
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// buildEventCommands lists the commands that accept --build_event_json_file.
+var buildEventCommands = map[string]bool{
+	"build":    true,
+	"coverage": true,
+	"run":      true,
+	"test":     true,
+}
+
+// Run runs a Bazel command in the workspace. The returned Result is never
+// nil, even when there's an error. Run returns an error if Bazel could not be
+// started or exited with a non-zero status.
+func (w *Workspace) Run(command string, args ...string) (*Result, error) {
+	var bepPath string
+	bazelArgs := append([]string{"--output_base=" + w.OutputBase}, w.StartupArgs...)
+	bazelArgs = append(bazelArgs, command)
+	if buildEventCommands[command] {
+		bepFile, err := ioutil.TempFile(filepath.Dir(w.OutputBase), "bep")
+		if err != nil {
+			return &Result{ExitCode: -1}, err
+		}
+		bepPath = bepFile.Name()
+		bepFile.Close()
+		defer os.Remove(bepPath)
+		bazelArgs = append(bazelArgs, "--build_event_json_file="+bepPath)
+	}
+	bazelArgs = append(bazelArgs, args...)
+
+	result := &Result{Args: bazelArgs, ExitCode: -1}
+	cmd := exec.Command(w.Bazel, bazelArgs...)
+	cmd.Dir = w.Dir
+	cmd.Env = w.Env
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	runErr := cmd.Start()
+	if runErr == nil {
+		// Close only needs to shut down the server if Bazel started.
+		w.ran = true
+		runErr = cmd.Wait()
+	}
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+	if cmd.ProcessState != nil {
+		result.ExitCode = exitCode(cmd.ProcessState)
+	}
+	if runErr != nil {
+		runErr = fmt.Errorf("bazel %s: %v\n%s", strings.Join(bazelArgs, " "), runErr, result.Stderr)
+	}
+
+	if bepPath != "" {
+		events, err := readBuildEvents(bepPath)
+		if err != nil && runErr == nil {
+			return result, err
+		}
+		result.BuildEvents = events
+	}
+	return result, runErr
+}
+
+// Events returns the build events of the given kind, for example,
+// "targetCompleted" or "testSummary".
+func (r *Result) Events(kind string) []BuildEvent {
+	var events []BuildEvent
+	for _, e := range r.BuildEvents {
+		if e.Kind() == kind {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// TargetSucceeded reports whether Bazel built the target named by label
+// successfully. found is false if there's no targetCompleted event for
+// the target.
+func (r *Result) TargetSucceeded(label string) (succeeded, found bool) {
+	for _, e := range r.Events("targetCompleted") {
+		if e.Label() != label {
+			continue
+		}
+		found = true
+		completed, _ := e["completed"].(map[string]interface{})
+		if success, _ := completed["success"].(bool); !success {
+			return false, true
+		}
+	}
+	return found, found
+}
+
+// TestStatus returns the overall status of the test named by label, for
+// example, "PASSED" or "FAILED". It returns "" if the test didn't run.
+func (r *Result) TestStatus(label string) string {
+	for _, e := range r.Events("testSummary") {
+		if e.Label() != label {
+			continue
+		}
+		if summary, ok := e["testSummary"].(map[string]interface{}); ok {
+			if status, ok := summary["overallStatus"].(string); ok {
+				return status
+			}
+		}
+	}
+	return ""
+}
+
+// Close stops the Bazel server started for the workspace, if there is one,
+// and deletes the workspace and its output base.
+func (w *Workspace) Close() error {
+	var err error
+	if w.ran {
+		// Files in the output base are read-only, so let Bazel delete it.
+		_, err = w.Run("clean", "--expunge")
+	}
+	if w.root != "" {
+		if rmErr := os.RemoveAll(w.root); err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus()
+	}
+	if state.Success() {
+		return 0
+	}
+	return -1
+}
+
+// readBuildEvents reads a file written with --build_event_json_file. Each
+// line is a JSON object describing one event.
+func readBuildEvents(path string) ([]BuildEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []BuildEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e BuildEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return events, fmt.Errorf("error reading build events from %s: %v", path, err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return events, fmt.Errorf("error reading build events from %s: %v", path, err)
+	}
+	return events, nil
+}
+
+type txtarFile struct {
+	name, data string
+}
+
+// parseTxtar parses a txtar archive. Lines of the form "-- name --" start a
+// new file. Text before the first file is ignored.
+func parseTxtar(archive string) []txtarFile {
+	var files []txtarFile
+	var cur *txtarFile
+	for _, line := range strings.SplitAfter(archive, "\n") {
+		trimmed := strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(trimmed, "-- ") && strings.HasSuffix(trimmed, " --") && len(trimmed) > len("-- --") {
+			files = append(files, txtarFile{name: strings.TrimSpace(trimmed[3 : len(trimmed)-3])})
+			cur = &files[len(files)-1]
+			continue
+		}
+		if cur != nil {
+			cur.data += line
+		}
+	}
+	return files
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeBazelEnv is set when the test binary is run as a fake Bazel.
+const fakeBazelEnv = "BAZEL_HARNESS_TEST_FAKE_BAZEL"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeBazelEnv) != "" {
+		os.Exit(fakeBazel(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeBazel pretends to be Bazel. It prints the command to stdout and the
+// working directory to stderr. For "build", it writes a targetCompleted event
+// for each target; targets named "fail" fail.
+func fakeBazel(args []string) int {
+	var command, bepPath string
+	var targets []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--build_event_json_file="):
+			bepPath = strings.TrimPrefix(arg, "--build_event_json_file=")
+		case strings.HasPrefix(arg, "-"):
+		case command == "":
+			command = arg
+		default:
+			targets = append(targets, arg)
+		}
+	}
+	fmt.Println(command)
+	wd, _ := os.Getwd()
+	fmt.Fprintln(os.Stderr, wd)
+
+	if command != "build" {
+		return 0
+	}
+	bep, err := os.Create(bepPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer bep.Close()
+	enc := json.NewEncoder(bep)
+	enc.Encode(map[string]interface{}{
+		"id": map[string]interface{}{"started": map[string]interface{}{"uuid": "fake"}},
+	})
+	exit := 0
+	for _, target := range targets {
+		completed := map[string]interface{}{}
+		if strings.HasSuffix(target, ":fail") {
+			exit = 1
+		} else {
+			completed["success"] = true
+		}
+		enc.Encode(map[string]interface{}{
+			"id":        map[string]interface{}{"targetCompleted": map[string]interface{}{"label": target}},
+			"completed": completed,
+		})
+	}
+	return exit
+}
+
+func TestParseTxtar(t *testing.T) {
+	archive := `comment
+-- WORKSPACE --
+-- pkg/BUILD.bazel --
+go_library(name = "pkg")
+-- pkg/pkg.go --
+package pkg
+
+`
+	got := parseTxtar(archive)
+	want := []txtarFile{
+		{name: "WORKSPACE"},
+		{name: "pkg/BUILD.bazel", data: "go_library(name = \"pkg\")\n"},
+		{name: "pkg/pkg.go", data: "package pkg\n\n"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestNewWorkspace(t *testing.T) {
+	w, err := NewWorkspace(`
+-- WORKSPACE --
+-- BUILD.bazel --
+filegroup(name = "ok")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Dir(w.Dir)
+	defer os.RemoveAll(root)
+	w.Bazel = os.Args[0]
+	w.Env = append(os.Environ(), fakeBazelEnv+"=1")
+
+	checkFile(t, filepath.Join(w.Dir, "BUILD.bazel"), "filegroup(name = \"ok\")\n")
+	if err := w.WriteFiles("-- sub/file.txt --\nhello\n"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(w.Dir, "sub", "file.txt"), "hello\n")
+	if err := w.WriteFiles("-- ../escape --\n"); err == nil {
+		t.Error("WriteFiles: got success for a file outside the workspace; want error")
+	}
+
+	result, err := w.Run("build", "//:ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(result.Stdout)); got != "build" {
+		t.Errorf("stdout: got %q; want %q", got, "build")
+	}
+	if got := strings.TrimSpace(string(result.Stderr)); got != w.Dir {
+		t.Errorf("stderr: got %q; want working directory %q", got, w.Dir)
+	}
+	if result.Args[0] != "--output_base="+w.OutputBase {
+		t.Errorf("args: got %q; want --output_base first", result.Args)
+	}
+	if len(result.BuildEvents) != 2 || result.BuildEvents[0].Kind() != "started" {
+		t.Errorf("build events: got %v; want started and targetCompleted events", result.BuildEvents)
+	}
+	if succeeded, found := result.TargetSucceeded("//:ok"); !succeeded || !found {
+		t.Errorf("TargetSucceeded(//:ok): got %v, %v; want true, true", succeeded, found)
+	}
+	if _, found := result.TargetSucceeded("//:other"); found {
+		t.Error("TargetSucceeded(//:other): found a target that wasn't built")
+	}
+
+	result, err = w.Run("build", "//:ok", "//:fail")
+	if err == nil {
+		t.Error("build //:fail: got success; want error")
+	}
+	if result.ExitCode != 1 {
+		t.Errorf("build //:fail: got exit code %d; want 1", result.ExitCode)
+	}
+	if succeeded, found := result.TargetSucceeded("//:fail"); succeeded || !found {
+		t.Errorf("TargetSucceeded(//:fail): got %v, %v; want false, true", succeeded, found)
+	}
+
+	result, err = w.Run("info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BuildEvents != nil {
+		t.Errorf("info: got build events %v; want none", result.BuildEvents)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("Close: workspace not deleted: %v", err)
+	}
+}
+
+func TestWorkspaceMissingBazel(t *testing.T) {
+	w, err := NewWorkspace("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Bazel = filepath.Join(w.Dir, "missing")
+	result, err := w.Run("build", "//...")
+	if err == nil {
+		t.Fatal("got success; want error")
+	}
+	if result == nil || result.ExitCode != -1 {
+		t.Errorf("got result %v; want exit code -1", result)
+	}
+	// Bazel never started, so Close has no server to stop.
+	if err := w.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestReadBuildEvents(t *testing.T) {
+	f, err := ioutil.TempFile("", "bep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintln(f, `{"id":{"testSummary":{"label":"//:t"}},"testSummary":{"overallStatus":"PASSED"}}`)
+	fmt.Fprintln(f, `not json`)
+	f.Close()
+	events, err := readBuildEvents(f.Name())
+	if err == nil {
+		t.Error("got success for malformed events; want error")
+	}
+	r := &Result{BuildEvents: events}
+	if got := r.TestStatus("//:t"); got != "PASSED" {
+		t.Errorf("TestStatus: got %q; want PASSED", got)
+	}
+}
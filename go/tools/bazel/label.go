@@ -0,0 +1,178 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ResolveLabel returns the absolute path to the file produced by a target
+// in the runfiles of the running binary or test. See Runfiles.ResolveLabel.
+func ResolveLabel(label string) (string, error) {
+	r, err := NewRunfiles()
+	if err != nil {
+		return "", err
+	}
+	return r.ResolveLabel(label)
+}
+
+// ResolveLabel returns the absolute path to the file produced by a target,
+// for example, "//cmd/server" or "@org_golang_x_tools//cmd/goimports". The
+// target must be in the runfiles, usually because it's listed in the data
+// attribute of the binary or test. Labels without a repository name and
+// labels starting with "@//" refer to the main workspace. Its name is
+// TestWorkspace if that's known. Otherwise, it's inferred from the runfiles:
+// the main workspace is the one containing the running binary.
+//
+// Source files and files generated with a declared name are found at the
+// path named by the label. Binaries built by go_binary are found in the
+// configuration-specific directory they're written to (for example,
+// cmd/server/linux_amd64_stripped/server). Only the runfiles are searched,
+// so this works the same way in every configuration. ResolveLabel returns an
+// error if the target is not found or if more than one file matches.
+func (r *Runfiles) ResolveLabel(label string) (string, error) {
+	repo, pkg, name, err := parseLabel(label)
+	if err != nil {
+		return "", err
+	}
+	if repo == "" {
+		if repo, err = r.mainWorkspace(); err != nil {
+			return "", fmt.Errorf("resolving label %s: %v", label, err)
+		}
+	}
+	dir := path.Join(repo, pkg)
+
+	// Files named directly by the label.
+	for _, rpath := range []string{path.Join(dir, name), path.Join(dir, name+".exe")} {
+		if fi, err := r.Stat(rpath); err == nil && !fi.IsDir() {
+			return r.Rlocation(rpath)
+		}
+	}
+
+	// Binaries in a subdirectory named after the build mode.
+	infos, err := r.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("target %s not found in runfiles: %v", label, err)
+	}
+	var matches []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		for _, base := range []string{name, name + ".exe"} {
+			rpath := path.Join(dir, info.Name(), base)
+			if fi, err := r.Stat(rpath); err == nil && !fi.IsDir() {
+				matches = append(matches, rpath)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("target %s not found in runfiles; is it in the data attribute?", label)
+	case 1:
+		return r.Rlocation(matches[0])
+	default:
+		return "", fmt.Errorf("target %s is ambiguous; found %s", label, strings.Join(matches, ", "))
+	}
+}
+
+// mainWorkspace returns the name of the main workspace. It's TestWorkspace
+// if TEST_WORKSPACE or a default is set. Otherwise, it's inferred from the
+// runfiles of the running binary.
+func (r *Runfiles) mainWorkspace() (string, error) {
+	if ws, err := TestWorkspace(); err == nil {
+		return ws, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	return r.inferWorkspace(exe)
+}
+
+// inferWorkspace guesses the name of the main workspace when TEST_WORKSPACE
+// isn't set, for example, in binaries started with "bazel run". A binary is
+// in its own runfiles at <workspace>/<package>/..., so the main workspace is
+// the top-level runfiles directory containing the file exe, which is the
+// binary. If exe isn't found there but the runfiles have only one top-level
+// directory, that's the main workspace.
+func (r *Runfiles) inferWorkspace(exe string) (string, error) {
+	infos, err := r.ReadDir(".")
+	if err != nil {
+		return "", err
+	}
+	var dirs []string
+	for _, info := range infos {
+		if info.IsDir() {
+			dirs = append(dirs, info.Name())
+		}
+	}
+
+	if exeInfo, err := os.Stat(exe); err == nil {
+		if abs, err := filepath.Abs(exe); err == nil {
+			// The binary's path in the workspace is a suffix of its path
+			// in the output directory, like cmd/server/linux_amd64_stripped/server.
+			elems := strings.Split(filepath.ToSlash(abs), "/")
+			for _, ws := range dirs {
+				for i := len(elems) - 1; i > 0; i-- {
+					real, err := r.Rlocation(ws + "/" + strings.Join(elems[i:], "/"))
+					if err != nil {
+						continue
+					}
+					if fi, err := os.Stat(real); err == nil && os.SameFile(fi, exeInfo) {
+						return ws, nil
+					}
+				}
+			}
+		}
+	}
+
+	if len(dirs) == 1 {
+		return dirs[0], nil
+	}
+	return "", errors.New("TEST_WORKSPACE is not set, and the main workspace could not be inferred from the runfiles")
+}
+
+// parseLabel splits an absolute label into a repository name, a package, and
+// a target name. The repository name is "" for labels in the main workspace,
+// including labels starting with "@//".
+func parseLabel(label string) (repo, pkg, name string, err error) {
+	rest := label
+	if strings.HasPrefix(rest, "@") {
+		i := strings.Index(rest, "//")
+		if i < 0 {
+			return "", "", "", fmt.Errorf("invalid label %q", label)
+		}
+		repo, rest = rest[1:i], rest[i:]
+	}
+	if !strings.HasPrefix(rest, "//") {
+		return "", "", "", fmt.Errorf("invalid label %q: must be absolute, starting with // or @", label)
+	}
+	rest = rest[2:]
+	if i := strings.Index(rest, ":"); i >= 0 {
+		pkg, name = rest[:i], rest[i+1:]
+	} else {
+		pkg, name = rest, path.Base(rest)
+	}
+	if pkg != "" && checkRelativeName(pkg) != nil || name == "." || checkRelativeName(name) != nil {
+		return "", "", "", fmt.Errorf("invalid label %q", label)
+	}
+	return repo, pkg, name, nil
+}
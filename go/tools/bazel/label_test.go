@@ -0,0 +1,202 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLabel(t *testing.T) {
+	for _, tc := range []struct {
+		label, repo, pkg, name string
+		wantErr                bool
+	}{
+		{label: "//cmd/server", pkg: "cmd/server", name: "server"},
+		{label: "//cmd/server:server", pkg: "cmd/server", name: "server"},
+		{label: "//:data.txt", name: "data.txt"},
+		{label: "@org_golang_x_tools//cmd/goimports", repo: "org_golang_x_tools", pkg: "cmd/goimports", name: "goimports"},
+		{label: "@repo//pkg:testdata/file.txt", repo: "repo", pkg: "pkg", name: "testdata/file.txt"},
+		{label: ":server", wantErr: true},
+		{label: "cmd/server", wantErr: true},
+		{label: "//", wantErr: true},
+		{label: "@//pkg", pkg: "pkg", name: "pkg"},
+		{label: "@//:data.txt", name: "data.txt"},
+		{label: "@", wantErr: true},
+		{label: "@repo", wantErr: true},
+		{label: "//pkg:../x", wantErr: true},
+	} {
+		repo, pkg, name, err := parseLabel(tc.label)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseLabel(%q): got success; want error", tc.label)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLabel(%q): %v", tc.label, err)
+		} else if repo != tc.repo || pkg != tc.pkg || name != tc.name {
+			t.Errorf("parseLabel(%q): got %q, %q, %q; want %q, %q, %q", tc.label, repo, pkg, name, tc.repo, tc.pkg, tc.name)
+		}
+	}
+}
+
+func TestResolveLabel(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "label_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer setTestEnv(t, map[string]string{TEST_WORKSPACE: "main"})()
+
+	// Build the same runfiles as a directory and as a manifest.
+	runfilesDir := filepath.Join(tmpDir, "bin.runfiles")
+	manifest := &bytes.Buffer{}
+	for _, rpath := range []string{
+		"main/cmd/server/linux_amd64_stripped/server",
+		"main/cmd/ambiguous/linux_amd64_stripped/ambiguous",
+		"main/cmd/ambiguous/linux_amd64_pure_stripped/ambiguous",
+		"main/pkg/data.txt",
+		"other/tools/tool",
+	} {
+		real := filepath.Join(runfilesDir, filepath.FromSlash(rpath))
+		if err := os.MkdirAll(filepath.Dir(real), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(real, nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(manifest, "%s %s\n", rpath, real)
+	}
+	manifestPath := filepath.Join(tmpDir, "bin.runfiles_manifest")
+	if err := ioutil.WriteFile(manifestPath, manifest.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fromDir, err := NewRunfilesFromDir(runfilesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromManifest, err := NewRunfilesFromManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []*Runfiles{fromDir, fromManifest} {
+		for _, tc := range []struct {
+			label, want string
+			wantErr     bool
+		}{
+			{label: "//cmd/server", want: "main/cmd/server/linux_amd64_stripped/server"},
+			{label: "@main//cmd/server:server", want: "main/cmd/server/linux_amd64_stripped/server"},
+			{label: "//pkg:data.txt", want: "main/pkg/data.txt"},
+			{label: "@//pkg:data.txt", want: "main/pkg/data.txt"},
+			{label: "@other//tools:tool", want: "other/tools/tool"},
+			{label: "//cmd/ambiguous", wantErr: true},
+			{label: "//cmd/missing", wantErr: true},
+			{label: "//pkg:missing.txt", wantErr: true},
+		} {
+			got, err := r.ResolveLabel(tc.label)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ResolveLabel(%q): got %q; want error", tc.label, got)
+				}
+				continue
+			}
+			want := filepath.Join(runfilesDir, filepath.FromSlash(tc.want))
+			if err != nil {
+				t.Errorf("ResolveLabel(%q): %v", tc.label, err)
+			} else if got != want {
+				t.Errorf("ResolveLabel(%q): got %q; want %q", tc.label, got, want)
+			}
+		}
+	}
+}
+
+func TestInferWorkspace(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "label_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The binary is linked into its runfiles in the main workspace. Another
+	// repository has a file with the same name.
+	exe := filepath.Join(tmpDir, "bazel-bin", "cmd", "server", "linux_amd64_stripped", "server")
+	other := filepath.Join(tmpDir, "other_server")
+	for _, name := range []string{exe, other} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runfilesDir := filepath.Join(tmpDir, "bin.runfiles")
+	manifest := &bytes.Buffer{}
+	for rpath, real := range map[string]string{
+		"main/cmd/server/linux_amd64_stripped/server": exe,
+		"ext/linux_amd64_stripped/server":             other,
+	} {
+		link := filepath.Join(runfilesDir, filepath.FromSlash(rpath))
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(real, link); err != nil {
+			t.Skip(err)
+		}
+		fmt.Fprintf(manifest, "%s %s\n", rpath, real)
+	}
+	manifestPath := filepath.Join(tmpDir, "bin.runfiles_manifest")
+	if err := ioutil.WriteFile(manifestPath, manifest.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fromDir, err := NewRunfilesFromDir(runfilesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromManifest, err := NewRunfilesFromManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []*Runfiles{fromDir, fromManifest} {
+		if got, err := r.inferWorkspace(exe); err != nil {
+			t.Error(err)
+		} else if got != "main" {
+			t.Errorf("got %q; want %q", got, "main")
+		}
+		if got, err := r.inferWorkspace(filepath.Join(tmpDir, "missing")); err == nil {
+			t.Errorf("got %q for a binary not in the runfiles; want error", got)
+		}
+	}
+
+	// With one top-level directory, that's the main workspace.
+	single, err := NewRunfilesFromDir(runfilesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(runfilesDir, "ext")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := single.inferWorkspace(filepath.Join(tmpDir, "missing")); err != nil {
+		t.Error(err)
+	} else if got != "main" {
+		t.Errorf("got %q; want %q", got, "main")
+	}
+}
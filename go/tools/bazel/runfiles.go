@@ -0,0 +1,248 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const RUNFILES_MANIFEST_FILE = "RUNFILES_MANIFEST_FILE"
+const RUNFILES_DIR = "RUNFILES_DIR"
+
+// Runfiles provides access to the runfiles of a Bazel binary or test.
+//
+// Runfiles are found either through a manifest file, which maps runfile
+// paths to real paths, or through a directory tree containing the runfiles.
+// Manifests are used on platforms without symbolic links (like Windows) and
+// when Bazel is run with --noenable_runfiles or --nobuild_runfile_links.
+type Runfiles struct {
+	// manifestPath is the path to the manifest file, or "" if runfiles are
+	// found through dir.
+	manifestPath string
+
+	// manifest maps runfile paths to real paths. It's nil if runfiles are
+	// found through dir.
+	manifest map[string]string
+
+	// dir is the absolute path to the runfiles directory. It may be set
+	// along with manifestPath if the directory is known.
+	dir string
+}
+
+// NewRunfiles returns a Runfiles for the running binary or test.
+//
+// It first checks the environment: RUNFILES_MANIFEST_FILE, then RUNFILES_DIR,
+// then TEST_SRCDIR. These are set by "bazel test", and they're passed to child
+// processes by Env. If none are set, NewRunfiles looks for a manifest or
+// a directory next to the binary, based on os.Args[0]. This is how runfiles are
+// found for binaries started with "bazel run" or from bazel-bin.
+func NewRunfiles() (*Runfiles, error) {
+	return newRunfiles(os.Getenv, os.Args[0])
+}
+
+// NewRunfilesFromManifest returns a Runfiles that looks up runfiles in
+// the manifest file at manifestPath.
+func NewRunfilesFromManifest(manifestPath string) (*Runfiles, error) {
+	manifestPath, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	r := &Runfiles{manifestPath: manifestPath, manifest: manifest}
+	switch {
+	case strings.HasSuffix(manifestPath, ".runfiles_manifest"):
+		r.dir = strings.TrimSuffix(manifestPath, "_manifest")
+	case filepath.Base(manifestPath) == "MANIFEST":
+		r.dir = filepath.Dir(manifestPath)
+	}
+	if r.dir != "" {
+		if fi, err := os.Stat(r.dir); err != nil || !fi.IsDir() {
+			r.dir = ""
+		}
+	}
+	return r, nil
+}
+
+// NewRunfilesFromDir returns a Runfiles that looks up runfiles in the
+// directory dir.
+func NewRunfilesFromDir(dir string) (*Runfiles, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("runfiles directory %s is not a directory", dir)
+	}
+	return &Runfiles{dir: dir}, nil
+}
+
+func newRunfiles(getenv func(string) string, argv0 string) (*Runfiles, error) {
+	if manifest := getenv(RUNFILES_MANIFEST_FILE); manifest != "" {
+		return NewRunfilesFromManifest(manifest)
+	}
+	for _, key := range []string{RUNFILES_DIR, TEST_SRCDIR} {
+		if dir := getenv(key); dir != "" {
+			return NewRunfilesFromDir(dir)
+		}
+	}
+	if argv0 != "" {
+		// Manifests are preferred, since the directory may not contain links
+		// to the runfiles when Bazel is run with --nobuild_runfile_links.
+		for _, manifest := range []string{argv0 + ".runfiles_manifest", filepath.Join(argv0+".runfiles", "MANIFEST")} {
+			if isFile(manifest) {
+				return NewRunfilesFromManifest(manifest)
+			}
+		}
+		if isDir(argv0 + ".runfiles") {
+			return NewRunfilesFromDir(argv0 + ".runfiles")
+		}
+	}
+	return nil, errors.New("unable to find runfiles: none of RUNFILES_MANIFEST_FILE, RUNFILES_DIR, or TEST_SRCDIR is set, and no runfiles were found next to the binary")
+}
+
+// Rlocation returns the absolute path to a runfile. The path must start with
+// the name of the workspace the file belongs to, for example,
+// "io_bazel_rules_go/go/tools/bazel/README.md". Paths use forward slashes on
+// all platforms and are cleaned, so "./" and "dir/../" elements are allowed
+// as long as the path stays within the runfiles. If the path is absolute,
+// it's cleaned and returned without being looked up.
+//
+// Rlocation returns an error if the path is malformed or the file it refers
+// to does not exist.
+func (r *Runfiles) Rlocation(rpath string) (string, error) {
+	if rpath == "" {
+		return "", errors.New("runfile path is empty")
+	}
+	var real string
+	if filepath.IsAbs(rpath) {
+		real = filepath.Clean(rpath)
+	} else {
+		rpath = path.Clean(rpath)
+		if rpath == "." || rpath == ".." || strings.HasPrefix(rpath, "../") || path.IsAbs(rpath) {
+			return "", fmt.Errorf("runfile path %q is outside the runfiles", rpath)
+		}
+		var err error
+		if real, err = r.realPath(rpath); err != nil {
+			return "", err
+		}
+	}
+	if _, err := os.Stat(real); err != nil {
+		return "", fmt.Errorf("runfile %s not found: %v", rpath, err)
+	}
+	return real, nil
+}
+
+// realPath returns the real path of a runfile, given a clean, relative runfile
+// path. It doesn't check whether the real path exists.
+//
+// Manifests only list files, so when a path isn't in the manifest and the
+// runfiles directory is known, the path is looked up in the directory. This
+// finds directories like "repo/pkg/testdata".
+func (r *Runfiles) realPath(rpath string) (string, error) {
+	if r.manifest == nil {
+		return filepath.Join(r.dir, filepath.FromSlash(rpath)), nil
+	}
+	if real, ok := r.manifest[rpath]; ok {
+		if real == "" {
+			// Empty files generated by Bazel have no real path.
+			return "", fmt.Errorf("runfile %s is an empty file generated by Bazel", rpath)
+		}
+		return real, nil
+	}
+	// Manifests may list directories (tree artifacts) instead of the
+	// files they contain.
+	for prefix := rpath; ; {
+		i := strings.LastIndex(prefix, "/")
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+		if real, ok := r.manifest[prefix]; ok && real != "" {
+			return filepath.Join(real, filepath.FromSlash(rpath[i+1:])), nil
+		}
+	}
+	if r.dir != "" {
+		return filepath.Join(r.dir, filepath.FromSlash(rpath)), nil
+	}
+	return "", fmt.Errorf("runfile %s not found in manifest %s", rpath, r.manifestPath)
+}
+
+// Env returns environment variables that let child processes find the same
+// runfiles, in "key=value" form. Append them to the environment of a command,
+// for example, exec.Cmd.Env.
+func (r *Runfiles) Env() []string {
+	var env []string
+	if r.manifestPath != "" {
+		env = append(env, RUNFILES_MANIFEST_FILE+"="+r.manifestPath)
+	}
+	if r.dir != "" {
+		env = append(env, RUNFILES_DIR+"="+r.dir)
+		// JAVA_RUNFILES is used by some tools and older runfiles libraries
+		// in other languages.
+		env = append(env, "JAVA_RUNFILES="+r.dir)
+	}
+	return env
+}
+
+// readManifest parses a runfiles manifest. Each line contains a runfile path
+// and a real path, separated by a space. Empty files generated by Bazel have
+// no real path.
+func readManifest(manifestPath string) (map[string]string, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if i := strings.Index(line, " "); i >= 0 {
+			manifest[line[:i]] = line[i+1:]
+		} else {
+			manifest[line] = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading runfiles manifest %s: %v", manifestPath, err)
+	}
+	return manifest, nil
+}
+
+func isFile(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func isDir(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.IsDir()
+}
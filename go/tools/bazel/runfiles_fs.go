@@ -0,0 +1,242 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The methods in this file treat the runfiles as a read-only file system.
+// Names are runfile paths, as accepted by Rlocation, for example,
+// "io_bazel_rules_go/go/tools/bazel". The root of the runfiles is ".".
+// They work the same way whether runfiles are found through a directory or
+// a manifest. With a manifest, directories are inferred from the paths of
+// the files listed in it.
+
+// Open opens the named runfile for reading. Directories can't be opened;
+// use ReadDir to list them.
+func (r *Runfiles) Open(name string) (*os.File, error) {
+	real, fi, err := r.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: fmt.Errorf("is a directory")}
+	}
+	if real == "" {
+		// Empty files generated by Bazel have no real path.
+		return os.Open(os.DevNull)
+	}
+	return os.Open(real)
+}
+
+// Stat returns information about the named runfile or directory. Symbolic
+// links in the runfiles tree are followed.
+func (r *Runfiles) Stat(name string) (os.FileInfo, error) {
+	_, fi, err := r.lookup("stat", name)
+	return fi, err
+}
+
+// ReadDir returns the entries of the named runfiles directory, sorted by
+// name. Symbolic links in the runfiles tree are followed.
+func (r *Runfiles) ReadDir(name string) ([]os.FileInfo, error) {
+	real, fi, err := r.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	if real != "" {
+		return readRealDir(real)
+	}
+
+	// The directory is only known from the manifest. List the files and
+	// directories directly inside it.
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+	// children maps the name of each child to whether it's listed in the
+	// manifest itself. Children that aren't are directories.
+	children := map[string]bool{}
+	for rpath := range r.manifest {
+		if !strings.HasPrefix(rpath, prefix) {
+			continue
+		}
+		child := rpath[len(prefix):]
+		listed := true
+		if i := strings.Index(child, "/"); i >= 0 {
+			child = child[:i]
+			listed = false
+		}
+		children[child] = children[child] || listed
+	}
+	infos := make([]os.FileInfo, 0, len(children))
+	for child, listed := range children {
+		if !listed {
+			infos = append(infos, manifestDirInfo(child))
+			continue
+		}
+		_, childInfo, err := r.lookup("readdir", prefix+child)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, childInfo)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// Walk walks the runfiles tree rooted at root, calling walkFn for each file
+// or directory, including root. Files are walked in lexical order. Paths
+// passed to walkFn are runfile paths that start with root. Like filepath.Walk,
+// walkFn may return filepath.SkipDir to skip a directory.
+func (r *Runfiles) Walk(root string, walkFn filepath.WalkFunc) error {
+	fi, err := r.Stat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = r.walk(root, fi, walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (r *Runfiles) walk(name string, fi os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !fi.IsDir() {
+		return walkFn(name, fi, nil)
+	}
+	infos, err := r.ReadDir(name)
+	err1 := walkFn(name, fi, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, child := range infos {
+		childName := path.Join(name, child.Name())
+		if err := r.walk(childName, child, walkFn); err != nil {
+			if !child.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookup returns the real path and file information for a runfile path.
+// The real path is "" for directories that are only known from the manifest
+// and for empty files generated by Bazel. op is used in errors.
+func (r *Runfiles) lookup(op, name string) (string, os.FileInfo, error) {
+	if name == "" || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return "", nil, &os.PathError{Op: op, Path: name, Err: fmt.Errorf("invalid runfile path")}
+	}
+
+	if r.manifest == nil {
+		real := filepath.Join(r.dir, filepath.FromSlash(name))
+		fi, err := os.Stat(real)
+		if err != nil {
+			return "", nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+		return real, fi, nil
+	}
+
+	if name == "." {
+		return "", manifestDirInfo("."), nil
+	}
+	if real, ok := r.manifest[name]; ok && real == "" {
+		return "", emptyFileInfo(path.Base(name)), nil
+	}
+	if real, err := r.Rlocation(name); err == nil {
+		fi, err := os.Stat(real)
+		if err != nil {
+			return "", nil, &os.PathError{Op: op, Path: name, Err: err}
+		}
+		if base := path.Base(name); fi.Name() != base {
+			// Runfiles may have different names than the files they map to.
+			fi = renamedFileInfo{FileInfo: fi, name: base}
+		}
+		return real, fi, nil
+	}
+	prefix := name + "/"
+	for rpath := range r.manifest {
+		if strings.HasPrefix(rpath, prefix) {
+			return "", manifestDirInfo(path.Base(name)), nil
+		}
+	}
+	return "", nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// readRealDir lists a directory on disk, following symbolic links, since
+// runfiles trees are made of links.
+func readRealDir(dir string) ([]os.FileInfo, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, fi)
+	}
+	return infos, nil
+}
+
+// syntheticFileInfo describes a directory or an empty file that appears in
+// a runfiles manifest but doesn't exist on disk.
+type syntheticFileInfo struct {
+	name string
+	mode os.FileMode
+}
+
+func manifestDirInfo(name string) os.FileInfo {
+	return syntheticFileInfo{name: name, mode: os.ModeDir | 0555}
+}
+
+func emptyFileInfo(name string) os.FileInfo {
+	return syntheticFileInfo{name: name, mode: 0444}
+}
+
+func (fi syntheticFileInfo) Name() string       { return fi.name }
+func (fi syntheticFileInfo) Size() int64        { return 0 }
+func (fi syntheticFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi syntheticFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi syntheticFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi syntheticFileInfo) Sys() interface{}   { return nil }
+
+// renamedFileInfo describes a file on disk with a runfile name that differs
+// from its real name.
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi renamedFileInfo) Name() string { return fi.name }
@@ -0,0 +1,124 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunfilesFS(t *testing.T) {
+	tmpDir, binary := makeRunfiles(t)
+	defer os.RemoveAll(tmpDir)
+
+	fromManifest, err := NewRunfilesFromManifest(binary + ".runfiles_manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromDir, err := NewRunfilesFromDir(binary + ".runfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		r    *Runfiles
+	}{
+		{"manifest", fromManifest},
+		{"dir", fromDir},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []string
+			err := tc.r.Walk(".", func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					got = append(got, path+"/")
+				} else {
+					got = append(got, fmt.Sprintf("%s %d", path, info.Size()))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{
+				"./",
+				"repo/",
+				"repo/pkg/",
+				"repo/pkg/__init__.py 0",
+				"repo/pkg/file.txt 4",
+				"repo/pkg/tree/",
+				"repo/pkg/tree/leaf.txt 4",
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Walk: got %q; want %q", got, want)
+			}
+
+			infos, err := tc.r.ReadDir("repo/pkg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, fi := range infos {
+				names = append(names, fi.Name())
+			}
+			if wantNames := []string{"__init__.py", "file.txt", "tree"}; !reflect.DeepEqual(names, wantNames) {
+				t.Errorf("ReadDir: got %q; want %q", names, wantNames)
+			}
+
+			f, err := tc.r.Open("repo/pkg/tree/leaf.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "data" {
+				t.Errorf("Open: got %q; want %q", data, "data")
+			}
+
+			if _, err := tc.r.Open("repo/pkg"); err == nil {
+				t.Error("Open: got success for a directory; want error")
+			}
+			if _, err := tc.r.Stat("repo/missing"); !os.IsNotExist(err) {
+				t.Errorf("Stat: got %v for a missing file; want a not-exist error", err)
+			}
+
+			// Walk skips directories when asked.
+			var walked []string
+			tc.r.Walk("repo", func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				walked = append(walked, path)
+				if path == "repo/pkg/tree" {
+					return filepath.SkipDir
+				}
+				return nil
+			})
+			if wantWalked := []string{"repo", "repo/pkg", "repo/pkg/__init__.py", "repo/pkg/file.txt", "repo/pkg/tree"}; !reflect.DeepEqual(walked, wantWalked) {
+				t.Errorf("Walk with SkipDir: got %q; want %q", walked, wantWalked)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunfilesRlocation(t *testing.T) {
+	workspace, err := TestWorkspace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRunfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := r.Rlocation(workspace + "/go/tools/bazel/README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("file found by Rlocation doesn't exist: %v", err)
+	}
+}
+
+// makeRunfiles creates a runfiles directory and a manifest for a fake binary
+// in a temporary directory. Both contain the same runfiles. It returns the
+// temporary directory and the binary path.
+func makeRunfiles(t *testing.T) (tmpDir, binary string) {
+	tmpDir, err := ioutil.TempDir("", "runfiles_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary = filepath.Join(tmpDir, "bin")
+	runfilesDir := binary + ".runfiles"
+	dataDir := filepath.Join(tmpDir, "data")
+	for _, dir := range []string{
+		filepath.Join(runfilesDir, "repo", "pkg", "tree"),
+		filepath.Join(dataDir, "tree"),
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	realFile := filepath.Join(dataDir, "file.txt")
+	for _, name := range []string{
+		realFile,
+		filepath.Join(dataDir, "tree", "leaf.txt"),
+		filepath.Join(runfilesDir, "repo", "pkg", "file.txt"),
+		filepath.Join(runfilesDir, "repo", "pkg", "tree", "leaf.txt"),
+	} {
+		if err := ioutil.WriteFile(name, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(runfilesDir, "repo", "pkg", "__init__.py"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := fmt.Sprintf("repo/pkg/file.txt %s\nrepo/pkg/tree %s\nrepo/pkg/__init__.py\n", realFile, filepath.Join(dataDir, "tree"))
+	if err := ioutil.WriteFile(binary+".runfiles_manifest", []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return tmpDir, binary
+}
+
+func TestRunfilesFromManifest(t *testing.T) {
+	tmpDir, binary := makeRunfiles(t)
+	defer os.RemoveAll(tmpDir)
+
+	r, err := NewRunfilesFromManifest(binary + ".runfiles_manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		rpath, want string
+		wantErr     bool
+	}{
+		{rpath: "repo/pkg/file.txt", want: filepath.Join(tmpDir, "data", "file.txt")},
+		{rpath: "repo/pkg/tree/leaf.txt", want: filepath.Join(tmpDir, "data", "tree", "leaf.txt")},
+		{rpath: "repo/pkg/__init__.py", wantErr: true},
+		{rpath: "repo/pkg/missing.txt", wantErr: true},
+		{rpath: "repo/../repo/pkg/file.txt", want: filepath.Join(tmpDir, "data", "file.txt")},
+		{rpath: "./repo/pkg/file.txt", want: filepath.Join(tmpDir, "data", "file.txt")},
+		{rpath: "repo/pkg/tree/missing.txt", wantErr: true},
+		// Directories aren't listed in the manifest, so they're found in
+		// the runfiles directory.
+		{rpath: "repo/pkg", want: filepath.Join(binary+".runfiles", "repo", "pkg")},
+		{rpath: "repo/pkg/", want: filepath.Join(binary+".runfiles", "repo", "pkg")},
+		{rpath: "../repo/pkg/file.txt", wantErr: true},
+		{rpath: "repo/../..", wantErr: true},
+		{rpath: ".", wantErr: true},
+		{rpath: filepath.Join(tmpDir, "data", ".", "file.txt"), want: filepath.Join(tmpDir, "data", "file.txt")},
+		{rpath: filepath.Join(tmpDir, "data", "missing.txt"), wantErr: true},
+		{rpath: "", wantErr: true},
+	} {
+		got, err := r.Rlocation(tc.rpath)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("Rlocation(%q): got %q; want error", tc.rpath, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Rlocation(%q): %v", tc.rpath, err)
+		} else if got != tc.want {
+			t.Errorf("Rlocation(%q): got %q; want %q", tc.rpath, got, tc.want)
+		}
+	}
+
+	wantEnv := []string{
+		"RUNFILES_MANIFEST_FILE=" + binary + ".runfiles_manifest",
+		"RUNFILES_DIR=" + binary + ".runfiles",
+		"JAVA_RUNFILES=" + binary + ".runfiles",
+	}
+	if got := r.Env(); !reflect.DeepEqual(got, wantEnv) {
+		t.Errorf("Env: got %q; want %q", got, wantEnv)
+	}
+}
+
+func TestRunfilesFromManifestWithoutDir(t *testing.T) {
+	tmpDir, binary := makeRunfiles(t)
+	defer os.RemoveAll(tmpDir)
+
+	// The manifest's name doesn't tell where the runfiles directory is, so
+	// only files in the manifest are found.
+	manifest := filepath.Join(tmpDir, "manifest")
+	if err := os.Rename(binary+".runfiles_manifest", manifest); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRunfilesFromManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Rlocation("repo/pkg/file.txt"); err != nil {
+		t.Error(err)
+	} else if want := filepath.Join(tmpDir, "data", "file.txt"); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, err := r.Rlocation("repo/pkg"); err == nil {
+		t.Errorf("got %q for a directory not in the manifest; want error", got)
+	}
+}
+
+func TestRunfilesFromDir(t *testing.T) {
+	tmpDir, binary := makeRunfiles(t)
+	defer os.RemoveAll(tmpDir)
+
+	r, err := NewRunfilesFromDir(binary + ".runfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(binary+".runfiles", "repo", "pkg", "file.txt")
+	if got, err := r.Rlocation("repo/pkg/file.txt"); err != nil {
+		t.Error(err)
+	} else if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, err := r.Rlocation("repo/pkg/missing.txt"); err == nil {
+		t.Errorf("got %q for a missing file; want error", got)
+	}
+
+	wantEnv := []string{
+		"RUNFILES_DIR=" + binary + ".runfiles",
+		"JAVA_RUNFILES=" + binary + ".runfiles",
+	}
+	if got := r.Env(); !reflect.DeepEqual(got, wantEnv) {
+		t.Errorf("Env: got %q; want %q", got, wantEnv)
+	}
+}
+
+func TestNewRunfilesSources(t *testing.T) {
+	tmpDir, binary := makeRunfiles(t)
+	defer os.RemoveAll(tmpDir)
+	manifest := binary + ".runfiles_manifest"
+	dir := binary + ".runfiles"
+
+	for _, tc := range []struct {
+		desc                  string
+		env                   map[string]string
+		argv0                 string
+		wantManifest, wantDir string
+		wantErr               bool
+	}{
+		{
+			desc:         "manifest_env",
+			env:          map[string]string{RUNFILES_MANIFEST_FILE: manifest, RUNFILES_DIR: tmpDir},
+			wantManifest: manifest,
+			wantDir:      dir,
+		}, {
+			desc:    "dir_env",
+			env:     map[string]string{RUNFILES_DIR: dir, TEST_SRCDIR: tmpDir},
+			wantDir: dir,
+		}, {
+			desc:    "test_srcdir",
+			env:     map[string]string{TEST_SRCDIR: dir},
+			wantDir: dir,
+		}, {
+			desc:         "argv0",
+			argv0:        binary,
+			wantManifest: manifest,
+			wantDir:      dir,
+		}, {
+			desc:    "none",
+			argv0:   filepath.Join(tmpDir, "other"),
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			getenv := func(key string) string { return tc.env[key] }
+			r, err := newRunfiles(getenv, tc.argv0)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("got success; want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.manifestPath != tc.wantManifest || r.dir != tc.wantDir {
+				t.Errorf("got manifest %q, dir %q; want %q, %q", r.manifestPath, r.dir, tc.wantManifest, tc.wantDir)
+			}
+		})
+	}
+}
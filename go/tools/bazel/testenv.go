@@ -0,0 +1,186 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Environment variables set by "bazel test". See
+// https://docs.bazel.build/versions/master/test-encyclopedia.html.
+const TEST_UNDECLARED_OUTPUTS_DIR = "TEST_UNDECLARED_OUTPUTS_DIR"
+const TEST_UNDECLARED_OUTPUTS_ANNOTATIONS_DIR = "TEST_UNDECLARED_OUTPUTS_ANNOTATIONS_DIR"
+const TEST_WARNINGS_OUTPUT_FILE = "TEST_WARNINGS_OUTPUT_FILE"
+const TEST_PREMATURE_EXIT_FILE = "TEST_PREMATURE_EXIT_FILE"
+const TEST_INFRASTRUCTURE_FAILURE_FILE = "TEST_INFRASTRUCTURE_FAILURE_FILE"
+const TEST_SHARD_INDEX = "TEST_SHARD_INDEX"
+const TEST_TOTAL_SHARDS = "TEST_TOTAL_SHARDS"
+const TEST_SHARD_STATUS_FILE = "TEST_SHARD_STATUS_FILE"
+
+// UndeclaredOutputsDir returns the directory where a test may write
+// undeclared outputs. Bazel collects files written there into
+// test.outputs/outputs.zip in the test's testlogs directory.
+// It returns an error if TEST_UNDECLARED_OUTPUTS_DIR is not defined.
+func UndeclaredOutputsDir() (string, error) {
+	return testEnv(TEST_UNDECLARED_OUTPUTS_DIR)
+}
+
+// CreateUndeclaredOutput creates or truncates an undeclared output file.
+// name is a slash-separated path relative to UndeclaredOutputsDir();
+// missing parent directories are created.
+func CreateUndeclaredOutput(name string) (*os.File, error) {
+	dir, err := UndeclaredOutputsDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRelativeName(name); err != nil {
+		return nil, err
+	}
+	outPath := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
+		return nil, err
+	}
+	return os.Create(outPath)
+}
+
+// AddUndeclaredOutputsAnnotation appends text to the annotation file name in
+// TEST_UNDECLARED_OUTPUTS_ANNOTATIONS_DIR. Bazel concatenates all annotation
+// files into test.outputs_manifest/ANNOTATIONS, which describes the
+// undeclared outputs to people and tools reading the test logs.
+func AddUndeclaredOutputsAnnotation(name, text string) error {
+	dir, err := testEnv(TEST_UNDECLARED_OUTPUTS_ANNOTATIONS_DIR)
+	if err != nil {
+		return err
+	}
+	if err := checkRelativeName(name); err != nil {
+		return err
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("annotation name %q must not contain slashes", name)
+	}
+	// Only files ending with .part are collected.
+	return appendFile(filepath.Join(dir, name+".part"), text)
+}
+
+// AddTestWarning appends a warning to TEST_WARNINGS_OUTPUT_FILE. Bazel
+// shows the warnings in the test summary, even when the test passes.
+func AddTestWarning(warning string) error {
+	file, err := testEnv(TEST_WARNINGS_OUTPUT_FILE)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(warning, "\n") {
+		warning += "\n"
+	}
+	return appendFile(file, warning)
+}
+
+// MarkPrematureExit creates TEST_PREMATURE_EXIT_FILE. If the file still
+// exists when the test exits, Bazel reports that the test exited before it
+// finished, even if the exit status was 0. Tests should call this when they
+// start and call ClearPrematureExit just before they exit normally.
+func MarkPrematureExit() error {
+	file, err := testEnv(TEST_PREMATURE_EXIT_FILE)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, nil, 0666)
+}
+
+// ClearPrematureExit removes the file created by MarkPrematureExit.
+func ClearPrematureExit() error {
+	file, err := testEnv(TEST_PREMATURE_EXIT_FILE)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ReportInfrastructureFailure writes a message to
+// TEST_INFRASTRUCTURE_FAILURE_FILE. Tests should do this when they fail
+// because of a problem with the test environment rather than the code under
+// test, so the failure isn't blamed on the code.
+func ReportInfrastructureFailure(message string) error {
+	file, err := testEnv(TEST_INFRASTRUCTURE_FAILURE_FILE)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(message), 0666)
+}
+
+// TestShard returns the index of the shard being run and the total number of
+// shards, as set by Bazel when shard_count is set on a test. If the test is
+// not sharded, it returns 0 and 1.
+func TestShard() (index, total int) {
+	total, err := strconv.Atoi(os.Getenv(TEST_TOTAL_SHARDS))
+	if err != nil || total <= 1 {
+		return 0, 1
+	}
+	index, err = strconv.Atoi(os.Getenv(TEST_SHARD_INDEX))
+	if err != nil || index < 0 || index >= total {
+		return 0, 1
+	}
+	return index, total
+}
+
+// AcknowledgeSharding touches TEST_SHARD_STATUS_FILE to tell Bazel that the
+// test only runs its part of the cases in each shard. Tests built with
+// go_test already do this. It does nothing if the test is not sharded.
+func AcknowledgeSharding() error {
+	file := os.Getenv(TEST_SHARD_STATUS_FILE)
+	if file == "" {
+		return nil
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func testEnv(key string) (string, error) {
+	if value := os.Getenv(key); value != "" {
+		return value, nil
+	}
+	return "", fmt.Errorf("environment variable %q is not defined, are you running with bazel test", key)
+}
+
+func checkRelativeName(name string) error {
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("%q is not a normalized relative path", name)
+	}
+	return nil
+}
+
+func appendFile(name, text string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setTestEnv sets environment variables for the duration of a test. It
+// returns a function that restores the old values.
+func setTestEnv(t *testing.T, env map[string]string) func() {
+	old := map[string]*string{}
+	for key, value := range env {
+		if v, ok := os.LookupEnv(key); ok {
+			old[key] = &v
+		} else {
+			old[key] = nil
+		}
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for key, value := range old {
+			if value == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *value)
+			}
+		}
+	}
+}
+
+func TestTestEnvFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testenv_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	outputsDir := filepath.Join(tmpDir, "outputs")
+	annotationsDir := filepath.Join(tmpDir, "annotations")
+	for _, dir := range []string{outputsDir, annotationsDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	warningsFile := filepath.Join(tmpDir, "warnings")
+	prematureExitFile := filepath.Join(tmpDir, "exited_prematurely")
+	infraFailureFile := filepath.Join(tmpDir, "infrastructure_failure")
+	defer setTestEnv(t, map[string]string{
+		TEST_UNDECLARED_OUTPUTS_DIR:             outputsDir,
+		TEST_UNDECLARED_OUTPUTS_ANNOTATIONS_DIR: annotationsDir,
+		TEST_WARNINGS_OUTPUT_FILE:               warningsFile,
+		TEST_PREMATURE_EXIT_FILE:                prematureExitFile,
+		TEST_INFRASTRUCTURE_FAILURE_FILE:        infraFailureFile,
+	})()
+
+	f, err := CreateUndeclaredOutput("logs/server.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(f, "started")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(outputsDir, "logs", "server.log"), "started")
+	if _, err := CreateUndeclaredOutput("../escape"); err == nil {
+		t.Error("CreateUndeclaredOutput: got success for a path outside the directory; want error")
+	}
+
+	if err := AddUndeclaredOutputsAnnotation("server", "logs/server.log: server output\n"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(annotationsDir, "server.part"), "logs/server.log: server output\n")
+
+	for _, w := range []string{"first", "second\n"} {
+		if err := AddTestWarning(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkFile(t, warningsFile, "first\nsecond\n")
+
+	if err := MarkPrematureExit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(prematureExitFile); err != nil {
+		t.Errorf("MarkPrematureExit: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := ClearPrematureExit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(prematureExitFile); !os.IsNotExist(err) {
+		t.Errorf("ClearPrematureExit: got %v; want a not-exist error", err)
+	}
+
+	if err := ReportInfrastructureFailure("network unavailable"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, infraFailureFile, "network unavailable")
+}
+
+func TestTestEnvUnset(t *testing.T) {
+	defer setTestEnv(t, map[string]string{TEST_WARNINGS_OUTPUT_FILE: ""})()
+	if err := AddTestWarning("warning"); err == nil {
+		t.Error("got success; want error")
+	}
+}
+
+func TestTestShard(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testenv_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	statusFile := filepath.Join(tmpDir, "shard_status")
+
+	for _, tc := range []struct {
+		desc                 string
+		index, total         string
+		wantIndex, wantTotal int
+	}{
+		{desc: "unsharded", wantIndex: 0, wantTotal: 1},
+		{desc: "sharded", index: "2", total: "3", wantIndex: 2, wantTotal: 3},
+		{desc: "bad_index", index: "3", total: "3", wantIndex: 0, wantTotal: 1},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			defer setTestEnv(t, map[string]string{
+				TEST_SHARD_INDEX:  tc.index,
+				TEST_TOTAL_SHARDS: tc.total,
+			})()
+			if index, total := TestShard(); index != tc.wantIndex || total != tc.wantTotal {
+				t.Errorf("got %d, %d; want %d, %d", index, total, tc.wantIndex, tc.wantTotal)
+			}
+		})
+	}
+
+	defer setTestEnv(t, map[string]string{TEST_SHARD_STATUS_FILE: statusFile})()
+	if err := AcknowledgeSharding(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(statusFile); err != nil {
+		t.Errorf("AcknowledgeSharding: %v", err)
+	}
+}
+
+func checkFile(t *testing.T, name, want string) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Error(err)
+	} else if got := string(data); got != want {
+		t.Errorf("%s: got %q; want %q", name, got, want)
+	}
+}
@@ -17,6 +17,7 @@ package main
 import (
 	"bytes"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -25,6 +26,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
@@ -51,60 +53,119 @@ const (
 )
 
 type benchmark struct {
+	suite       string
 	desc        string
 	serverState serverState
 	cleanState  cleanState
-	incrFile    string
+	editFiles   []string
 	targets     []string
-	result      time.Duration
+	results     []time.Duration
+	profiles    []*profileSummary
 }
 
-var benchmarks = []benchmark{
-	{
-		desc:        "hello_asleep_clean",
-		serverState: asleep,
-		cleanState:  clean,
-		targets:     []string{"//:hello"},
-	}, {
-		desc:        "hello_awake_clean",
-		serverState: awake,
-		cleanState:  clean,
-		targets:     []string{"//:hello"},
-	}, {
-		desc:        "hello_asleep_incr",
-		serverState: asleep,
-		cleanState:  incr,
-		incrFile:    "hello.go",
-		targets:     []string{"//:hello"},
-	}, {
-		desc:        "hello_awake_incr",
-		serverState: awake,
-		cleanState:  incr,
-		incrFile:    "hello.go",
-		targets:     []string{"//:hello"},
-	}, {
-		desc:        "popular_repos_awake_clean",
-		serverState: awake,
-		cleanState:  clean,
-		targets:     []string{"@io_bazel_rules_go//tests/integration/popular_repos:all"},
-	},
-	// TODO: more substantial Kubernetes targets
+// config describes the benchmarks to run. It's read from a JSON file,
+// by default, benchmarks.json in this directory.
+type config struct {
+	// Runs is the number of times each benchmark is run.
+	Runs int `json:"runs"`
+
+	Suites []suiteConfig `json:"suites"`
+}
+
+type suiteConfig struct {
+	Name       string            `json:"name"`
+	Benchmarks []benchmarkConfig `json:"benchmarks"`
+}
+
+type benchmarkConfig struct {
+	Desc string `json:"desc"`
+
+	// Server is "asleep" if the Bazel server is shut down before the build
+	// is timed, or "awake" if it's left running.
+	Server string `json:"server"`
+
+	// Clean is "clean" if the output is cleaned before the build is timed, or
+	// "incr" if the targets are built, then the files in Edit are changed.
+	// Each occurrence of "INCR" in the edited files is replaced with "INCR.".
+	Clean string   `json:"clean"`
+	Edit  []string `json:"edit"`
+
+	Targets []string `json:"targets"`
+}
+
+// report is written with -json and read by the compare subcommand.
+type report struct {
+	Time         string            `json:"time"`
+	BazelVersion string            `json:"bazel_version"`
+	Commit       string            `json:"commit"`
+	Runs         int               `json:"runs"`
+	Benchmarks   []benchmarkResult `json:"benchmarks"`
+}
+
+type benchmarkResult struct {
+	Suite string `json:"suite"`
+	Desc  string `json:"desc"`
+
+	// Samples are the times of each run, in seconds.
+	Samples []float64 `json:"samples"`
+
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	Stddev float64 `json:"stddev"`
+
+	// Mnemonics and CriticalPath are set when -profile is used.
+	Mnemonics    []mnemonicResult    `json:"mnemonics,omitempty"`
+	CriticalPath *criticalPathResult `json:"critical_path,omitempty"`
+}
+
+// mnemonicResult is the time spent in actions with the same mnemonic,
+// averaged over runs.
+type mnemonicResult struct {
+	Mnemonic string  `json:"mnemonic"`
+	Count    float64 `json:"count"`
+	Mean     float64 `json:"mean"`
+}
+
+type criticalPathResult struct {
+	// Mean is the length of the critical path in seconds, averaged over runs.
+	Mean float64 `json:"mean"`
+
+	// Steps are the actions on the critical path in the last run.
+	Steps []criticalPathStepResult `json:"steps"`
+}
+
+type criticalPathStepResult struct {
+	Mnemonic    string  `json:"mnemonic"`
+	Description string  `json:"description"`
+	Time        float64 `json:"time"`
 }
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix(programName + ": ")
-	if err := run(os.Args[1:]); err != nil {
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		err = runCompare(os.Args[2:])
+	} else {
+		err = run(os.Args[1:])
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func run(args []string) error {
 	fs := flag.NewFlagSet(programName, flag.ExitOnError)
-	var rulesGoDir, outPath string
+	var rulesGoDir, configPath, outPath, jsonPath, suites string
 	fs.StringVar(&rulesGoDir, "rules_go_dir", "", "directory where rules_go is checked out")
-	fs.StringVar(&outPath, "out", "", "csv file to append results to")
-	var keep bool
+	fs.StringVar(&configPath, "config", "", "JSON file describing benchmark suites (default: benchmarks.json in rules_go_dir/go/tools/bazel_benchmark)")
+	fs.StringVar(&suites, "suites", "", "comma-separated list of suites to run (default: all)")
+	fs.StringVar(&outPath, "out", "", "csv file to append mean results to")
+	fs.StringVar(&jsonPath, "json", "", "json file to write all results to, for use with the compare subcommand")
+	var runs int
+	fs.IntVar(&runs, "runs", 0, "number of times to run each benchmark (default: set in the config file)")
+	var keep, profile bool
+	fs.BoolVar(&profile, "profile", false, "if true, Bazel writes a JSON profile for each build, and time is broken down by action mnemonic")
 	fs.BoolVar(&keep, "keep", false, "if true, the workspace directory won't be deleted at the end")
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -117,13 +178,32 @@ func run(args []string) error {
 	} else {
 		rulesGoDir = abs
 	}
-	if outPath == "" {
-		return errors.New("-out not set")
+	if outPath == "" && jsonPath == "" {
+		return errors.New("neither -out nor -json is set")
+	}
+	for _, p := range []*string{&outPath, &jsonPath} {
+		if *p == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(*p); err != nil {
+			return err
+		} else {
+			*p = abs
+		}
 	}
-	if abs, err := filepath.Abs(outPath); err != nil {
+	if configPath == "" {
+		configPath = filepath.Join(rulesGoDir, "go", "tools", "bazel_benchmark", "benchmarks.json")
+	}
+	var suiteNames []string
+	if suites != "" {
+		suiteNames = strings.Split(suites, ",")
+	}
+	benchmarks, configRuns, err := loadBenchmarks(configPath, suiteNames)
+	if err != nil {
 		return err
-	} else {
-		outPath = abs
+	}
+	if runs <= 0 {
+		runs = configRuns
 	}
 
 	commit, err := getCommit(rulesGoDir)
@@ -144,6 +224,16 @@ func run(args []string) error {
 		return err
 	}
 
+	var profileDir string
+	if profile {
+		if profileDir, err = ioutil.TempDir("", "bazel_benchmark_profiles"); err != nil {
+			return err
+		}
+		if !keep {
+			defer os.RemoveAll(profileDir)
+		}
+	}
+
 	log.Printf("running benchmarks in %s", dir)
 	targetSet := make(map[string]bool)
 	for _, b := range benchmarks {
@@ -159,14 +249,128 @@ func run(args []string) error {
 
 	for i := range benchmarks {
 		b := &benchmarks[i]
-		log.Printf("running benchmark %d/%d: %s", i+1, len(benchmarks), b.desc)
-		if err := runBenchmark(b); err != nil {
-			return fmt.Errorf("error running benchmark %s: %v", b.desc, err)
+		for run := 1; run <= runs; run++ {
+			log.Printf("running benchmark %d/%d: %s (run %d/%d)", i+1, len(benchmarks), b.desc, run, runs)
+			var profilePath string
+			if profileDir != "" {
+				profilePath = filepath.Join(profileDir, fmt.Sprintf("%s.%d.json", b.desc, run))
+			}
+			if err := runBenchmark(b, profilePath); err != nil {
+				return fmt.Errorf("error running benchmark %s: %v", b.desc, err)
+			}
+		}
+		if len(b.profiles) > 0 {
+			log.Printf("%s, last run:\n%s", b.desc, b.profiles[len(b.profiles)-1])
 		}
 	}
 
-	log.Printf("writing results to %s", outPath)
-	return recordResults(outPath, time.Now().UTC(), bazelVersion, commit, benchmarks)
+	t := time.Now().UTC()
+	if jsonPath != "" {
+		log.Printf("writing results to %s", jsonPath)
+		if err := writeReport(jsonPath, t, bazelVersion, commit, runs, benchmarks); err != nil {
+			return err
+		}
+	}
+	if outPath != "" {
+		log.Printf("writing results to %s", outPath)
+		if err := recordResults(outPath, t, bazelVersion, commit, benchmarks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadBenchmarks reads benchmarks from a config file. If suiteNames is not
+// empty, only benchmarks in those suites are returned. The number of runs
+// from the config file is also returned.
+func loadBenchmarks(configPath string, suiteNames []string) ([]benchmark, int, error) {
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, 0, fmt.Errorf("error reading %s: %v", configPath, err)
+	}
+	if c.Runs <= 0 {
+		c.Runs = 1
+	}
+	wantSuites := make(map[string]bool)
+	for _, name := range suiteNames {
+		wantSuites[name] = true
+	}
+
+	var benchmarks []benchmark
+	seen := make(map[string]bool)
+	for _, sc := range c.Suites {
+		if len(suiteNames) > 0 && !wantSuites[sc.Name] {
+			continue
+		}
+		delete(wantSuites, sc.Name)
+		for _, bc := range sc.Benchmarks {
+			b := benchmark{
+				suite:     sc.Name,
+				desc:      bc.Desc,
+				editFiles: bc.Edit,
+				targets:   bc.Targets,
+			}
+			if b.desc == "" {
+				return nil, 0, fmt.Errorf("%s: benchmark in suite %s has no desc", configPath, sc.Name)
+			}
+			if !isValidDesc(b.desc) {
+				return nil, 0, fmt.Errorf("%s: benchmark %q: desc may only contain letters, digits, '_', '-', and '.', and must start with a letter or digit", configPath, b.desc)
+			}
+			if seen[b.desc] {
+				return nil, 0, fmt.Errorf("%s: benchmark %s is defined more than once", configPath, b.desc)
+			}
+			seen[b.desc] = true
+			if len(b.targets) == 0 {
+				return nil, 0, fmt.Errorf("%s: benchmark %s has no targets", configPath, b.desc)
+			}
+			switch bc.Server {
+			case "asleep":
+				b.serverState = asleep
+			case "awake":
+				b.serverState = awake
+			default:
+				return nil, 0, fmt.Errorf("%s: benchmark %s: server must be \"asleep\" or \"awake\"; got %q", configPath, b.desc, bc.Server)
+			}
+			switch bc.Clean {
+			case "clean":
+				b.cleanState = clean
+			case "incr":
+				b.cleanState = incr
+				if len(b.editFiles) == 0 {
+					return nil, 0, fmt.Errorf("%s: incremental benchmark %s has no files to edit", configPath, b.desc)
+				}
+			default:
+				return nil, 0, fmt.Errorf("%s: benchmark %s: clean must be \"clean\" or \"incr\"; got %q", configPath, b.desc, bc.Clean)
+			}
+			benchmarks = append(benchmarks, b)
+		}
+	}
+	for name := range wantSuites {
+		return nil, 0, fmt.Errorf("%s: no suite named %s", configPath, name)
+	}
+	return benchmarks, c.Runs, nil
+}
+
+// isValidDesc reports whether desc may be used as a benchmark description.
+// Descriptions are used in profile file names, so they can't contain path
+// separators or be "." or "..".
+func isValidDesc(desc string) bool {
+	if desc == "" {
+		return false
+	}
+	for i, r := range desc {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case i > 0 && (r == '_' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 func getCommit(rulesGoDir string) (commit string, err error) {
@@ -271,7 +475,10 @@ func fetch(targets []string) error {
 	return logBazelCommand("fetch", targets...)
 }
 
-func runBenchmark(b *benchmark) error {
+// runBenchmark runs a benchmark once and records the time of the build.
+// If profilePath is not empty, Bazel writes a JSON profile there, and a
+// summary of it is recorded, too.
+func runBenchmark(b *benchmark, profilePath string) error {
 	switch b.cleanState {
 	case clean:
 		if err := logBazelCommand("clean"); err != nil {
@@ -281,16 +488,15 @@ func runBenchmark(b *benchmark) error {
 		if err := logBazelCommand("build", b.targets...); err != nil {
 			return err
 		}
-		if b.incrFile == "" {
-			return errors.New("incrFile not set")
-		}
-		data, err := ioutil.ReadFile(b.incrFile)
-		if err != nil {
-			return err
-		}
-		data = bytes.Replace(data, []byte("INCR"), []byte("INCR."), -1)
-		if err := ioutil.WriteFile(b.incrFile, data, 0666); err != nil {
-			return err
+		for _, name := range b.editFiles {
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			data = bytes.Replace(data, []byte("INCR"), []byte("INCR."), -1)
+			if err := ioutil.WriteFile(name, data, 0666); err != nil {
+				return err
+			}
 		}
 	}
 	if b.serverState == asleep {
@@ -298,14 +504,113 @@ func runBenchmark(b *benchmark) error {
 			return err
 		}
 	}
+	args := b.targets
+	if profilePath != "" {
+		args = append(append([]string{"--profile=" + profilePath}, profileFlags...), args...)
+	}
 	start := time.Now()
-	if err := logBazelCommand("build", b.targets...); err != nil {
+	if err := logBazelCommand("build", args...); err != nil {
 		return err
 	}
-	b.result = time.Since(start)
+	b.results = append(b.results, time.Since(start))
+	if profilePath != "" {
+		p, err := readProfile(profilePath)
+		if err != nil {
+			return err
+		}
+		b.profiles = append(b.profiles, p)
+	}
 	return nil
 }
 
+func (b *benchmark) samples() []float64 {
+	samples := make([]float64, len(b.results))
+	for i, d := range b.results {
+		samples[i] = d.Seconds()
+	}
+	return samples
+}
+
+func writeReport(jsonPath string, t time.Time, bazelVersion, commit string, runs int, benchmarks []benchmark) error {
+	r := report{
+		Time:         t.Format(time.RFC3339),
+		BazelVersion: bazelVersion,
+		Commit:       commit,
+		Runs:         runs,
+	}
+	for _, b := range benchmarks {
+		samples := b.samples()
+		s := summarize(samples)
+		r.Benchmarks = append(r.Benchmarks, benchmarkResult{
+			Suite:        b.suite,
+			Desc:         b.desc,
+			Samples:      samples,
+			Mean:         s.mean,
+			Median:       s.median,
+			Stddev:       s.stddev,
+			Mnemonics:    mnemonicResults(b.profiles),
+			CriticalPath: criticalPathResults(b.profiles),
+		})
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(jsonPath, append(data, '\n'), 0666)
+}
+
+// mnemonicResults averages the time spent on each mnemonic over several
+// profiles, in decreasing order of time.
+func mnemonicResults(profiles []*profileSummary) []mnemonicResult {
+	if len(profiles) == 0 {
+		return nil
+	}
+	byMnemonic := make(map[string]*mnemonicResult)
+	for _, p := range profiles {
+		for _, mt := range p.mnemonics {
+			mr := byMnemonic[mt.mnemonic]
+			if mr == nil {
+				mr = &mnemonicResult{Mnemonic: mt.mnemonic}
+				byMnemonic[mt.mnemonic] = mr
+			}
+			mr.Count += float64(mt.count)
+			mr.Mean += mt.time.Seconds()
+		}
+	}
+	results := make([]mnemonicResult, 0, len(byMnemonic))
+	for _, mr := range byMnemonic {
+		mr.Count /= float64(len(profiles))
+		mr.Mean /= float64(len(profiles))
+		results = append(results, *mr)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Mean != results[j].Mean {
+			return results[i].Mean > results[j].Mean
+		}
+		return results[i].Mnemonic < results[j].Mnemonic
+	})
+	return results
+}
+
+func criticalPathResults(profiles []*profileSummary) *criticalPathResult {
+	if len(profiles) == 0 {
+		return nil
+	}
+	r := &criticalPathResult{}
+	for _, p := range profiles {
+		r.Mean += p.criticalPathTime.Seconds()
+	}
+	r.Mean /= float64(len(profiles))
+	for _, step := range profiles[len(profiles)-1].criticalPath {
+		r.Steps = append(r.Steps, criticalPathStepResult{
+			Mnemonic:    step.mnemonic,
+			Description: step.description,
+			Time:        step.time.Seconds(),
+		})
+	}
+	return r
+}
+
 func recordResults(outPath string, t time.Time, bazelVersion, commit string, benchmarks []benchmark) (err error) {
 	// TODO(jayconrod): update the header if new columns are added.
 	columnMap, outExists, err := buildColumnMap(outPath, benchmarks)
@@ -394,7 +699,7 @@ func buildRecord(t time.Time, bazelVersion, commit string, benchmarks []benchmar
 	record[columnMap["bazel_version"]] = bazelVersion
 	record[columnMap["commit"]] = commit
 	for _, b := range benchmarks {
-		record[columnMap[b.desc]] = fmt.Sprintf("%.3f", b.result.Seconds())
+		record[columnMap[b.desc]] = fmt.Sprintf("%.3f", summarize(b.samples()).mean)
 	}
 	return record
 }
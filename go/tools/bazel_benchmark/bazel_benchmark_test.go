@@ -0,0 +1,69 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadBenchmarksDesc(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		wantErr bool
+	}{
+		{desc: "hello_asleep_clean"},
+		{desc: "go1.11-incr"},
+		{desc: "", wantErr: true},
+		{desc: ".", wantErr: true},
+		{desc: "..", wantErr: true},
+		{desc: "../escape", wantErr: true},
+		{desc: "sub/dir", wantErr: true},
+		{desc: `sub\dir`, wantErr: true},
+		{desc: "/abs", wantErr: true},
+		{desc: ".hidden", wantErr: true},
+		{desc: "has space", wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "benchmarks")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			descJSON := strings.Replace(strings.Replace(tc.desc, `\`, `\\`, -1), `"`, `\"`, -1)
+			fmt.Fprintf(f, `{"suites": [{"name": "s", "benchmarks": [{"desc": "%s", "server": "awake", "clean": "clean", "targets": ["//:all"]}]}]}`, descJSON)
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			benchmarks, _, err := loadBenchmarks(f.Name(), nil)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %d benchmarks; want error", len(benchmarks))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(benchmarks) != 1 || benchmarks[0].desc != tc.desc {
+				t.Errorf("got %v; want one benchmark with desc %q", benchmarks, tc.desc)
+			}
+		})
+	}
+}
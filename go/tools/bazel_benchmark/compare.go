@@ -0,0 +1,201 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// comparison is the difference between results for the same benchmark in
+// two reports.
+type comparison struct {
+	desc             string
+	old, new         summary
+	delta, p         float64
+	regression, gain bool
+
+	// mnemonics and the critical path lengths are set when both reports
+	// were written with -profile.
+	mnemonics                        []mnemonicComparison
+	oldCriticalPath, newCriticalPath float64
+}
+
+// mnemonicComparison is the difference in mean time spent on actions with
+// a mnemonic. Mnemonics in only one report have a time of 0 in the other.
+type mnemonicComparison struct {
+	mnemonic string
+	old, new float64
+}
+
+// runCompare implements the compare subcommand. It reads two reports written
+// with -json and prints how the mean time of each benchmark changed. A change
+// is significant if Welch's t-test gives a p-value below -alpha and the mean
+// changed by more than -min_delta. runCompare returns an error if any
+// benchmark got significantly slower.
+func runCompare(args []string) error {
+	fs := flag.NewFlagSet(programName+" compare", flag.ExitOnError)
+	alpha := fs.Float64("alpha", 0.05, "p-value below which a change is significant")
+	minDelta := fs.Float64("min_delta", 0.02, "relative change in the mean below which a change is ignored")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s compare [flags] old.json new.json\n", programName)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("compare needs two reports")
+	}
+	oldReport, err := readReport(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	newReport, err := readReport(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	comparisons := compareReports(oldReport, newReport, *alpha, *minDelta)
+	fmt.Printf("old: commit %s, %s\nnew: commit %s, %s\n\n", oldReport.Commit, oldReport.BazelVersion, newReport.Commit, newReport.BazelVersion)
+	printComparisons(os.Stdout, comparisons)
+	regressions := 0
+	for _, c := range comparisons {
+		if c.regression {
+			regressions++
+		}
+	}
+	if regressions > 0 {
+		return fmt.Errorf("%d benchmarks regressed", regressions)
+	}
+	return nil
+}
+
+// compareReports compares benchmarks that are in both reports, in the order
+// they appear in newReport.
+func compareReports(oldReport, newReport *report, alpha, minDelta float64) []comparison {
+	oldResults := make(map[string]benchmarkResult)
+	for _, r := range oldReport.Benchmarks {
+		oldResults[r.Desc] = r
+	}
+	var comparisons []comparison
+	for _, newResult := range newReport.Benchmarks {
+		oldResult, ok := oldResults[newResult.Desc]
+		if !ok {
+			continue
+		}
+		c := comparison{
+			desc: newResult.Desc,
+			old:  summarize(oldResult.Samples),
+			new:  summarize(newResult.Samples),
+			p:    welchTTest(oldResult.Samples, newResult.Samples),
+		}
+		if c.old.mean > 0 {
+			c.delta = (c.new.mean - c.old.mean) / c.old.mean
+		}
+		if c.p < alpha && (c.delta > minDelta || c.delta < -minDelta) {
+			c.regression = c.delta > 0
+			c.gain = c.delta < 0
+		}
+		if oldResult.CriticalPath != nil && newResult.CriticalPath != nil {
+			c.mnemonics = compareMnemonics(oldResult.Mnemonics, newResult.Mnemonics)
+			c.oldCriticalPath = oldResult.CriticalPath.Mean
+			c.newCriticalPath = newResult.CriticalPath.Mean
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
+
+// compareMnemonics pairs up mnemonics from two reports, in decreasing order
+// of the change in time.
+func compareMnemonics(oldResults, newResults []mnemonicResult) []mnemonicComparison {
+	byMnemonic := make(map[string]*mnemonicComparison)
+	get := func(m string) *mnemonicComparison {
+		if byMnemonic[m] == nil {
+			byMnemonic[m] = &mnemonicComparison{mnemonic: m}
+		}
+		return byMnemonic[m]
+	}
+	for _, r := range oldResults {
+		get(r.Mnemonic).old = r.Mean
+	}
+	for _, r := range newResults {
+		get(r.Mnemonic).new = r.Mean
+	}
+	mnemonics := make([]mnemonicComparison, 0, len(byMnemonic))
+	for _, mc := range byMnemonic {
+		mnemonics = append(mnemonics, *mc)
+	}
+	sort.Slice(mnemonics, func(i, j int) bool {
+		di := mnemonics[i].new - mnemonics[i].old
+		dj := mnemonics[j].new - mnemonics[j].old
+		if di != dj {
+			return di > dj
+		}
+		return mnemonics[i].mnemonic < mnemonics[j].mnemonic
+	})
+	return mnemonics
+}
+
+func printComparisons(w io.Writer, comparisons []comparison) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "benchmark\told\tnew\tdelta\tp\t")
+	for _, c := range comparisons {
+		verdict := "~"
+		if c.regression {
+			verdict = "REGRESSION"
+		} else if c.gain {
+			verdict = "improvement"
+		}
+		fmt.Fprintf(tw, "%s\t%.3fs ± %.3f\t%.3fs ± %.3f\t%+.1f%%\t%.3f\t%s\n",
+			c.desc, c.old.mean, c.old.stddev, c.new.mean, c.new.stddev, c.delta*100, c.p, verdict)
+	}
+	tw.Flush()
+
+	for _, c := range comparisons {
+		if c.mnemonics == nil {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", c.desc)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "  mnemonic\told\tnew\tdelta\t")
+		fmt.Fprintf(tw, "  (critical path)\t%.3fs\t%.3fs\t%+.3fs\t\n", c.oldCriticalPath, c.newCriticalPath, c.newCriticalPath-c.oldCriticalPath)
+		for _, mc := range c.mnemonics {
+			fmt.Fprintf(tw, "  %s\t%.3fs\t%.3fs\t%+.3fs\t\n", mc.mnemonic, mc.old, mc.new, mc.new-mc.old)
+		}
+		tw.Flush()
+	}
+}
+
+func readReport(path string) (*report, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &report{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("error reading report %s: %v", path, err)
+	}
+	return r, nil
+}
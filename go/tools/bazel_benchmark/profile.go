@@ -0,0 +1,189 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// profileFlags are passed to Bazel to write a JSON trace profile to the
+// file named after --profile.
+var profileFlags = []string{"--experimental_generate_json_trace_profile"}
+
+// traceEvent is an event in a JSON trace profile written by Bazel. Profiles
+// use the Trace Event Format read by chrome://tracing. Times are in
+// microseconds.
+type traceEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat"`
+	Phase string                 `json:"ph"`
+	Ts    float64                `json:"ts"`
+	Dur   float64                `json:"dur"`
+	Args  map[string]interface{} `json:"args"`
+}
+
+const (
+	actionCategory       = "action processing"
+	criticalPathCategory = "critical path component"
+)
+
+// profileSummary describes where time was spent in a build.
+type profileSummary struct {
+	// mnemonics lists the total time spent in actions of each kind, in
+	// decreasing order of time.
+	mnemonics []mnemonicTime
+
+	// criticalPath lists the actions on the critical path, in the order
+	// they ran. criticalPathTime is the sum of their times.
+	criticalPath     []criticalPathStep
+	criticalPathTime time.Duration
+}
+
+type mnemonicTime struct {
+	mnemonic string
+	count    int
+	time     time.Duration
+}
+
+type criticalPathStep struct {
+	mnemonic, description string
+	time                  time.Duration
+}
+
+func readProfile(path string) (*profileSummary, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading profile %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// parseProfile reads a JSON trace profile. Both the object form
+// ({"traceEvents": [...]}) and the array form of the format are accepted.
+func parseProfile(data []byte) (*profileSummary, error) {
+	var events []traceEvent
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		// Bazel may not write the closing bracket of the array form.
+		if trimmed[len(trimmed)-1] != ']' {
+			trimmed = append(bytes.TrimRight(trimmed, ","), ']')
+		}
+		err = json.Unmarshal(trimmed, &events)
+	} else {
+		var trace struct {
+			TraceEvents []traceEvent `json:"traceEvents"`
+		}
+		err = json.Unmarshal(data, &trace)
+		events = trace.TraceEvents
+	}
+	if err != nil {
+		return nil, err
+	}
+	return summarizeProfile(events), nil
+}
+
+func summarizeProfile(events []traceEvent) *profileSummary {
+	p := &profileSummary{}
+	byMnemonic := make(map[string]*mnemonicTime)
+	var criticalEvents []traceEvent
+	for _, e := range events {
+		if e.Phase != "X" {
+			continue
+		}
+		switch e.Cat {
+		case actionCategory:
+			m := eventMnemonic(e)
+			mt := byMnemonic[m]
+			if mt == nil {
+				mt = &mnemonicTime{mnemonic: m}
+				byMnemonic[m] = mt
+			}
+			mt.count++
+			mt.time += microseconds(e.Dur)
+		case criticalPathCategory:
+			criticalEvents = append(criticalEvents, e)
+		}
+	}
+
+	for _, mt := range byMnemonic {
+		p.mnemonics = append(p.mnemonics, *mt)
+	}
+	sort.Slice(p.mnemonics, func(i, j int) bool {
+		if p.mnemonics[i].time != p.mnemonics[j].time {
+			return p.mnemonics[i].time > p.mnemonics[j].time
+		}
+		return p.mnemonics[i].mnemonic < p.mnemonics[j].mnemonic
+	})
+
+	sort.SliceStable(criticalEvents, func(i, j int) bool { return criticalEvents[i].Ts < criticalEvents[j].Ts })
+	for _, e := range criticalEvents {
+		step := criticalPathStep{
+			mnemonic:    eventMnemonic(e),
+			description: e.Name,
+			time:        microseconds(e.Dur),
+		}
+		p.criticalPath = append(p.criticalPath, step)
+		p.criticalPathTime += step.time
+	}
+	return p
+}
+
+// eventMnemonic returns the mnemonic of the action an event describes.
+// Newer versions of Bazel record it in the event's arguments. Older versions
+// only record the action's progress message, which starts with the mnemonic
+// for actions that don't set one, like most rules_go actions.
+func eventMnemonic(e traceEvent) string {
+	if m, ok := e.Args["mnemonic"].(string); ok && m != "" {
+		return m
+	}
+	name := strings.TrimPrefix(e.Name, "action '")
+	name = strings.TrimSuffix(name, "'")
+	if i := strings.IndexByte(name, ' '); i > 0 {
+		name = name[:i]
+	}
+	if name == "" || strings.ContainsAny(name, "/.:") {
+		return "unknown"
+	}
+	return name
+}
+
+func microseconds(us float64) time.Duration {
+	return time.Duration(us * float64(time.Microsecond))
+}
+
+// String formats the summary as a table of the slowest mnemonics followed by
+// the critical path.
+func (p *profileSummary) String() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "time by mnemonic:\n")
+	for _, mt := range p.mnemonics {
+		fmt.Fprintf(buf, "  %-24s %8.3fs  %5d actions\n", mt.mnemonic, mt.time.Seconds(), mt.count)
+	}
+	fmt.Fprintf(buf, "critical path (%.3fs):\n", p.criticalPathTime.Seconds())
+	for _, step := range p.criticalPath {
+		fmt.Fprintf(buf, "  %8.3fs  %s\n", step.time.Seconds(), step.description)
+	}
+	return buf.String()
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The profiles in testdata are trimmed traces of builds of the benchmark's
+// hello target. They were written by hand following the JSON trace profile
+// format Bazel writes; they are not captured from live builds.
+//
+// profile.json is in the object form written by versions of Bazel that
+// record mnemonics in action events. Critical path events have no mnemonic,
+// so it's taken from the progress message. profile_array.json is in the
+// array form, without a closing bracket, and has no mnemonics at all, as
+// written by older versions of Bazel.
+func TestReadProfile(t *testing.T) {
+	for _, tc := range []struct {
+		file             string
+		wantMnemonics    []mnemonicTime
+		wantCriticalPath []string
+		wantCriticalTime time.Duration
+	}{
+		{
+			file: "profile.json",
+			wantMnemonics: []mnemonicTime{
+				{mnemonic: "GoStdlib", count: 1, time: 38722104 * time.Microsecond},
+				{mnemonic: "GoLink", count: 1, time: 1873450 * time.Microsecond},
+				{mnemonic: "GoCompile", count: 1, time: 612338 * time.Microsecond},
+				{mnemonic: "SourceSymlinkManifest", count: 1, time: 1544 * time.Microsecond},
+			},
+			wantCriticalPath: []string{"GoStdlib", "GoCompile", "GoLink"},
+			wantCriticalTime: 41207892 * time.Microsecond,
+		}, {
+			file: "profile_array.json",
+			wantMnemonics: []mnemonicTime{
+				{mnemonic: "GoLink", count: 1, time: 1790563 * time.Microsecond},
+				{mnemonic: "GoCompile", count: 1, time: 583117 * time.Microsecond},
+				// Actions with their own progress messages can't be told
+				// apart without mnemonics.
+				{mnemonic: "Writing", count: 1, time: 389 * time.Microsecond},
+			},
+			wantCriticalPath: []string{"GoCompile", "GoLink"},
+			wantCriticalTime: 2373680 * time.Microsecond,
+		},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			p, err := readProfile(filepath.Join("testdata", tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.mnemonics, tc.wantMnemonics) {
+				t.Errorf("mnemonics: got %+v; want %+v", p.mnemonics, tc.wantMnemonics)
+			}
+			var criticalPath []string
+			for _, step := range p.criticalPath {
+				criticalPath = append(criticalPath, step.mnemonic)
+			}
+			if !reflect.DeepEqual(criticalPath, tc.wantCriticalPath) {
+				t.Errorf("critical path: got %q; want %q", criticalPath, tc.wantCriticalPath)
+			}
+			if p.criticalPathTime != tc.wantCriticalTime {
+				t.Errorf("critical path time: got %v; want %v", p.criticalPathTime, tc.wantCriticalTime)
+			}
+		})
+	}
+}
+
+func TestParseProfileError(t *testing.T) {
+	if _, err := parseProfile([]byte(`{"traceEvents": [`)); err == nil {
+		t.Error("got success for a truncated profile; want error")
+	}
+}
+
+func TestMnemonicResults(t *testing.T) {
+	profiles := []*profileSummary{
+		{
+			mnemonics:        []mnemonicTime{{mnemonic: "GoCompile", count: 2, time: 2 * time.Second}},
+			criticalPathTime: 3 * time.Second,
+		}, {
+			mnemonics: []mnemonicTime{
+				{mnemonic: "GoCompile", count: 2, time: 4 * time.Second},
+				{mnemonic: "GoLink", count: 1, time: time.Second},
+			},
+			criticalPath:     []criticalPathStep{{mnemonic: "GoLink", description: "link", time: 5 * time.Second}},
+			criticalPathTime: 5 * time.Second,
+		},
+	}
+	want := []mnemonicResult{
+		{Mnemonic: "GoCompile", Count: 2, Mean: 3},
+		{Mnemonic: "GoLink", Count: 0.5, Mean: 0.5},
+	}
+	if got := mnemonicResults(profiles); !reflect.DeepEqual(got, want) {
+		t.Errorf("mnemonicResults: got %+v; want %+v", got, want)
+	}
+	wantPath := &criticalPathResult{
+		Mean:  4,
+		Steps: []criticalPathStepResult{{Mnemonic: "GoLink", Description: "link", Time: 5}},
+	}
+	if got := criticalPathResults(profiles); !reflect.DeepEqual(got, wantPath) {
+		t.Errorf("criticalPathResults: got %+v; want %+v", got, wantPath)
+	}
+}
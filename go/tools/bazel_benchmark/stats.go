@@ -0,0 +1,147 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// summary describes a set of samples.
+type summary struct {
+	mean, median, stddev float64
+}
+
+func summarize(samples []float64) summary {
+	if len(samples) == 0 {
+		return summary{}
+	}
+	var s summary
+	s.mean = mean(samples)
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	if n := len(sorted); n%2 == 1 {
+		s.median = sorted[n/2]
+	} else {
+		s.median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	s.stddev = math.Sqrt(variance(samples))
+	return s
+}
+
+func mean(samples []float64) float64 {
+	total := 0.0
+	for _, x := range samples {
+		total += x
+	}
+	return total / float64(len(samples))
+}
+
+// variance returns the sample variance, which is 0 if there are fewer than
+// two samples.
+func variance(samples []float64) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	m := mean(samples)
+	total := 0.0
+	for _, x := range samples {
+		total += (x - m) * (x - m)
+	}
+	return total / float64(len(samples)-1)
+}
+
+// welchTTest tests whether two sets of samples have the same mean, without
+// assuming they have the same variance. It returns the two-sided p-value:
+// the probability of seeing a difference at least this large if the means
+// are the same. Both sets need at least two samples; otherwise, the p-value
+// is 1.
+func welchTTest(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 1
+	}
+	na, nb := float64(len(a)), float64(len(b))
+	va, vb := variance(a)/na, variance(b)/nb
+	diff := mean(a) - mean(b)
+	if va+vb == 0 {
+		if diff == 0 {
+			return 1
+		}
+		return 0
+	}
+	t := diff / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/(na-1) + vb*vb/(nb-1))
+	// For Student's t distribution, P(|T| > |t|) = I_x(df/2, 1/2) with
+	// x = df / (df + t^2).
+	return regIncBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// regIncBeta returns the regularized incomplete beta function I_x(a, b).
+// It's evaluated with a continued fraction, as in Numerical Recipes, 6.4.
+func regIncBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges quickly for x < (a+1)/(a+b+2). Use the
+	// symmetry I_x(a, b) = 1 - I_{1-x}(b, a) otherwise.
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(a, b, x) / a
+	}
+	return 1 - front*betaContinuedFraction(b, a, 1-x)/b
+}
+
+func betaContinuedFraction(a, b, x float64) float64 {
+	const (
+		maxIterations = 200
+		epsilon       = 1e-14
+		tiny          = 1e-300
+	)
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+		for _, num := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+		if math.Abs(d*c-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
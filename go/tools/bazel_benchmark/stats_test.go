@@ -0,0 +1,118 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		samples []float64
+		want    summary
+	}{
+		{desc: "empty"},
+		{desc: "one", samples: []float64{2}, want: summary{mean: 2, median: 2}},
+		{desc: "odd", samples: []float64{3, 1, 2}, want: summary{mean: 2, median: 2, stddev: 1}},
+		{desc: "even", samples: []float64{4, 1, 2, 5}, want: summary{mean: 3, median: 3, stddev: math.Sqrt(10.0 / 3)}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := summarize(tc.samples)
+			if !approxEqual(got.mean, tc.want.mean) || !approxEqual(got.median, tc.want.median) || !approxEqual(got.stddev, tc.want.stddev) {
+				t.Errorf("got %+v; want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWelchTTest(t *testing.T) {
+	// Expected p-values were computed by numerically integrating the density
+	// of Student's t distribution.
+	for _, tc := range []struct {
+		desc string
+		a, b []float64
+		want float64
+	}{
+		{
+			desc: "same",
+			a:    []float64{1, 2, 3},
+			b:    []float64{1, 2, 3},
+			want: 1,
+		}, {
+			desc: "different",
+			a:    []float64{10.1, 10.3, 9.9, 10.0, 10.2},
+			b:    []float64{11.0, 11.4, 10.9, 11.2, 11.1},
+			want: 0.00002067,
+		}, {
+			desc: "overlapping",
+			a:    []float64{1, 2, 3, 4},
+			b:    []float64{2, 3, 4, 6},
+			want: 0.29033,
+		}, {
+			desc: "constant",
+			a:    []float64{1, 1},
+			b:    []float64{2, 2},
+			want: 0,
+		}, {
+			desc: "too_few",
+			a:    []float64{1},
+			b:    []float64{2, 3},
+			want: 1,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := welchTTest(tc.a, tc.b); math.Abs(got-tc.want) > 1e-3*math.Max(tc.want, 1e-3) {
+				t.Errorf("got %g; want %g", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompareReports(t *testing.T) {
+	oldReport := &report{Benchmarks: []benchmarkResult{
+		{Desc: "slower", Samples: []float64{10.1, 10.3, 9.9, 10.0, 10.2}},
+		{Desc: "faster", Samples: []float64{11.0, 11.4, 10.9, 11.2, 11.1}},
+		{Desc: "noisy", Samples: []float64{1, 2, 3, 4}},
+		{Desc: "removed", Samples: []float64{1, 1}},
+	}}
+	newReport := &report{Benchmarks: []benchmarkResult{
+		{Desc: "slower", Samples: []float64{11.0, 11.4, 10.9, 11.2, 11.1}},
+		{Desc: "faster", Samples: []float64{10.1, 10.3, 9.9, 10.0, 10.2}},
+		{Desc: "noisy", Samples: []float64{2, 3, 4, 6}},
+		{Desc: "added", Samples: []float64{1, 1}},
+	}}
+	got := compareReports(oldReport, newReport, 0.05, 0.02)
+	if len(got) != 3 {
+		t.Fatalf("got %d comparisons; want 3", len(got))
+	}
+	for i, want := range []struct {
+		desc             string
+		regression, gain bool
+	}{
+		{desc: "slower", regression: true},
+		{desc: "faster", gain: true},
+		{desc: "noisy"},
+	} {
+		if c := got[i]; c.desc != want.desc || c.regression != want.regression || c.gain != want.gain {
+			t.Errorf("comparison %d: got %s regression=%v gain=%v; want %s regression=%v gain=%v", i, c.desc, c.regression, c.gain, want.desc, want.regression, want.gain)
+		}
+	}
+}
+
+func approxEqual(x, y float64) bool {
+	return math.Abs(x-y) < 1e-9
+}
@@ -13,7 +13,8 @@
 // limitations under the License.
 
 // asm builds a single .s file with "go tool asm". It is invoked by the
-// Go rules as an action.
+// Go rules as an action. Several files may be given with -gensymabis, which
+// writes the symbol ABIs defined and referenced by a package's assembly.
 package main
 
 import (
@@ -39,23 +40,32 @@ func run(args []string) error {
 	if err := goenv.checkFlags(); err != nil {
 		return err
 	}
-	if flags.NArg() != 1 {
+	genSymABIs := false
+	for _, arg := range toolArgs {
+		genSymABIs = genSymABIs || arg == "-gensymabis"
+	}
+	if flags.NArg() == 0 || (flags.NArg() > 1 && !genSymABIs) {
 		return fmt.Errorf("wanted exactly 1 source file; got %d", flags.NArg())
 	}
-	source := flags.Args()[0]
 
-	// Filter the input file.
-	metadata, err := readGoMetadata(build.Default, source, false)
-	if err != nil {
-		return err
+	// Filter the input files.
+	var sources []string
+	for _, source := range flags.Args() {
+		metadata, err := readGoMetadata(build.Default, source, false)
+		if err != nil {
+			return err
+		}
+		if metadata.matched {
+			sources = append(sources, source)
+		}
 	}
-	if !metadata.matched {
-		source = os.DevNull
+	if len(sources) == 0 {
+		sources = []string{os.DevNull}
 	}
 
-	// Build source with the assembler.
+	// Build sources with the assembler.
 	goargs := goenv.goTool("asm", toolArgs...)
-	goargs = append(goargs, source)
+	goargs = append(goargs, sources...)
 	absArgs(goargs, []string{"-I", "-o", "-trimpath"})
 	return goenv.runCommand(goargs)
 }
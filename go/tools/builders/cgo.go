@@ -18,6 +18,9 @@
 //   and .cgo2.c files containing split definitions.
 // * In _cgo_import, cgo generates _cgo_gotypes.go which contains type
 //   information for C definitions.
+//
+// For cgo packages in the standard library, the -stdlib flag runs cgo and the
+// C compiler in one action. See cgo_stdlib.go.
 
 package main
 
@@ -44,6 +47,7 @@ func run(args []string) error {
 	importMode := false
 	flags := flag.NewFlagSet("CGoCodeGen", flag.ExitOnError)
 	goenv := envFlags(flags)
+	stdlib := stdlibCgoFlags(flags)
 	flags.Var(&sources, "src", "A source file to be filtered and compiled")
 	flags.BoolVar(&importMode, "import", false, "When true, run cgo in import mode.")
 	// process the args
@@ -54,6 +58,10 @@ func run(args []string) error {
 		return err
 	}
 
+	if stdlib.importPath != "" {
+		return stdlib.run(goenv)
+	}
+
 	// When running in import mode, just invoke cgo with the tool args. No need
 	// to process source files.
 	if importMode {
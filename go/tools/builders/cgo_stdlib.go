@@ -0,0 +1,236 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stdlibCgo holds flags for building a cgo package in the standard library.
+//
+// Standard library packages are built without cc_library rules, so cgo and
+// the C compiler are run the way "go build" runs them, all in one action.
+// The Go files generated by cgo and the C objects are written to objDir with
+// predictable names, so the rule can declare them:
+//
+// * <name>.cgo1.go and <name>.cgo2.o for each cgo file <name>.go.
+// * _cgo_gotypes.go, _cgo_import.go, and _cgo_export.o.
+// * <name>.o for each C or assembly file compiled by the C compiler.
+//
+// The package is then compiled and packed like other packages. The C
+// compiler is read from CC, and flags from the C toolchain are read from
+// CGO_CPPFLAGS, CGO_CFLAGS, and CGO_LDFLAGS.
+type stdlibCgo struct {
+	// importPath is the path of the package being built. If it's empty,
+	// the builder runs in its usual mode.
+	importPath string
+
+	objDir                     string
+	cgoSrcs, gccSrcs, sysoSrcs multiFlag
+	cppFlags, cFlags, ldFlags  multiFlag
+}
+
+// stdlibCgoFlags registers flags for building a cgo package in the standard
+// library and returns a stdlibCgo configured with those flags.
+func stdlibCgoFlags(flags *flag.FlagSet) *stdlibCgo {
+	s := &stdlibCgo{}
+	flags.StringVar(&s.importPath, "stdlib", "", "Import path of a standard library package to run cgo on")
+	flags.StringVar(&s.objDir, "objdir", "", "Directory where generated Go files and C objects are written")
+	flags.Var(&s.cgoSrcs, "cgo", "A Go file that imports \"C\"")
+	flags.Var(&s.gccSrcs, "gcc", "A C or assembly file compiled with the C compiler")
+	flags.Var(&s.sysoSrcs, "syso", "A system object linked when checking dynamic imports")
+	flags.Var(&s.cppFlags, "cppflag", "A C preprocessor flag from a #cgo directive")
+	flags.Var(&s.cFlags, "cflag", "A C compiler flag from a #cgo directive")
+	flags.Var(&s.ldFlags, "ldflag", "A linker flag from a #cgo directive")
+	return s
+}
+
+func (s *stdlibCgo) run(goenv *env) error {
+	if s.objDir == "" {
+		return fmt.Errorf("-objdir was not set")
+	}
+	if len(s.cgoSrcs) == 0 {
+		return fmt.Errorf("%s: no cgo files", s.importPath)
+	}
+	srcDir := filepath.Dir(s.cgoSrcs[0])
+	var cgoNames []string
+	for _, src := range s.cgoSrcs {
+		if filepath.Dir(src) != srcDir {
+			return fmt.Errorf("%s: cgo files are in different directories: %s and %s", s.importPath, srcDir, filepath.Dir(src))
+		}
+		cgoNames = append(cgoNames, filepath.Base(src))
+	}
+	pkgName, err := extractPackage(s.cgoSrcs[0])
+	if err != nil {
+		return err
+	}
+	// Intermediate files are written to a directory below objDir instead of
+	// a temporary directory, so their paths (which end up in debug
+	// information) are the same each time.
+	objDir := s.objDir
+	workDir := filepath.Join(objDir, "_cgo_work")
+	if err := os.MkdirAll(workDir, 0777); err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	cc := os.Getenv("CC")
+	if cc == "" {
+		return fmt.Errorf("CC was not set")
+	}
+	var cppFlags, cFlags, ldFlags []string
+	for _, f := range []struct {
+		env  string
+		pkg  []string
+		dest *[]string
+	}{
+		{"CGO_CPPFLAGS", s.cppFlags, &cppFlags},
+		{"CGO_CFLAGS", s.cFlags, &cFlags},
+		{"CGO_LDFLAGS", s.ldFlags, &ldFlags},
+	} {
+		envFlags, err := splitQuoted(os.Getenv(f.env))
+		if err != nil {
+			return fmt.Errorf("error tokenizing %s: %v", f.env, err)
+		}
+		absArgs(envFlags, cgoAbsEnvFlags)
+		*f.dest = append(envFlags, f.pkg...)
+	}
+	cppFlags = append(cppFlags, "-I", workDir)
+
+	// Run cgo. It reads linker flags from CGO_LDFLAGS and records them in
+	// _cgo_gotypes.go for the external linker.
+	cgoArgs := goenv.goTool("cgo", "-objdir", workDir, "-importpath", s.importPath)
+	if s.importPath == "runtime/cgo" {
+		cgoArgs = append(cgoArgs, "-import_runtime_cgo=false")
+	}
+	switch s.importPath {
+	case "runtime/cgo", "runtime/race", "runtime/msan", "runtime/asan":
+		cgoArgs = append(cgoArgs, "-import_syscall=false")
+	}
+	cgoArgs = append(cgoArgs, "-srcdir", srcDir, "--")
+	cgoArgs = append(cgoArgs, cppFlags...)
+	cgoArgs = append(cgoArgs, cFlags...)
+	cgoArgs = append(cgoArgs, cgoNames...)
+	if err := os.Setenv("CGO_LDFLAGS", strings.Join(ldFlags, " ")); err != nil {
+		return err
+	}
+	if err := goenv.runCommand(cgoArgs); err != nil {
+		return err
+	}
+
+	// Move the generated Go files to objDir. Paths in line comments are made
+	// relative to the execution root, so the outputs don't depend on where
+	// it is.
+	goOuts := []string{"_cgo_gotypes.go"}
+	cOuts := []string{"_cgo_export.c"}
+	for _, name := range cgoNames {
+		stem := strings.TrimSuffix(name, ".go")
+		goOuts = append(goOuts, stem+".cgo1.go")
+		cOuts = append(cOuts, stem+".cgo2.c")
+	}
+	for _, name := range goOuts {
+		if err := fixupLineComments(filepath.Join(workDir, name), abs("."), false); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(workDir, name), filepath.Join(objDir, name)); err != nil {
+			return err
+		}
+	}
+
+	// Compile the C files generated by cgo and the package's own C and
+	// assembly files.
+	ccArgs := append([]string{cc, "-I", srcDir}, cppFlags...)
+	ccArgs = append(ccArgs, cFlags...)
+	ccArgs = append(ccArgs, platformCFlags()...)
+	compile := func(src, obj string) error {
+		return goenv.runCommand(append(ccArgs[:len(ccArgs):len(ccArgs)], "-c", src, "-o", obj))
+	}
+	var objs []string
+	for _, name := range cOuts {
+		src := filepath.Join(workDir, name)
+		if err := fixupLineComments(src, abs("."), true); err != nil {
+			return err
+		}
+		obj := filepath.Join(objDir, strings.TrimSuffix(name, ".c")+".o")
+		if err := compile(src, obj); err != nil {
+			return err
+		}
+		objs = append(objs, obj)
+	}
+	seen := map[string]bool{}
+	for _, src := range s.gccSrcs {
+		name := filepath.Base(src)
+		obj := name[:len(name)-len(filepath.Ext(name))] + ".o"
+		if seen[obj] {
+			return fmt.Errorf("%s: more than one C or assembly file would be compiled to %s", s.importPath, obj)
+		}
+		seen[obj] = true
+		obj = filepath.Join(objDir, obj)
+		if err := compile(src, obj); err != nil {
+			return err
+		}
+		objs = append(objs, obj)
+	}
+
+	// Link the objects with _cgo_main.c, then ask cgo which symbols are
+	// imported from shared libraries, so the Go linker can link them
+	// internally.
+	cgoMainObj := filepath.Join(workDir, "_cgo_main.o")
+	if err := compile(filepath.Join(workDir, "_cgo_main.c"), cgoMainObj); err != nil {
+		return err
+	}
+	dynObj := filepath.Join(workDir, "_cgo_.o")
+	linkArgs := []string{cc, "-o", dynObj}
+	linkArgs = append(linkArgs, platformCFlags()...)
+	linkArgs = append(linkArgs, cgoMainObj)
+	linkArgs = append(linkArgs, objs...)
+	linkArgs = append(linkArgs, s.sysoSrcs...)
+	linkArgs = append(linkArgs, ldFlags...)
+	if (build.Default.GOARCH == "arm" && build.Default.GOOS == "linux") || build.Default.GOOS == "android" {
+		hasNoPIE := false
+		for _, f := range ldFlags {
+			hasNoPIE = hasNoPIE || f == "-no-pie"
+		}
+		if !hasNoPIE {
+			linkArgs = append(linkArgs, "-pie")
+		}
+	}
+	if err := goenv.runCommand(linkArgs); err != nil {
+		return err
+	}
+	importArgs := goenv.goTool("cgo", "-dynpackage", pkgName, "-dynimport", dynObj, "-dynout", filepath.Join(objDir, "_cgo_import.go"))
+	if s.importPath == "runtime/cgo" {
+		importArgs = append(importArgs, "-dynlinker")
+	}
+	return goenv.runCommand(importArgs)
+}
+
+// platformCFlags returns flags "go build" always passes to the C compiler
+// for the target platform.
+func platformCFlags() []string {
+	switch build.Default.GOOS {
+	case "windows":
+		return []string{"-mthreads"}
+	case "darwin":
+		return []string{"-fPIC"}
+	default:
+		return []string{"-fPIC", "-pthread"}
+	}
+}
@@ -51,7 +51,7 @@ func run(args []string) error {
 	flags.Var(&archives, "arc", "Import path, package path, and file name of a direct dependency, separated by '='")
 	nogo := flags.String("nogo", "", "The nogo binary")
 	output := flags.String("o", "", "The output object file to write")
-	packageList := flags.String("package_list", "", "The file containing the list of standard library packages")
+	packageList := flags.String("package_list", "", "The file containing the list of standard library packages. If empty, all imports must be listed with -arc.")
 	testfilter := flags.String("testfilter", "off", "Controls test package filtering")
 	if err := flags.Parse(builderArgs); err != nil {
 		return err
@@ -113,6 +113,13 @@ func run(args []string) error {
 	}
 
 	// Build an importcfg file for the compiler.
+	// UGLY HACK: The vet tool called by compile program expects the vet.cfg file
+	// passed to it to contain import information for package fmt. Since we use
+	// importcfg to create vet.cfg, ensure that an entry for package fmt exists in
+	// the former.
+	if *packageList != "" {
+		stdImports = append(stdImports, "fmt")
+	}
 	importcfgName, err := buildImportcfgFile(archives, stdImports, goenv.installSuffix, filepath.Dir(*output))
 	if err != nil {
 		return err
@@ -186,15 +193,18 @@ func main() {
 }
 
 func checkDirectDeps(files []*goMetadata, archives []archive, packageList string) (depImports, stdImports []string, err error) {
-	packagesTxt, err := ioutil.ReadFile(packageList)
-	if err != nil {
-		log.Fatal(err)
-	}
-	stdlibSet := map[string]bool{}
-	for _, line := range strings.Split(string(packagesTxt), "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			stdlibSet[line] = true
+	// unsafe is built into the compiler, so it's never listed with -arc.
+	stdlibSet := map[string]bool{"unsafe": true}
+	if packageList != "" {
+		packagesTxt, err := ioutil.ReadFile(packageList)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, line := range strings.Split(string(packagesTxt), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" {
+				stdlibSet[line] = true
+			}
 		}
 	}
 
@@ -239,11 +249,6 @@ func buildImportcfgFile(archives []archive, stdImports []string, installSuffix,
 		return "", errors.New("GOROOT not set")
 	}
 	goroot = abs(goroot)
-	// UGLY HACK: The vet tool called by compile program expects the vet.cfg file
-	// passed to it to contain import information for package fmt. Since we use
-	// importcfg to create vet.cfg, ensure that an entry for package fmt exists in
-	// the former.
-	stdImports = append(stdImports, "fmt")
 	for _, imp := range stdImports {
 		path := filepath.Join(goroot, "pkg", installSuffix, filepath.FromSlash(imp))
 		fmt.Fprintf(buf, "packagefile %s=%s.a\n", imp, path)
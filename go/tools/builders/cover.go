@@ -36,11 +36,17 @@ func run(args []string) error {
 		return err
 	}
 	flags := flag.NewFlagSet("cover", flag.ExitOnError)
-	var coverSrc, coverVar, origSrc, srcName string
+	var coverSrc, coverVar, origSrc, pkgPath, srcName, srcPath, mode string
+	var binaryMain, wrapOnly bool
 	flags.StringVar(&coverSrc, "o", "", "coverage output file")
 	flags.StringVar(&coverVar, "var", "", "name of cover variable")
 	flags.StringVar(&origSrc, "src", "", "original source file")
+	flags.StringVar(&pkgPath, "pkgpath", "", "path of the package the file belongs to, as it's linked")
 	flags.StringVar(&srcName, "srcname", "", "source name printed in coverage data")
+	flags.StringVar(&srcPath, "srcpath", "", "path of the original source file relative to the execution root, printed in LCOV reports")
+	flags.StringVar(&mode, "mode", "set", "coverage mode: set, count, or atomic")
+	flags.BoolVar(&binaryMain, "main", false, "whether the file is in the main package of a binary; if it declares main, coverage is written when main returns")
+	flags.BoolVar(&wrapOnly, "wrap_main_only", false, "don't instrument the file; only wrap its main function (requires -main)")
 	goenv := envFlags(flags)
 	if err := flags.Parse(args); err != nil {
 		return err
@@ -60,22 +66,68 @@ func run(args []string) error {
 	if srcName == "" {
 		srcName = origSrc
 	}
+	switch mode {
+	case "set", "count", "atomic":
+	default:
+		return fmt.Errorf("-mode must be set, count, or atomic; got %q", mode)
+	}
+
+	if wrapOnly {
+		// The main package is excluded by --instrumentation_filter, but main
+		// is still wrapped, so coverage of instrumented libraries is written.
+		if !binaryMain {
+			return fmt.Errorf("-wrap_main_only requires -main")
+		}
+		return wrapMainOnly(origSrc, coverSrc)
+	}
 
-	goargs := goenv.goTool("cover", "-var", coverVar, "-o", coverSrc)
+	goargs := goenv.goTool("cover", "-var", coverVar, "-mode", mode, "-o", coverSrc)
 	goargs = append(goargs, flags.Args()...)
 	goargs = append(goargs, origSrc)
 	if err := goenv.runCommand(goargs); err != nil {
 		return err
 	}
 
-	return registerCoverage(coverSrc, coverVar, srcName)
+	return registerCoverage(coverSrc, coverVar, pkgPath, srcName, srcPath, mode, binaryMain)
+}
+
+// wrapMainOnly copies origSrc to coverSrc without instrumenting it. If the
+// file declares the main function of a binary, main is wrapped as it is by
+// registerCoverage.
+func wrapMainOnly(origSrc, coverSrc string) error {
+	data, err := ioutil.ReadFile(origSrc)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(coverSrc, data, 0666); err != nil {
+		return err
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), coverSrc, data, 0)
+	if err != nil || f.Name.Name != "main" {
+		return nil // not a main package, or a parse error the compiler will report
+	}
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "main" {
+			return registerCoverage(coverSrc, "", "", "", "", "", true)
+		}
+	}
+	return nil
 }
 
 // registerCoverage modifies coverSrc, the output file from go tool cover. It
 // adds a call to coverdata.RegisterCoverage, which ensures the coverage
 // data from each file is reported. The name by which the file is registered
-// need not match its original name (it may use the importpath).
-func registerCoverage(coverSrc, varName, srcName string) error {
+// need not match its original name (it may use the importpath). The file is
+// identified by pkgPath and srcPath, and srcPath is used so that LCOV reports
+// can refer to the original source file. mode is the mode the file was
+// instrumented with.
+//
+// If binaryMain is true and the file declares the main function of a binary,
+// the function is renamed, and a new main function is added that calls
+// coverdata.BinaryMain, so coverage is written when the binary exits. If
+// varName is empty, the file was not instrumented, and it's not registered;
+// only main is wrapped.
+func registerCoverage(coverSrc, varName, pkgPath, srcName, srcPath, mode string, binaryMain bool) error {
 	// Parse the file.
 	fset := token.NewFileSet()
 	f, err := parser.ParseFile(fset, coverSrc, nil, parser.ParseComments)
@@ -120,20 +172,44 @@ func registerCoverage(coverSrc, varName, srcName string) error {
 		decl := &ast.GenDecl{Tok: token.IMPORT, Specs: []ast.Spec{imp}}
 		f.Decls = append([]ast.Decl{decl}, f.Decls...)
 	}
+
+	// Rename main, if we need to wrap it. Only the name changes, so line
+	// numbers in the instrumented code still match the original file.
+	wrapMain := false
+	if binaryMain && f.Name.Name == "main" {
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "main" {
+				fn.Name.Name = coveredMainName
+				wrapMain = true
+				break
+			}
+		}
+	}
+
 	var buf bytes.Buffer
 	if err := format.Node(&buf, fset, f); err != nil {
 		return fmt.Errorf("registerCoverage: could not reformat coverage source %s: %v", coverSrc, err)
 	}
 
 	// Append an init function.
-	fmt.Fprintf(&buf, `
+	if varName != "" {
+		fmt.Fprintf(&buf, `
 func init() {
-	%s.RegisterFile(%q,
-		%[3]s.Count[:],
-		%[3]s.Pos[:],
-		%[3]s.NumStmt[:])
+	%s.RegisterFile(%q, %q, %q, %q,
+		%[6]s.Count[:],
+		%[6]s.Pos[:],
+		%[6]s.NumStmt[:])
+}
+`, coverdataName, pkgPath, srcName, srcPath, mode, varName)
+	}
+	if wrapMain {
+		fmt.Fprintf(&buf, `
+func main() {
+	defer %s.BinaryMain()()
+	%s()
 }
-`, coverdataName, srcName, varName)
+`, coverdataName, coveredMainName)
+	}
 	if err := ioutil.WriteFile(coverSrc, buf.Bytes(), 0666); err != nil {
 		return fmt.Errorf("registerCoverage: %v", err)
 	}
@@ -141,6 +217,9 @@ func init() {
 	return nil
 }
 
+// coveredMainName is the name main is given when it's wrapped.
+const coveredMainName = "_bazel_coverage_main"
+
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("GoCover: ")
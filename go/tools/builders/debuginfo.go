@@ -0,0 +1,90 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// linkBuildID computes a GNU build ID for a binary from the arguments and
+// files used to link it: args, the main archive, and every archive named by a
+// packagefile line in importcfg, including standard library archives. The
+// same inputs always produce the same ID, so builds are reproducible, and
+// different inputs produce different IDs, so debuggers can match a stripped
+// binary with its debug file.
+func linkBuildID(importcfg, main string, args []string) (string, error) {
+	h := sha1.New()
+	for _, arg := range args {
+		fmt.Fprintf(h, "arg %q\n", arg)
+	}
+	if err := hashLinkInput(h, "main", main); err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(importcfg)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "packagefile ") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return "", fmt.Errorf("%s: invalid line %q", importcfg, line)
+		}
+		if err := hashLinkInput(h, line[:i], line[i+1:]); err != nil {
+			return "", err
+		}
+	}
+	return "0x" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashLinkInput writes name and the size and contents of file to h. The file
+// name is not hashed, since it may be an absolute path.
+func hashLinkInput(h io.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		// The standard library package list may include packages that
+		// weren't built for the target. The linker doesn't read them.
+		fmt.Fprintf(h, "%s missing\n", name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%s %d\n", name, fi.Size())
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// splitDebugInfo moves the debug information in binary to debugFile using
+// objcopy. binary is left with a .gnu_debuglink section naming debugFile.
+// Together with the build ID, this lets debuggers find the debug file.
+func splitDebugInfo(goenv *env, objcopy, binary, debugFile string) error {
+	if err := goenv.runCommand([]string{objcopy, "--only-keep-debug", binary, debugFile}); err != nil {
+		return err
+	}
+	return goenv.runCommand([]string{objcopy, "--strip-debug", "--add-gnu-debuglink=" + debugFile, binary})
+}
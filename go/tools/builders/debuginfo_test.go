@@ -0,0 +1,75 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkBuildID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLinkBuildID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// buildID writes the main archive, a library archive, and a standard
+	// library archive to a directory named root, then returns the build ID.
+	buildID := func(root, stdContent string, args ...string) string {
+		root = filepath.Join(dir, root)
+		if err := os.MkdirAll(filepath.Join(root, "std"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{
+			"main.a":    "main",
+			"lib.a":     "lib",
+			"std/fmt.a": stdContent,
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		importcfg := filepath.Join(root, "importcfg")
+		// syscall/js isn't built for this platform, so its archive is missing.
+		content := fmt.Sprintf("packagefile fmt=%s\npackagefile syscall/js=%s\npackagefile example.com/lib=%s\n",
+			filepath.Join(root, "std/fmt.a"),
+			filepath.Join(root, "std/syscall/js.a"),
+			filepath.Join(root, "lib.a"))
+		if err := ioutil.WriteFile(importcfg, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		id, err := linkBuildID(importcfg, filepath.Join(root, "main.a"), args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	id := buildID("a", "fmt", "-s")
+	if other := buildID("b", "fmt", "-s"); other != id {
+		t.Errorf("build ID depends on file locations: %s and %s", id, other)
+	}
+	if other := buildID("c", "fmt v2", "-s"); other == id {
+		t.Errorf("build ID doesn't depend on standard library archives")
+	}
+	if other := buildID("d", "fmt", "-w"); other == id {
+		t.Errorf("build ID doesn't depend on arguments")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// findDuplicatePackages returns a report describing packages provided by more
+// than one library in archives, or "" if there are none. For each library
+// that provides a conflicting package, the report shows a chain of
+// dependencies leading to it from root, the label of the target being linked.
+//
+// arcDeps describes the dependency graph. Each element is the label of a
+// library followed by the labels of its direct dependencies, separated
+// by '='.
+func findDuplicatePackages(archives []archive, arcDeps []string, root string) string {
+	labelsByPath := map[string][]string{}
+	for _, arc := range archives {
+		labels := labelsByPath[arc.pkgPath]
+		seen := false
+		for _, l := range labels {
+			if l == arc.label {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			labelsByPath[arc.pkgPath] = append(labels, arc.label)
+		}
+	}
+	var conflicts []string
+	for pkgPath, labels := range labelsByPath {
+		if len(labels) > 1 {
+			conflicts = append(conflicts, pkgPath)
+		}
+	}
+	if len(conflicts) == 0 {
+		return ""
+	}
+	sort.Strings(conflicts)
+
+	graph := map[string][]string{}
+	for _, d := range arcDeps {
+		parts := strings.Split(d, "=")
+		graph[parts[0]] = append(graph[parts[0]], parts[1:]...)
+	}
+	parents := shortestPaths(graph, root)
+
+	buf := &bytes.Buffer{}
+	for _, pkgPath := range conflicts {
+		fmt.Fprintf(buf, "package %s is provided by more than one library:\n", pkgPath)
+		for _, label := range labelsByPath[pkgPath] {
+			fmt.Fprintf(buf, "    %s\n", label)
+			if chain := dependencyChain(parents, root, label); chain != nil {
+				fmt.Fprintf(buf, "        linked through %s\n", strings.Join(chain, " -> "))
+			}
+		}
+	}
+	return buf.String()
+}
+
+// shortestPaths searches graph breadth-first from root. It returns a map from
+// each reachable label to the label it was first reached from.
+func shortestPaths(graph map[string][]string, root string) map[string]string {
+	parents := map[string]string{root: ""}
+	queue := []string{root}
+	for len(queue) > 0 {
+		label := queue[0]
+		queue = queue[1:]
+		for _, dep := range graph[label] {
+			if _, ok := parents[dep]; !ok {
+				parents[dep] = label
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return parents
+}
+
+// dependencyChain returns the labels on the shortest path from root to label,
+// including both ends. It returns nil if label is not reachable.
+func dependencyChain(parents map[string]string, root, label string) []string {
+	if _, ok := parents[label]; !ok {
+		return nil
+	}
+	var chain []string
+	for l := label; l != root; l = parents[l] {
+		chain = append(chain, l)
+	}
+	chain = append(chain, root)
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestFindDuplicatePackages(t *testing.T) {
+	archives := []archive{
+		{label: "//a:a", pkgPath: "example.com/a"},
+		{label: "//vendor/example.com/lib:lib", pkgPath: "example.com/lib"},
+		{label: "@lib//:lib", pkgPath: "example.com/lib"},
+		{label: "//b:b", pkgPath: "example.com/b"},
+	}
+	arcDeps := []string{
+		"//cmd:cmd=//a:a=//b:b",
+		"//a:a=//vendor/example.com/lib:lib",
+		"//b:b=@lib//:lib",
+		"@lib//:lib",
+	}
+	want := `package example.com/lib is provided by more than one library:
+    //vendor/example.com/lib:lib
+        linked through //cmd:cmd -> //a:a -> //vendor/example.com/lib:lib
+    @lib//:lib
+        linked through //cmd:cmd -> //b:b -> @lib//:lib
+`
+	if got := findDuplicatePackages(archives, arcDeps, "//cmd:cmd"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := findDuplicatePackages(archives[:2], arcDeps, "//cmd:cmd"); got != "" {
+		t.Errorf("got:\n%s\nwant no conflicts", got)
+	}
+}
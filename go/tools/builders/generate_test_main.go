@@ -23,14 +23,21 @@ import (
 	"go/ast"
 	"go/build"
 	"go/doc"
+	"go/importer"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Import struct {
@@ -53,12 +60,29 @@ type Example struct {
 // Cases holds template data.
 type Cases struct {
 	RunDir     string
+	PkgName    string
 	Imports    []*Import
 	Tests      []TestCase
 	Benchmarks []TestCase
 	Examples   []Example
 	TestMain   string
 	Coverage   bool
+
+	// FuzzTargets lists functions like FuzzX(*testing.F). Their seed corpora
+	// are run like tests. Fuzz targets require Go 1.18 or later, which added
+	// a parameter for them to testing.MainStart.
+	FuzzTargets []TestCase
+
+	// TestMainPackages lists the packages with TestMain functions when both
+	// the internal and external test packages have one, and they are
+	// composed. TestMain is not set in this case.
+	TestMainPackages []string
+
+	Quarantine        []string
+	QuarantineRetries int
+
+	CoverageThreshold         float64
+	PackageCoverageThresholds map[string]float64
 }
 
 var codeTpl = `
@@ -73,6 +97,7 @@ import (
 	"testing"
 	"testing/internal/testdeps"
 
+	"github.com/bazelbuild/rules_go/go/tools/bzltestutil"
 {{if .Coverage}}
 	"github.com/bazelbuild/rules_go/go/tools/coverdata"
 {{end}}
@@ -100,6 +125,66 @@ var examples = []testing.InternalExample{
 {{end}}
 }
 
+{{if .FuzzTargets}}
+var fuzzTargets = []testing.InternalFuzzTarget{
+{{range .FuzzTargets}}
+	{"{{.Name}}", {{.Package}}.{{.Name}} },
+{{end}}
+}
+{{end}}
+
+{{if .TestMainPackages}}
+// Tests, benchmarks, and examples in each package with a TestMain function.
+// Each package's tests are run in a separate process under its own TestMain.
+var packageTests = map[string][]testing.InternalTest{
+{{range $pkg := .TestMainPackages}}
+	"{{$pkg}}": {
+{{range $.Tests}}{{if eq .Package $pkg}}
+		{"{{.Name}}", {{.Package}}.{{.Name}} },
+{{end}}{{end}}
+	},
+{{end}}
+}
+
+var packageBenchmarks = map[string][]testing.InternalBenchmark{
+{{range $pkg := .TestMainPackages}}
+	"{{$pkg}}": {
+{{range $.Benchmarks}}{{if eq .Package $pkg}}
+		{"{{.Name}}", {{.Package}}.{{.Name}} },
+{{end}}{{end}}
+	},
+{{end}}
+}
+
+var packageExamples = map[string][]testing.InternalExample{
+{{range $pkg := .TestMainPackages}}
+	"{{$pkg}}": {
+{{range $.Examples}}{{if eq .Package $pkg}}
+		{Name: "{{.Name}}", F: {{.Package}}.{{.Name}}, Output: {{printf "%q" .Output}}, Unordered: {{.Unordered}} },
+{{end}}{{end}}
+	},
+{{end}}
+}
+
+{{if .FuzzTargets}}
+var packageFuzzTargets = map[string][]testing.InternalFuzzTarget{
+{{range $pkg := .TestMainPackages}}
+	"{{$pkg}}": {
+{{range $.FuzzTargets}}{{if eq .Package $pkg}}
+		{"{{.Name}}", {{.Package}}.{{.Name}} },
+{{end}}{{end}}
+	},
+{{end}}
+}
+{{end}}
+
+var testMains = map[string]func(*testing.M){
+{{range .TestMainPackages}}
+	"{{.}}": {{.}}.TestMain,
+{{end}}
+}
+{{end}}
+
 func testsInShard() []testing.InternalTest {
 	totalShards, err := strconv.Atoi(os.Getenv("TEST_TOTAL_SHARDS"))
 	if err != nil || totalShards <= 1 {
@@ -109,6 +194,12 @@ func testsInShard() []testing.InternalTest {
 	if err != nil || shardIndex < 0 {
 		return allTests
 	}
+	// Tell Bazel this test supports sharding.
+	if statusFile := os.Getenv("TEST_SHARD_STATUS_FILE"); statusFile != "" {
+		if f, err := os.Create(statusFile); err == nil {
+			f.Close()
+		}
+	}
 	tests := []testing.InternalTest{}
 	for i, t := range allTests {
 		if i % totalShards == shardIndex {
@@ -119,6 +210,53 @@ func testsInShard() []testing.InternalTest {
 }
 
 func main() {
+	opts := bzltestutil.Options{
+		Package: {{printf "%q" .PkgName}},
+{{if .Quarantine}}
+		Quarantine: []string{
+{{range .Quarantine}}
+			{{printf "%q" .}},
+{{end}}
+		},
+		QuarantineRetries: {{.QuarantineRetries}},
+{{end}}
+{{if .TestMain}}
+		TestMain: true,
+{{end}}
+{{if .TestMainPackages}}
+		TestMainPackages: []string{
+{{range .TestMainPackages}}
+			"{{.}}",
+{{end}}
+		},
+{{end}}
+{{if .CoverageThreshold}}
+		CoverageThreshold: {{printf "%g" .CoverageThreshold}},
+{{end}}
+{{if .PackageCoverageThresholds}}
+		PackageCoverageThresholds: map[string]float64{
+{{range $pkg, $min := .PackageCoverageThresholds}}
+			{{printf "%q" $pkg}}: {{printf "%g" $min}},
+{{end}}
+		},
+{{end}}
+	}
+	if bzltestutil.ShouldWrap(opts) {
+		os.Exit(bzltestutil.Wrap(opts))
+	}
+
+{{if .TestMainPackages}}
+	pkg := bzltestutil.TestMainPackage()
+	testMain, ok := testMains[pkg]
+	if !ok {
+		log.Fatalf("unknown test package %q", pkg)
+	}
+	allTests, benchmarks, examples = packageTests[pkg], packageBenchmarks[pkg], packageExamples[pkg]
+{{if .FuzzTargets}}
+	fuzzTargets = packageFuzzTargets[pkg]
+{{end}}
+{{end}}
+
 	// Check if we're being run by Bazel and change directories if so.
 	// TEST_SRCDIR and TEST_WORKSPACE are set by the Bazel test runner, so that makes a decent proxy.
 	testSrcdir := os.Getenv("TEST_SRCDIR")
@@ -146,16 +284,23 @@ func main() {
 	if len(coverdata.Cover.Counters) > 0 {
 		testing.RegisterCover(coverdata.Cover)
 	}
-	if coverageDat, ok := os.LookupEnv("COVERAGE_OUTPUT_FILE"); ok {
+	if profile := bzltestutil.CoverProfile(); profile != "" {
 		if testing.CoverMode() != "" {
-			flag.Lookup("test.coverprofile").Value.Set(coverageDat)
+			flag.Lookup("test.coverprofile").Value.Set(profile)
 		}
 	}
 	{{end}}
 
-	m := testing.MainStart(testdeps.TestDeps{}, testsInShard(), benchmarks, examples)
-	{{if not .TestMain}}
-	os.Exit(m.Run())
+	bzltestutil.StartWatchdog()
+{{if .FuzzTargets}}
+	m := testing.MainStart(testdeps.TestDeps{}, bzltestutil.TrackTests(opts, testsInShard()), benchmarks, fuzzTargets, examples)
+{{else}}
+	m := testing.MainStart(testdeps.TestDeps{}, bzltestutil.TrackTests(opts, testsInShard()), benchmarks, examples)
+{{end}}
+	{{if .TestMainPackages}}
+	testMain(m)
+	{{else if not .TestMain}}
+	os.Exit(bzltestutil.Finish(opts, m.Run()))
 	{{else}}
 	{{.TestMain}}(m)
 	{{end}}
@@ -170,13 +315,25 @@ func run(args []string) error {
 	}
 	imports := multiFlag{}
 	sources := multiFlag{}
+	archives := multiFlag{}
+	quarantine := multiFlag{}
+	quarantineFiles := multiFlag{}
+	packageCoverageThresholds := multiFlag{}
 	flags := flag.NewFlagSet("GoTestGenTest", flag.ExitOnError)
 	goenv := envFlags(flags)
 	runDir := flags.String("rundir", ".", "Path to directory where tests should run.")
+	pkgname := flags.String("pkgname", "", "Import path of the package under test.")
 	out := flags.String("output", "", "output file to write. Defaults to stdout.")
 	coverage := flags.Bool("coverage", false, "whether coverage is supported")
+	composeTestMain := flags.Bool("compose_test_main", false, "Whether to allow both test packages to have a TestMain, running each package's tests separately")
+	quarantineRetries := flags.Int("quarantine_retries", 0, "Number of times to retry a failed quarantined test")
+	flags.Var(&quarantine, "quarantine", "Name of a flaky test to quarantine")
+	flags.Var(&quarantineFiles, "quarantine_file", "File listing names of flaky tests to quarantine, one per line")
+	coverageThreshold := flags.Float64("coverage_threshold", 0, "Minimum percentage of statements covered in all instrumented packages")
+	flags.Var(&packageCoverageThresholds, "package_coverage_threshold", "Minimum percentage of statements covered in a package, as importpath=percent")
 	flags.Var(&imports, "import", "Packages to import")
 	flags.Var(&sources, "src", "Sources to process for tests")
+	flags.Var(&archives, "archive", "Compiled archives for packages to import, used to check test signatures")
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
@@ -193,6 +350,15 @@ func run(args []string) error {
 		i := &Import{Name: parts[0], Path: parts[1]}
 		importMap[i.Name] = i
 	}
+	// Process archive args
+	archiveMap := map[string]string{}
+	for _, a := range archives {
+		parts := strings.Split(a, "=")
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid archive %q specified", a)
+		}
+		archiveMap[parts[0]] = parts[1]
+	}
 	// Process source args
 	sourceList := []string{}
 	sourceMap := map[string]string{}
@@ -223,11 +389,18 @@ func run(args []string) error {
 
 	cases := Cases{
 		RunDir:   strings.Replace(filepath.FromSlash(*runDir), `\`, `\\`, -1),
+		PkgName:  *pkgname,
 		Coverage: *coverage,
 	}
 
+	pkgTypes, err := loadArchives(archiveMap)
+	if err != nil {
+		return err
+	}
+
 	testFileSet := token.NewFileSet()
 	pkgs := map[string]bool{}
+	testMains := map[string]token.Position{}
 	for _, f := range filenames {
 		parse, err := parser.ParseFile(testFileSet, f, nil, parser.ParseComments)
 		if err != nil {
@@ -257,64 +430,134 @@ func run(args []string) error {
 			if fn.Recv != nil {
 				continue
 			}
-			if fn.Name.Name == "TestMain" {
-				// TestMain is not, itself, a test
-				pkgs[pkg] = true
-				cases.TestMain = fmt.Sprintf("%s.%s", pkg, fn.Name.Name)
+			name := fn.Name.Name
+			var kind string
+			switch {
+			case name == "TestMain":
+				kind = "M"
+			case isTest(name, "Test"):
+				kind = "T"
+			case isTest(name, "Benchmark"):
+				kind = "B"
+			case isTest(name, "Fuzz"):
+				kind = "F"
+			default:
 				continue
 			}
 
-			// Here we check the signature of the Test* function. To
-			// be considered a test:
-
-			// 1. The function should have a single argument.
-			if len(fn.Type.Params.List) != 1 {
-				continue
+			// The syntax tree tells us which functions might be tests and in
+			// what order they're declared. We check signatures using types
+			// from the compiled package, since names in the syntax tree may
+			// refer to aliases or to types with the same name in other
+			// packages.
+			sig, err := lookupSignature(pkgTypes, pkg, name)
+			if err != nil {
+				return err
 			}
-
-			// 2. The function should return nothing.
-			if fn.Type.Results != nil {
+			if sig == nil {
 				continue
 			}
-
-			// 3. The only parameter should have a type identified as
-			//    *<something>.T
-			starExpr, ok := fn.Type.Params.List[0].Type.(*ast.StarExpr)
-			if !ok {
+			pos := testFileSet.Position(fn.Pos())
+			switch kind {
+			case "M":
+				if isTestingFunc(sig, "T") {
+					// Like go test, treat TestMain(*testing.T) as an ordinary test.
+					kind = "T"
+					break
+				}
+				if !isTestingFunc(sig, "M") {
+					return wrongSignature(pos, name, "M")
+				}
+				// TestMain is not, itself, a test
+				if other, ok := testMains[pkg]; ok {
+					return fmt.Errorf("%s: multiple definitions of TestMain; previous definition at %s", pos, other)
+				}
+				pkgs[pkg] = true
+				testMains[pkg] = pos
+				cases.TestMain = fmt.Sprintf("%s.%s", pkg, name)
 				continue
 			}
-			selExpr, ok := starExpr.X.(*ast.SelectorExpr)
-			if !ok {
-				continue
+			if !isTestingFunc(sig, kind) {
+				return wrongSignature(pos, name, kind)
 			}
+			pkgs[pkg] = true
+			tc := TestCase{Package: pkg, Name: name}
+			switch kind {
+			case "T":
+				cases.Tests = append(cases.Tests, tc)
+			case "B":
+				cases.Benchmarks = append(cases.Benchmarks, tc)
+			case "F":
+				cases.FuzzTargets = append(cases.FuzzTargets, tc)
+			}
+		}
+	}
+	// If both the internal and external test packages have a TestMain,
+	// report an error like go test does, unless the user asked for them to
+	// be composed.
+	if len(testMains) > 1 {
+		if !*composeTestMain {
+			return fmt.Errorf("multiple definitions of TestMain in the internal and external test packages:\n\t%s\n\t%s\nSet compose_test_main = True on the go_test to run each package's tests under its own TestMain.", testMains["l"], testMains["l_test"])
+		}
+		for pkg := range testMains {
+			cases.TestMainPackages = append(cases.TestMainPackages, pkg)
+		}
+		sort.Strings(cases.TestMainPackages)
+		cases.TestMain = ""
+	}
 
-			// We do not descriminate on the referenced type of the
-			// parameter being *testing.T. Instead we assert that it
-			// should be *<something>.T. This is because the import
-			// could have been aliased as a different identifier.
-
-			if strings.HasPrefix(fn.Name.Name, "Test") {
-				if selExpr.Sel.Name != "T" {
-					continue
-				}
-				pkgs[pkg] = true
-				cases.Tests = append(cases.Tests, TestCase{
-					Package: pkg,
-					Name:    fn.Name.Name,
-				})
+	// Check that quarantined tests exist. Mistyped names would otherwise
+	// go unnoticed.
+	for _, path := range quarantineFiles {
+		names, err := readQuarantineFile(path)
+		if err != nil {
+			return err
+		}
+		quarantine = append(quarantine, names...)
+	}
+	if len(quarantine) > 0 {
+		testNames := map[string]bool{}
+		for _, tc := range cases.Tests {
+			testNames[tc.Name] = true
+		}
+		seen := map[string]bool{}
+		for _, name := range quarantine {
+			if !testNames[name] {
+				return fmt.Errorf("quarantined test %s was not found", name)
 			}
-			if strings.HasPrefix(fn.Name.Name, "Benchmark") {
-				if selExpr.Sel.Name != "B" {
-					continue
-				}
-				pkgs[pkg] = true
-				cases.Benchmarks = append(cases.Benchmarks, TestCase{
-					Package: pkg,
-					Name:    fn.Name.Name,
-				})
+			if !seen[name] {
+				seen[name] = true
+				cases.Quarantine = append(cases.Quarantine, name)
 			}
 		}
+		cases.QuarantineRetries = *quarantineRetries
 	}
+
+	// Check coverage thresholds. They're checked at run time, but only when
+	// coverage is collected, so mistakes would otherwise go unnoticed.
+	if *coverageThreshold < 0 || *coverageThreshold > 100 {
+		return fmt.Errorf("coverage_threshold must be between 0 and 100; got %g", *coverageThreshold)
+	}
+	cases.CoverageThreshold = *coverageThreshold
+	for _, t := range packageCoverageThresholds {
+		i := strings.LastIndex(t, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid package coverage threshold %q: want importpath=percent", t)
+		}
+		pkgPath := t[:i]
+		min, err := strconv.ParseFloat(t[i+1:], 64)
+		if err != nil || pkgPath == "" {
+			return fmt.Errorf("invalid package coverage threshold %q: want importpath=percent", t)
+		}
+		if min < 0 || min > 100 {
+			return fmt.Errorf("coverage threshold for package %s must be between 0 and 100; got %g", pkgPath, min)
+		}
+		if cases.PackageCoverageThresholds == nil {
+			cases.PackageCoverageThresholds = map[string]float64{}
+		}
+		cases.PackageCoverageThresholds[pkgPath] = min
+	}
+
 	// Add only the imports we found tests for
 	for pkg := range pkgs {
 		cases.Imports = append(cases.Imports, importMap[pkg])
@@ -329,6 +572,109 @@ func run(args []string) error {
 	return nil
 }
 
+// readQuarantineFile reads names of quarantined tests from a file. Names are
+// listed one per line. Blank lines and lines starting with '#' are ignored.
+func readQuarantineFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, nil
+}
+
+// loadArchives reads type information from the export data in compiled
+// archives. archives maps import names (like "l" and "l_test") to
+// archive files. The returned map has the same keys.
+func loadArchives(archives map[string]string) (map[string]*types.Package, error) {
+	imp := importer.For("gc", func(path string) (io.ReadCloser, error) {
+		file, ok := archives[path]
+		if !ok {
+			return nil, fmt.Errorf("no archive for package %s", path)
+		}
+		return os.Open(file)
+	})
+	pkgs := map[string]*types.Package{}
+	for name := range archives {
+		pkg, err := imp.Import(name)
+		if err != nil {
+			return nil, fmt.Errorf("reading type information for package %s: %v", name, err)
+		}
+		pkgs[name] = pkg
+	}
+	return pkgs, nil
+}
+
+// lookupSignature returns the signature of the package-level function
+// declared in package pkg with the given name. nil is returned if the
+// compiled package has no such function. This may happen if the source file
+// was excluded from the compiled package by build constraints.
+func lookupSignature(pkgTypes map[string]*types.Package, pkg, name string) (*types.Signature, error) {
+	p, ok := pkgTypes[pkg]
+	if !ok {
+		return nil, fmt.Errorf("no archive was provided for package %s", pkg)
+	}
+	fn, ok := p.Scope().Lookup(name).(*types.Func)
+	if !ok {
+		return nil, nil
+	}
+	return fn.Type().(*types.Signature), nil
+}
+
+// isTest returns whether name looks like a test (or benchmark, according to
+// prefix). It is a Test (say) if there is a character after Test that is not
+// a lower-case letter. We don't want TesticularCancer to be a test.
+// This is the same rule go test uses.
+func isTest(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) { // "Test" is ok
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// isTestingFunc returns whether sig is the signature of a function that
+// accepts a single *testing.<arg> parameter and returns nothing.
+func isTestingFunc(sig *types.Signature, arg string) bool {
+	if sig.Params().Len() != 1 || sig.Results().Len() != 0 || sig.Variadic() {
+		return false
+	}
+	ptr, ok := sig.Params().At(0).Type().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	elem := ptr.Elem()
+	// Newer versions of go/types represent aliases explicitly. Resolve them
+	// without depending on types.Unalias.
+	for {
+		alias, ok := elem.(interface{ Rhs() types.Type })
+		if !ok {
+			break
+		}
+		elem = alias.Rhs()
+	}
+	named, ok := elem.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "testing" && obj.Name() == arg
+}
+
+func wrongSignature(pos token.Position, name, arg string) error {
+	return fmt.Errorf("%s: wrong signature for %s, must be: func %s(%s *testing.%s)", pos, name, name, strings.ToLower(arg), arg)
+}
+
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("GoTestGenTest: ")
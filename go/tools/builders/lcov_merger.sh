@@ -17,5 +17,9 @@
 # This is a workaround for bazelbuild/bazel#6293. Since Bazel 0.18.0, Bazel
 # expects tests to have an "$lcov_merger' or "_lcov_merger" attribute that
 # points to an executable. If this is missing, the test driver fails.
+#
+# There's nothing for this script to merge: Go test binaries convert their
+# coverage data to LCOV themselves and write it directly to
+# COVERAGE_OUTPUT_FILE (see go/tools/bzltestutil/lcov.go).
 
 exit 0
@@ -28,9 +28,17 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// buildinfoPath is the import path of the package whose variables are set
+// with information about the binary being linked. See
+// go/tools/buildinfo.
+const buildinfoPath = "github.com/bazelbuild/rules_go/go/tools/buildinfo"
+
 type archive struct {
 	label, pkgPath, file string
 }
@@ -54,6 +62,18 @@ func run(args []string) error {
 	buildmode := flags.String("buildmode", "", "Build mode used.")
 	flags.Var(&stamps, "stamp", "The name of a file with stamping values.")
 	flags.Var(&xstamps, "Xstamp", "A link xdef that may need stamping.")
+	label := flags.String("label", "", "Label of the target being linked.")
+	mode := flags.String("mode", "", "Name of the mode the target is built in.")
+	stampBuildinfo := flags.Bool("stamp_buildinfo", false, "Whether to set stamped values in the buildinfo package.")
+	arcDeps := multiFlag{}
+	flags.Var(&arcDeps, "arcdeps", "Label of a library and labels of its direct dependencies, separated by '='")
+	debugInfo := flags.String("debug_info", "", "If set, debug information is moved from the output file to this file.")
+	objcopy := flags.String("objcopy", "", "Path to objcopy, used with -debug_info.")
+	sbom := flags.String("sbom", "", "If set, a software bill of materials is written to this file.")
+	sbomFormat := flags.String("sbom_format", "spdx", "Format of the bill of materials: spdx or cyclonedx.")
+	licenses := multiFlag{}
+	flags.Var(&licenses, "license", "Label of a library and its license kinds, separated by '=' and ','")
+	allowDuplicates := flags.Bool("allow_duplicate_packages", false, "Whether to link the first of several libraries with the same package path instead of reporting an error")
 	if err := flags.Parse(builderArgs); err != nil {
 		return err
 	}
@@ -94,6 +114,15 @@ func run(args []string) error {
 		}
 	}
 
+	// Check that each package is provided by only one library. The Go linker
+	// can only link one package with a given path.
+	if conflicts := findDuplicatePackages(archives, arcDeps, *label); conflicts != "" {
+		if !*allowDuplicates {
+			return errors.New(conflicts + "Set \"importmap\" to different paths in each library, or remove one of the dependencies.\nTo link the first library listed instead, build with --features=go_allow_duplicate_packages.")
+		}
+		log.Printf("warning: %sThe first library listed for each package is linked.", conflicts)
+	}
+
 	// Build an importcfg file.
 	importcfgName, err := buildImportcfgFile(archives, *packageList, goenv.installSuffix, filepath.Dir(*outFile))
 	if err != nil {
@@ -101,9 +130,10 @@ func run(args []string) error {
 	}
 	defer os.Remove(importcfgName)
 
-	// generate any additional link options we need
-	goargs := goenv.goTool("link")
-	goargs = append(goargs, "-importcfg", importcfgName)
+	// generate any additional link options we need. These are hashed into
+	// the build ID below, so they're collected apart from the tool path and
+	// importcfg file name, which may vary between machines and builds.
+	var linkArgs []string
 	for _, xdef := range xstamps {
 		split := strings.SplitN(xdef, "=", 2)
 		if len(split) != 2 {
@@ -112,12 +142,34 @@ func run(args []string) error {
 		name := split[0]
 		key := split[1]
 		if value, found := stampmap[key]; found {
-			goargs = append(goargs, "-X", fmt.Sprintf("%s=%s", name, value))
+			linkArgs = append(linkArgs, "-X", fmt.Sprintf("%s=%s", name, value))
 		}
 	}
+	var stampBuildinfoMap map[string]string
+	if *stampBuildinfo {
+		stampBuildinfoMap = stampmap
+	}
+	for _, xdef := range buildinfoXdefs(archives, *label, *mode, stampBuildinfoMap) {
+		linkArgs = append(linkArgs, "-X", xdef)
+	}
 
 	if *buildmode != "" {
-		goargs = append(goargs, "-buildmode", *buildmode)
+		linkArgs = append(linkArgs, "-buildmode", *buildmode)
+	}
+	goargs := goenv.goTool("link", "-importcfg", importcfgName)
+	goargs = append(goargs, linkArgs...)
+	if *debugInfo != "" {
+		// The build ID links the stripped binary with its debug file.
+		if *objcopy == "" {
+			return errors.New("-debug_info requires -objcopy")
+		}
+		*debugInfo = abs(*debugInfo)
+		buildIDArgs := append(linkArgs, toolArgs...)
+		buildID, err := linkBuildID(importcfgName, *main, buildIDArgs)
+		if err != nil {
+			return fmt.Errorf("error computing build ID: %v", err)
+		}
+		goargs = append(goargs, "-B", buildID)
 	}
 	goargs = append(goargs, "-o", *outFile)
 
@@ -128,6 +180,36 @@ func run(args []string) error {
 		return err
 	}
 
+	// Standard library packages are listed individually in bills of
+	// materials. They're found in the symbol table, so when it's stripped,
+	// the standard library is listed as a whole.
+	var stdPkgs []string
+	if *sbom != "" && !hasFlag(toolArgs, "-s") {
+		syms, err := readSymbols(goenv, *outFile)
+		if err != nil {
+			return fmt.Errorf("error reading symbols for bill of materials: %v", err)
+		}
+		if stdPkgs, err = stdPackages(syms, *packageList); err != nil {
+			return fmt.Errorf("error reading standard library packages: %v", err)
+		}
+	}
+
+	if *debugInfo != "" {
+		if err := splitDebugInfo(goenv, *objcopy, *outFile, *debugInfo); err != nil {
+			return fmt.Errorf("error splitting debug information: %v", err)
+		}
+	}
+
+	if *sbom != "" {
+		var created time.Time
+		if secs, err := strconv.ParseInt(stampBuildinfoMap["BUILD_TIMESTAMP"], 10, 64); err == nil {
+			created = time.Unix(secs, 0)
+		}
+		if err := writeSBOM(*sbom, *sbomFormat, *label, created, sbomPackages(archives, stdPkgs, licenses)); err != nil {
+			return fmt.Errorf("error writing bill of materials: %v", err)
+		}
+	}
+
 	if *buildmode == "c-archive" {
 		if err := stripArMetadata(*outFile); err != nil {
 			return fmt.Errorf("error stripping archive metadata: %v", err)
@@ -137,6 +219,52 @@ func run(args []string) error {
 	return nil
 }
 
+// buildinfoXdefs returns definitions for variables in the buildinfo package,
+// if it's linked into the binary. Stamped values are read from stampmap, which
+// is nil when stamping is off, so binaries are not re-linked when those
+// values change.
+func buildinfoXdefs(archives []archive, label, mode string, stampmap map[string]string) []string {
+	linked := false
+	depMap := map[string]string{}
+	for _, arc := range archives {
+		if arc.pkgPath == buildinfoPath {
+			linked = true
+		}
+		if _, ok := depMap[arc.pkgPath]; !ok {
+			depMap[arc.pkgPath] = arc.label
+		}
+	}
+	if !linked {
+		return nil
+	}
+	pkgPaths := make([]string, 0, len(depMap))
+	for pkgPath := range depMap {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+	deps := make([]string, len(pkgPaths))
+	for i, pkgPath := range pkgPaths {
+		deps[i] = pkgPath + "=" + depMap[pkgPath]
+	}
+
+	xdefs := []string{
+		buildinfoPath + ".label=" + label,
+		buildinfoPath + ".mode=" + mode,
+		buildinfoPath + ".deps=" + strings.Join(deps, "\n"),
+	}
+	commit := stampmap["STABLE_GIT_COMMIT"]
+	if commit == "" {
+		commit = stampmap["BUILD_SCM_REVISION"]
+	}
+	if commit != "" {
+		xdefs = append(xdefs, buildinfoPath+".gitCommit="+commit)
+	}
+	if timestamp := stampmap["BUILD_TIMESTAMP"]; timestamp != "" {
+		xdefs = append(xdefs, buildinfoPath+".timestamp="+timestamp)
+	}
+	return xdefs
+}
+
 func buildImportcfgFile(archives []archive, packageList, installSuffix, dir string) (string, error) {
 	buf := &bytes.Buffer{}
 	goroot, ok := os.LookupEnv("GOROOT")
@@ -160,19 +288,14 @@ func buildImportcfgFile(archives []archive, packageList, installSuffix, dir stri
 	if err := scanner.Err(); err != nil {
 		return "", err
 	}
-	depsSeen := map[string]string{}
+	depsSeen := map[string]bool{}
 	for _, arc := range archives {
-		if conflictLabel, ok := depsSeen[arc.pkgPath]; ok {
-			// TODO(#1327): link.bzl should report this as a failure after 0.11.0.
-			// At this point, we'll prepare an importcfg file and remove logic here.
-			log.Printf(`warning: package %q is provided by more than one rule:
-    %s
-    %s
-Set "importmap" to different paths in each library.
-This will be an error in the future.`, arc.pkgPath, arc.label, conflictLabel)
+		if depsSeen[arc.pkgPath] {
+			// Conflicts are reported by findDuplicatePackages. If they're
+			// allowed, the first archive is linked.
 			continue
 		}
-		depsSeen[arc.pkgPath] = arc.label
+		depsSeen[arc.pkgPath] = true
 		fmt.Fprintf(buf, "packagefile %s=%s\n", arc.pkgPath, arc.file)
 	}
 	f, err := ioutil.TempFile(dir, "importcfg")
@@ -192,6 +315,16 @@ This will be an error in the future.`, arc.pkgPath, arc.label, conflictLabel)
 	return filename, nil
 }
 
+// hasFlag returns whether args contains the boolean flag name, like "-s".
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == name || arg == name+"=true" {
+			return true
+		}
+	}
+	return false
+}
+
 type archiveMultiFlag []archive
 
 func (m *archiveMultiFlag) String() string {
@@ -0,0 +1,321 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// filePatch is the part of a unified diff that changes one file.
+type filePatch struct {
+	// oldPath and newPath are the paths of the file before and after the
+	// patch, with the first path component removed (like patch -p1). oldPath
+	// is empty when the file is created, and newPath is empty when the file
+	// is deleted.
+	oldPath, newPath string
+
+	hunks []hunk
+}
+
+// hunk is a contiguous change within a file.
+type hunk struct {
+	// oldStart is the line where the change starts in the original file,
+	// counting from 1. If the hunk only adds lines, it's the line after
+	// which they're added.
+	oldStart int
+
+	// old and new are the lines replaced and the lines that replace them,
+	// including context. Each line includes its newline, unless it's the
+	// last line of a file that doesn't end with one.
+	old, new []string
+}
+
+// applyPatch applies a unified diff, like the output of "git diff", to the
+// files in the directory root. Like "patch -p1", the first component of each
+// path in the diff is removed. Hunks must match their context exactly, but
+// they may be found a few lines before or after the line they start on.
+func applyPatch(root string, data []byte) error {
+	patches, err := parsePatch(string(data))
+	if err != nil {
+		return err
+	}
+	for _, p := range patches {
+		if err := applyFilePatch(root, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePatch parses a unified diff. Lines outside of file headers and hunks,
+// like "diff --git" and "index" lines, are ignored.
+func parsePatch(data string) ([]filePatch, error) {
+	lines := strings.SplitAfter(data, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var patches []filePatch
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "rename from ") || strings.HasPrefix(line, "copy from ") || strings.HasPrefix(line, "GIT binary patch"):
+			return nil, fmt.Errorf("line %d: %s: not supported", i+1, strings.TrimSpace(line))
+
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			oldPath, err := patchPath(line[len("--- "):])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			newPath, err := patchPath(lines[i+1][len("+++ "):])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+2, err)
+			}
+			if oldPath == "" && newPath == "" {
+				return nil, fmt.Errorf("line %d: no file to patch", i+1)
+			}
+			patches = append(patches, filePatch{oldPath: oldPath, newPath: newPath})
+			i += 2
+
+		case strings.HasPrefix(line, "@@ "):
+			if len(patches) == 0 {
+				return nil, fmt.Errorf("line %d: hunk before file header", i+1)
+			}
+			h, n, err := parseHunk(lines[i:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			p := &patches[len(patches)-1]
+			p.hunks = append(p.hunks, h)
+			i += n
+
+		default:
+			i++
+		}
+	}
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no files changed")
+	}
+	return patches, nil
+}
+
+// patchPath returns the path named in a "---" or "+++" line with its first
+// component removed, or "" for /dev/null. Absolute paths and paths
+// containing ".." are rejected, so patches can't change files outside the
+// directory they're applied to.
+func patchPath(s string) (string, error) {
+	s = strings.TrimRight(s, "\r\n")
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i] // timestamp
+	}
+	if s == "/dev/null" {
+		return "", nil
+	}
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return "", fmt.Errorf("path %q has no directory to remove", s)
+	}
+	p := s[i+1:]
+	if p == "" || path.IsAbs(p) || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("invalid path %q", s)
+	}
+	return p, nil
+}
+
+// parseHunk parses a hunk starting with its "@@" line. It returns the hunk
+// and the number of lines it spans.
+func parseHunk(lines []string) (hunk, int, error) {
+	var h hunk
+	fields := strings.Fields(lines[0])
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return h, 0, fmt.Errorf("malformed hunk header %q", strings.TrimSpace(lines[0]))
+	}
+	oldStart, oldCount, err := parseRange(fields[1][1:])
+	if err != nil {
+		return h, 0, err
+	}
+	_, newCount, err := parseRange(fields[2][1:])
+	if err != nil {
+		return h, 0, err
+	}
+	h.oldStart = oldStart
+
+	n := 1
+	var last *string
+	for oldCount > 0 || newCount > 0 || (n < len(lines) && strings.HasPrefix(lines[n], `\`)) {
+		if n >= len(lines) {
+			return h, 0, fmt.Errorf("hunk is truncated")
+		}
+		line := lines[n]
+		n++
+		if strings.HasPrefix(line, `\`) {
+			// "\ No newline at end of file" applies to the line before it.
+			if last == nil {
+				return h, 0, fmt.Errorf("unexpected %q", strings.TrimSpace(line))
+			}
+			*last = strings.TrimSuffix(*last, "\n")
+			continue
+		}
+		if line == "\n" {
+			// Some editors strip the space from empty context lines.
+			line = " \n"
+		}
+		text := line[1:]
+		switch line[0] {
+		case ' ':
+			if oldCount == 0 || newCount == 0 {
+				return h, 0, fmt.Errorf("hunk has too many lines")
+			}
+			h.old = append(h.old, text)
+			h.new = append(h.new, text)
+			oldCount--
+			newCount--
+			// A missing newline applies to both copies of a context line.
+			if n < len(lines) && strings.HasPrefix(lines[n], `\`) {
+				h.old[len(h.old)-1] = strings.TrimSuffix(text, "\n")
+			}
+			last = &h.new[len(h.new)-1]
+		case '-':
+			if oldCount == 0 {
+				return h, 0, fmt.Errorf("hunk has too many removed lines")
+			}
+			h.old = append(h.old, text)
+			oldCount--
+			last = &h.old[len(h.old)-1]
+		case '+':
+			if newCount == 0 {
+				return h, 0, fmt.Errorf("hunk has too many added lines")
+			}
+			h.new = append(h.new, text)
+			newCount--
+			last = &h.new[len(h.new)-1]
+		default:
+			return h, 0, fmt.Errorf("unexpected line in hunk: %q", strings.TrimSpace(line))
+		}
+	}
+	return h, n, nil
+}
+
+// parseRange parses a range like "12,3" in a hunk header. The count defaults
+// to 1.
+func parseRange(s string) (start, count int, err error) {
+	count = 1
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		if count, err = strconv.Atoi(s[i+1:]); err != nil {
+			return 0, 0, fmt.Errorf("malformed hunk range %q", s)
+		}
+		s = s[:i]
+	}
+	if start, err = strconv.Atoi(s); err != nil {
+		return 0, 0, fmt.Errorf("malformed hunk range %q", s)
+	}
+	return start, count, nil
+}
+
+// maxHunkOffset is how far from its starting line a hunk may be found.
+const maxHunkOffset = 100
+
+func applyFilePatch(root string, p filePatch) error {
+	var lines []string
+	mode := os.FileMode(0666)
+	if p.oldPath != "" {
+		oldFile := filepath.Join(root, filepath.FromSlash(p.oldPath))
+		fi, err := os.Stat(oldFile)
+		if err != nil {
+			return err
+		}
+		mode = fi.Mode()
+		data, err := ioutil.ReadFile(oldFile)
+		if err != nil {
+			return err
+		}
+		lines = strings.SplitAfter(string(data), "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+	} else if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(p.newPath))); err == nil {
+		return fmt.Errorf("%s: can't create file; it already exists", p.newPath)
+	}
+
+	// Hunks are applied in order. delta is the number of lines added by
+	// earlier hunks, and min is the first line a hunk may change.
+	delta, min := 0, 0
+	for i, h := range p.hunks {
+		want := h.oldStart - 1 + delta
+		if len(h.old) == 0 {
+			want = h.oldStart + delta
+		}
+		pos := findHunk(lines, h.old, want, min)
+		if pos < 0 {
+			name := p.oldPath
+			if name == "" {
+				name = p.newPath
+			}
+			return fmt.Errorf("%s: hunk %d does not apply at line %d", name, i+1, h.oldStart)
+		}
+		updated := append([]string{}, lines[:pos]...)
+		updated = append(updated, h.new...)
+		updated = append(updated, lines[pos+len(h.old):]...)
+		lines = updated
+		delta += len(h.new) - len(h.old)
+		min = pos + len(h.new)
+	}
+
+	if p.oldPath != "" && p.oldPath != p.newPath {
+		if err := os.Remove(filepath.Join(root, filepath.FromSlash(p.oldPath))); err != nil {
+			return err
+		}
+	}
+	if p.newPath == "" {
+		return nil
+	}
+	newFile := filepath.Join(root, filepath.FromSlash(p.newPath))
+	if err := os.MkdirAll(filepath.Dir(newFile), 0777); err != nil {
+		return err
+	}
+	// Files copied from the SDK may be read-only, so the file is replaced
+	// instead of written in place.
+	if err := os.Remove(newFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(newFile, []byte(strings.Join(lines, "")), mode.Perm()|0200)
+}
+
+// findHunk returns the index in lines where old is found, searching outward
+// from want but not before min. It returns -1 if old isn't found.
+func findHunk(lines, old []string, want, min int) int {
+	for offset := 0; offset <= maxHunkOffset; offset++ {
+		for _, pos := range []int{want - offset, want + offset} {
+			if pos >= min && pos+len(old) <= len(lines) && linesEqual(lines[pos:pos+len(old)], old) {
+				return pos
+			}
+		}
+	}
+	return -1
+}
+
+func linesEqual(a, b []string) bool {
+	for i := range b {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
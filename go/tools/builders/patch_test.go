@@ -0,0 +1,212 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	for _, tc := range []struct {
+		desc, patch string
+		files, want map[string]string
+		wantErr     string
+	}{
+		{
+			desc: "modify",
+			files: map[string]string{
+				"src/a/a.go": "package a\n\nfunc A() int {\n\treturn 1\n}\n",
+			},
+			patch: `diff --git a/src/a/a.go b/src/a/a.go
+index 1111111..2222222 100644
+--- a/src/a/a.go
++++ b/src/a/a.go
+@@ -2,4 +2,4 @@ package a
+
+ func A() int {
+-	return 1
++	return 2
+ }
+`,
+			want: map[string]string{
+				"src/a/a.go": "package a\n\nfunc A() int {\n\treturn 2\n}\n",
+			},
+		}, {
+			desc: "offset",
+			files: map[string]string{
+				"a.go": "// extra\n// lines\npackage a\n\nvar x = 1\n",
+			},
+			patch: `--- a/a.go
++++ b/a.go
+@@ -1,3 +1,3 @@
+ package a
+
+-var x = 1
++var x = 2
+`,
+			want: map[string]string{
+				"a.go": "// extra\n// lines\npackage a\n\nvar x = 2\n",
+			},
+		}, {
+			desc: "multiple hunks",
+			files: map[string]string{
+				"a.txt": "1\n2\n3\n4\n5\n6\n7\n8\n",
+			},
+			patch: `--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,3 @@
+ 1
++1.5
+ 2
+@@ -7,2 +8,1 @@
+ 7
+-8
+`,
+			want: map[string]string{
+				"a.txt": "1\n1.5\n2\n3\n4\n5\n6\n7\n",
+			},
+		}, {
+			desc: "create and delete",
+			files: map[string]string{
+				"src/old.go": "package old\n",
+			},
+			patch: `diff --git a/src/new/new.go b/src/new/new.go
+new file mode 100644
+--- /dev/null
++++ b/src/new/new.go
+@@ -0,0 +1,2 @@
++package new
++
+diff --git a/src/old.go b/src/old.go
+deleted file mode 100644
+--- a/src/old.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package old
+`,
+			want: map[string]string{
+				"src/new/new.go": "package new\n\n",
+				"src/old.go":     "",
+			},
+		}, {
+			desc: "no newline at end of file",
+			files: map[string]string{
+				"a.txt": "a\nb",
+			},
+			patch: `--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++c
+`,
+			want: map[string]string{
+				"a.txt": "a\nc\n",
+			},
+		}, {
+			desc: "context mismatch",
+			files: map[string]string{
+				"a.txt": "a\nb\n",
+			},
+			patch: `--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,2 @@
+ x
+-b
++c
+`,
+			wantErr: "hunk 1 does not apply",
+		}, {
+			desc: "create existing file",
+			files: map[string]string{
+				"a.txt": "a\n",
+			},
+			patch: `--- /dev/null
++++ b/a.txt
+@@ -0,0 +1 @@
++a
+`,
+			wantErr: "already exists",
+		}, {
+			desc: "escape root",
+			patch: `--- a/../a.txt
++++ b/../a.txt
+@@ -1 +1 @@
+-a
++b
+`,
+			wantErr: "invalid path",
+		}, {
+			desc: "truncated",
+			files: map[string]string{
+				"a.txt": "a\nb\n",
+			},
+			patch: `--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,2 @@
+ a
+`,
+			wantErr: "truncated",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "TestApplyPatch")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range tc.files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+					t.Fatal(err)
+				}
+				// Files copied from the SDK may be read-only.
+				if err := ioutil.WriteFile(path, []byte(content), 0444); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = applyPatch(dir, []byte(tc.patch))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tc.want {
+				data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if want == "" {
+					if !os.IsNotExist(err) {
+						t.Errorf("%s: got error %v; want file to be deleted", name, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+				} else if got := string(data); got != want {
+					t.Errorf("%s: got %q; want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
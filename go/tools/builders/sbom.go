@@ -0,0 +1,404 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sbomPackage is a Go package listed in a software bill of materials.
+type sbomPackage struct {
+	// PackagePath is the path the package was linked with.
+	PackagePath string
+
+	// Label is the label of the rule that provided the package. It's empty
+	// for the standard library.
+	Label string
+
+	// Repository is the name of the Bazel repository the package came from.
+	// It's empty for the main repository and the standard library.
+	Repository string
+
+	// Version is the version of the package, if known. Only the standard
+	// library has a version.
+	Version string
+
+	// Licenses are the license kinds declared for Label, for example,
+	// "notice" or "reciprocal".
+	Licenses []string
+}
+
+// stdlibSBOMPath is the package path used for the standard library when
+// the packages linked from it aren't known.
+const stdlibSBOMPath = "std"
+
+// sbomPackages returns the packages to list in a bill of materials for
+// a binary linked from archives, sorted by package path. If more than one
+// archive provides a package, only the first is listed, since only the first
+// is linked. stdPkgs are the standard library packages linked into the
+// binary; if it's nil, the standard library is listed as a single package.
+// licenses is a list of labels followed by their license kinds, separated by
+// '=' and ','.
+func sbomPackages(archives []archive, stdPkgs []string, licenses []string) []sbomPackage {
+	kindsByLabel := map[string][]string{}
+	for _, l := range licenses {
+		i := strings.Index(l, "=")
+		if i < 0 {
+			continue
+		}
+		kindsByLabel[l[:i]] = strings.Split(l[i+1:], ",")
+	}
+
+	// The builders are compiled with the same Go SDK that's used to link
+	// the binary, so the runtime version is the standard library version.
+	var pkgs []sbomPackage
+	if stdPkgs == nil {
+		pkgs = append(pkgs, sbomPackage{PackagePath: stdlibSBOMPath, Version: runtime.Version()})
+	}
+	for _, pkg := range stdPkgs {
+		pkgs = append(pkgs, sbomPackage{PackagePath: pkg, Version: runtime.Version()})
+	}
+	seen := map[string]bool{}
+	for _, arc := range archives {
+		if seen[arc.pkgPath] {
+			continue
+		}
+		seen[arc.pkgPath] = true
+		pkgs = append(pkgs, sbomPackage{
+			PackagePath: arc.pkgPath,
+			Label:       arc.label,
+			Repository:  labelRepository(arc.label),
+			Licenses:    kindsByLabel[arc.label],
+		})
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].PackagePath < pkgs[j].PackagePath
+	})
+	return pkgs
+}
+
+// stdPackages returns the sorted paths of the standard library packages that
+// have symbols in syms. packageList is a file listing the packages in the
+// standard library.
+func stdPackages(syms []symbol, packageList string) ([]string, error) {
+	data, err := ioutil.ReadFile(packageList)
+	if err != nil {
+		return nil, err
+	}
+	std := map[string]bool{}
+	for _, pkg := range strings.Fields(string(data)) {
+		std[pkg] = true
+	}
+	found := map[string]bool{}
+	pkgs := []string{}
+	for _, sym := range syms {
+		pkg := symbolPackage(sym.name)
+		if std[pkg] && !found[pkg] {
+			found[pkg] = true
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Strings(pkgs)
+	return pkgs, nil
+}
+
+// labelRepository returns the name of the repository in label, or "" if
+// label is in the main repository.
+func labelRepository(label string) string {
+	if !strings.HasPrefix(label, "@") {
+		return ""
+	}
+	if i := strings.Index(label, "//"); i >= 0 {
+		return label[1:i]
+	}
+	return label[1:]
+}
+
+// writeSBOM writes a bill of materials for the binary built by label to path.
+// format is either "spdx" or "cyclonedx". created is the time the binary was
+// built; if it's zero, a fixed time is used so the output is reproducible.
+func writeSBOM(path, format, label string, created time.Time, pkgs []sbomPackage) error {
+	buf := &bytes.Buffer{}
+	var err error
+	switch format {
+	case "spdx":
+		err = writeSPDX(buf, label, created, pkgs)
+	case "cyclonedx":
+		err = writeCycloneDX(buf, label, created, pkgs)
+	default:
+		err = fmt.Errorf("unknown bill of materials format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0666)
+}
+
+type spdxDocument struct {
+	SPDXVersion                string                 `json:"spdxVersion"`
+	DataLicense                string                 `json:"dataLicense"`
+	SPDXID                     string                 `json:"SPDXID"`
+	Name                       string                 `json:"name"`
+	DocumentNamespace          string                 `json:"documentNamespace"`
+	CreationInfo               spdxCreationInfo       `json:"creationInfo"`
+	Packages                   []spdxPackage          `json:"packages"`
+	Relationships              []spdxRelationship     `json:"relationships"`
+	HasExtractedLicensingInfos []spdxExtractedLicense `json:"hasExtractedLicensingInfos,omitempty"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string `json:"name"`
+	SPDXID           string `json:"SPDXID"`
+	VersionInfo      string `json:"versionInfo,omitempty"`
+	DownloadLocation string `json:"downloadLocation"`
+	FilesAnalyzed    bool   `json:"filesAnalyzed"`
+	LicenseConcluded string `json:"licenseConcluded"`
+	LicenseDeclared  string `json:"licenseDeclared"`
+	CopyrightText    string `json:"copyrightText"`
+	SourceInfo       string `json:"sourceInfo,omitempty"`
+}
+
+type spdxExtractedLicense struct {
+	LicenseID     string `json:"licenseId"`
+	ExtractedText string `json:"extractedText"`
+	Name          string `json:"name"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// writeSPDX writes an SPDX 2.2 document in JSON format. The binary is
+// described by the document and contains a package for each Go package.
+// Bazel license kinds aren't SPDX license identifiers, so they're written
+// as license references (see spdxLicenseRef), each with an entry in
+// hasExtractedLicensingInfos.
+func writeSPDX(w io.Writer, label string, created time.Time, pkgs []sbomPackage) error {
+	if created.IsZero() {
+		created = time.Unix(0, 0)
+	}
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.2",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              label,
+		DocumentNamespace: "https://github.com/bazelbuild/rules_go/spdx/" + sbomDigest(label, pkgs),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: rules_go"},
+		},
+	}
+	doc.Packages = append(doc.Packages, spdxPackage{
+		Name:             label,
+		SPDXID:           "SPDXRef-Binary",
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+		CopyrightText:    "NOASSERTION",
+	})
+	doc.Relationships = append(doc.Relationships, spdxRelationship{
+		SPDXElementID:      "SPDXRef-DOCUMENT",
+		RelationshipType:   "DESCRIBES",
+		RelatedSPDXElement: "SPDXRef-Binary",
+	})
+	extracted := map[string]bool{}
+	for i, p := range pkgs {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i)
+		declared := "NOASSERTION"
+		if len(p.Licenses) > 0 {
+			refs := make([]string, len(p.Licenses))
+			for j, kind := range p.Licenses {
+				ref := spdxLicenseRef(kind)
+				refs[j] = ref
+				if !extracted[ref] {
+					extracted[ref] = true
+					doc.HasExtractedLicensingInfos = append(doc.HasExtractedLicensingInfos, spdxExtractedLicense{
+						LicenseID:     ref,
+						ExtractedText: fmt.Sprintf("Bazel license kind %q. The license text is not known.", kind),
+						Name:          kind,
+					})
+				}
+			}
+			declared = strings.Join(refs, " AND ")
+		}
+		sourceInfo := ""
+		if p.Label != "" {
+			sourceInfo = "built from " + p.Label
+		}
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             p.PackagePath,
+			SPDXID:           id,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  declared,
+			CopyrightText:    "NOASSERTION",
+			SourceInfo:       sourceInfo,
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      "SPDXRef-Binary",
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+	sort.Slice(doc.HasExtractedLicensingInfos, func(i, j int) bool {
+		return doc.HasExtractedLicensingInfos[i].LicenseID < doc.HasExtractedLicensingInfos[j].LicenseID
+	})
+	return writeJSON(w, doc)
+}
+
+// spdxLicenseRef returns an SPDX license reference for a Bazel license kind.
+// References may only contain letters, digits, '.', and '-', so other
+// characters (like the '_' in "by_exception_only") are replaced with '-'.
+func spdxLicenseRef(kind string) string {
+	id := []byte(kind)
+	for i, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '-') {
+			id[i] = '-'
+		}
+	}
+	return "LicenseRef-" + string(id)
+}
+
+type cycloneDXDocument struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp,omitempty"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	BOMRef     string              `json:"bom-ref"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// writeCycloneDX writes a CycloneDX 1.4 document in JSON format. The binary is
+// the metadata component, and each Go package is a library component. Labels
+// and repositories are recorded as "bazel:label" and "bazel:repository"
+// properties. Standard library packages are identified as subpaths of the
+// std module at the Go version, like pkg:golang/std@go1.11.2#net/http.
+func writeCycloneDX(w io.Writer, label string, created time.Time, pkgs []sbomPackage) error {
+	digest := sbomDigest(label, pkgs)
+	doc := cycloneDXDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.4",
+		SerialNumber: fmt.Sprintf("urn:uuid:%s-%s-%s-%s-%s", digest[0:8], digest[8:12], digest[12:16], digest[16:20], digest[20:32]),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Component: cycloneDXComponent{
+				Type:   "application",
+				BOMRef: label,
+				Name:   label,
+			},
+		},
+	}
+	if !created.IsZero() {
+		doc.Metadata.Timestamp = created.UTC().Format(time.RFC3339)
+	}
+	for _, p := range pkgs {
+		c := cycloneDXComponent{
+			Type:    "library",
+			BOMRef:  p.PackagePath,
+			Name:    p.PackagePath,
+			Version: p.Version,
+			PURL:    cycloneDXPURL(p),
+		}
+		for _, kind := range p.Licenses {
+			var l cycloneDXLicense
+			l.License.Name = kind
+			c.Licenses = append(c.Licenses, l)
+		}
+		if p.Label != "" {
+			c.Properties = append(c.Properties, cycloneDXProperty{Name: "bazel:label", Value: p.Label})
+		}
+		if p.Repository != "" {
+			c.Properties = append(c.Properties, cycloneDXProperty{Name: "bazel:repository", Value: p.Repository})
+		}
+		doc.Components = append(doc.Components, c)
+	}
+	return writeJSON(w, doc)
+}
+
+func cycloneDXPURL(p sbomPackage) string {
+	if p.Label != "" {
+		return "pkg:golang/" + p.PackagePath
+	}
+	purl := "pkg:golang/std@" + p.Version
+	if p.PackagePath != stdlibSBOMPath {
+		purl += "#" + p.PackagePath
+	}
+	return purl
+}
+
+// sbomDigest returns a hex digest of the binary label and its packages. It's
+// used to build unique, reproducible document identifiers.
+func sbomDigest(label string, pkgs []sbomPackage) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n", label)
+	for _, p := range pkgs {
+		fmt.Fprintf(h, "%s=%s\n", p.PackagePath, p.Label)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var sbomTestArchives = []archive{
+	{label: "//a:a", pkgPath: "example.com/a"},
+	{label: "@org_golang_x_text//unicode/norm:go_default_library", pkgPath: "golang.org/x/text/unicode/norm"},
+	{label: "//vendor/example.com/lib:lib", pkgPath: "example.com/lib"},
+	{label: "@lib//:lib", pkgPath: "example.com/lib"},
+}
+
+var sbomTestStdPkgs = []string{"fmt", "runtime"}
+
+var sbomTestLicenses = []string{
+	"//a:a=by_exception_only,notice",
+	"@org_golang_x_text//unicode/norm:go_default_library=notice,reciprocal",
+}
+
+func TestSBOMPackages(t *testing.T) {
+	got := sbomPackages(sbomTestArchives, sbomTestStdPkgs, sbomTestLicenses)
+	want := []sbomPackage{
+		{PackagePath: "example.com/a", Label: "//a:a", Licenses: []string{"by_exception_only", "notice"}},
+		{PackagePath: "example.com/lib", Label: "//vendor/example.com/lib:lib"},
+		{PackagePath: "fmt", Version: runtime.Version()},
+		{
+			PackagePath: "golang.org/x/text/unicode/norm",
+			Label:       "@org_golang_x_text//unicode/norm:go_default_library",
+			Repository:  "org_golang_x_text",
+			Licenses:    []string{"notice", "reciprocal"},
+		},
+		{PackagePath: "runtime", Version: runtime.Version()},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+
+	// When the linked standard library packages aren't known, the standard
+	// library is listed as a whole.
+	got = sbomPackages(sbomTestArchives[:1], nil, nil)
+	want = []sbomPackage{
+		{PackagePath: "example.com/a", Label: "//a:a"},
+		{PackagePath: "std", Version: runtime.Version()},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestStdPackages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestStdPackages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	packageList := filepath.Join(dir, "packages.txt")
+	if err := ioutil.WriteFile(packageList, []byte("fmt\nnet/http\nruntime\nvendor/golang.org/x/net/http2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	syms := []symbol{
+		{name: "main.main"},
+		{name: "runtime.mallocgc"},
+		{name: "fmt.Println"},
+		{name: "fmt.(*pp).doPrintln"},
+		{name: "vendor/golang.org/x/net/http2.(*Framer).WriteData"},
+		{name: "example.com/a.F"},
+		{name: "go.buildid"},
+	}
+	got, err := stdPackages(syms, packageList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"fmt", "runtime", "vendor/golang.org/x/net/http2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestLabelRepository(t *testing.T) {
+	for _, tc := range []struct {
+		label, want string
+	}{
+		{"//a:a", ""},
+		{"@lib//:lib", "lib"},
+		{"@org_golang_x_text//unicode/norm:go_default_library", "org_golang_x_text"},
+	} {
+		if got := labelRepository(tc.label); got != tc.want {
+			t.Errorf("labelRepository(%q): got %q; want %q", tc.label, got, tc.want)
+		}
+	}
+}
+
+func TestWriteSPDX(t *testing.T) {
+	pkgs := sbomPackages(sbomTestArchives, sbomTestStdPkgs, sbomTestLicenses)
+	buf := &bytes.Buffer{}
+	if err := writeSPDX(buf, "//cmd:cmd", time.Time{}, pkgs); err != nil {
+		t.Fatal(err)
+	}
+	var doc spdxDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SPDXVersion != "SPDX-2.2" || doc.Name != "//cmd:cmd" {
+		t.Errorf("got version %q, name %q; want SPDX-2.2, //cmd:cmd", doc.SPDXVersion, doc.Name)
+	}
+	if doc.CreationInfo.Created != "1970-01-01T00:00:00Z" {
+		t.Errorf("got creation time %q; want the Unix epoch", doc.CreationInfo.Created)
+	}
+	if len(doc.Packages) != len(pkgs)+1 || len(doc.Relationships) != len(pkgs)+1 {
+		t.Fatalf("got %d packages and %d relationships; want %d of each", len(doc.Packages), len(doc.Relationships), len(pkgs)+1)
+	}
+	norm := doc.Packages[4]
+	if norm.Name != "golang.org/x/text/unicode/norm" ||
+		norm.LicenseDeclared != "LicenseRef-notice AND LicenseRef-reciprocal" ||
+		norm.SourceInfo != "built from @org_golang_x_text//unicode/norm:go_default_library" {
+		t.Errorf("got package %#v", norm)
+	}
+	if lib := doc.Packages[2]; lib.LicenseDeclared != "NOASSERTION" {
+		t.Errorf("got declared license %q for %s; want NOASSERTION", lib.LicenseDeclared, lib.Name)
+	}
+
+	// License references are valid SPDX identifiers, and each is defined.
+	if a := doc.Packages[1]; a.LicenseDeclared != "LicenseRef-by-exception-only AND LicenseRef-notice" {
+		t.Errorf("got declared license %q for %s", a.LicenseDeclared, a.Name)
+	}
+	var gotRefs []string
+	for _, l := range doc.HasExtractedLicensingInfos {
+		if l.ExtractedText == "" {
+			t.Errorf("license %s has no extracted text", l.LicenseID)
+		}
+		gotRefs = append(gotRefs, l.LicenseID)
+	}
+	wantRefs := []string{"LicenseRef-by-exception-only", "LicenseRef-notice", "LicenseRef-reciprocal"}
+	if !reflect.DeepEqual(gotRefs, wantRefs) {
+		t.Errorf("got extracted licenses %q; want %q", gotRefs, wantRefs)
+	}
+
+	// Documents are reproducible.
+	buf2 := &bytes.Buffer{}
+	if err := writeSPDX(buf2, "//cmd:cmd", time.Time{}, pkgs); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), buf2.Bytes()) {
+		t.Errorf("documents differ:\n%s\n%s", buf.Bytes(), buf2.Bytes())
+	}
+}
+
+func TestWriteCycloneDX(t *testing.T) {
+	pkgs := sbomPackages(sbomTestArchives, sbomTestStdPkgs, sbomTestLicenses)
+	buf := &bytes.Buffer{}
+	if err := writeCycloneDX(buf, "//cmd:cmd", time.Unix(1540000000, 0), pkgs); err != nil {
+		t.Fatal(err)
+	}
+	var doc cycloneDXDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.BOMFormat != "CycloneDX" || doc.Metadata.Component.Name != "//cmd:cmd" {
+		t.Errorf("got format %q, component %q; want CycloneDX, //cmd:cmd", doc.BOMFormat, doc.Metadata.Component.Name)
+	}
+	if doc.Metadata.Timestamp != "2018-10-20T01:46:40Z" {
+		t.Errorf("got timestamp %q", doc.Metadata.Timestamp)
+	}
+	if len(doc.Components) != len(pkgs) {
+		t.Fatalf("got %d components; want %d", len(doc.Components), len(pkgs))
+	}
+	norm := doc.Components[3]
+	wantProps := []cycloneDXProperty{
+		{Name: "bazel:label", Value: "@org_golang_x_text//unicode/norm:go_default_library"},
+		{Name: "bazel:repository", Value: "org_golang_x_text"},
+	}
+	if norm.PURL != "pkg:golang/golang.org/x/text/unicode/norm" ||
+		len(norm.Licenses) != 2 || norm.Licenses[1].License.Name != "reciprocal" ||
+		!reflect.DeepEqual(norm.Properties, wantProps) {
+		t.Errorf("got component %#v", norm)
+	}
+	if fmt := doc.Components[2]; fmt.Name != "fmt" || fmt.Version != runtime.Version() ||
+		fmt.PURL != "pkg:golang/std@"+runtime.Version()+"#fmt" {
+		t.Errorf("got component %#v; want fmt at %s", fmt, runtime.Version())
+	}
+}
+
+func TestCycloneDXPURL(t *testing.T) {
+	for _, tc := range []struct {
+		pkg  sbomPackage
+		want string
+	}{
+		{sbomPackage{PackagePath: "example.com/a", Label: "//a:a"}, "pkg:golang/example.com/a"},
+		{sbomPackage{PackagePath: "net/http", Version: "go1.11.2"}, "pkg:golang/std@go1.11.2#net/http"},
+		{sbomPackage{PackagePath: "std", Version: "go1.11.2"}, "pkg:golang/std@go1.11.2"},
+	} {
+		if got := cycloneDXPURL(tc.pkg); got != tc.want {
+			t.Errorf("cycloneDXPURL(%q): got %q; want %q", tc.pkg.PackagePath, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// size_report breaks down the size of a linked binary by Go package and by
+// the label of the rule that provided each package. It's invoked by the Go
+// rules as an action when the size_report output group of a go_binary is
+// built.
+//
+// With -diff, size_report compares two reports written earlier and prints
+// the differences. This may be run directly:
+//
+//	bazel run @io_bazel_rules_go//go/tools/builders:size_report -- -diff old.json new.json
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// stdlibLabel is reported as the label of standard library packages.
+	stdlibLabel = "(standard library)"
+
+	// unattributedLabel is reported as the label of symbols that can't be
+	// attributed to a package, like symbols from C code and symbols the
+	// linker synthesizes.
+	unattributedLabel = "(unattributed)"
+)
+
+// sizeReport is the JSON form of a size report.
+type sizeReport struct {
+	// Label is the label of the binary.
+	Label string `json:"label"`
+
+	// FileSize is the size of the binary file. SymbolSize is the total size
+	// of symbols in the binary. The difference is made up of headers, debug
+	// information, and the symbol table itself.
+	FileSize   int64 `json:"file_size"`
+	SymbolSize int64 `json:"symbol_size"`
+
+	// Packages and Labels list the total size of symbols attributed to each
+	// package and each label, largest first.
+	Packages []packageSize `json:"packages"`
+	Labels   []labelSize   `json:"labels"`
+}
+
+type packageSize struct {
+	Package string `json:"package"`
+	Label   string `json:"label"`
+	Size    int64  `json:"size"`
+}
+
+type labelSize struct {
+	Label    string `json:"label"`
+	Size     int64  `json:"size"`
+	Packages int    `json:"packages"`
+}
+
+func run(args []string) error {
+	args, err := readParamsFiles(args)
+	if err != nil {
+		return err
+	}
+	pkgs := multiFlag{}
+	flags := flag.NewFlagSet("size_report", flag.ExitOnError)
+	goenv := envFlags(flags)
+	binary := flags.String("binary", "", "Path to the linked binary.")
+	label := flags.String("label", "", "Label of the binary.")
+	packageList := flags.String("package_list", "", "File listing standard library packages.")
+	flags.Var(&pkgs, "pkg", "Package path and label of a library linked into the binary, separated by '='")
+	jsonOut := flags.String("json", "", "Path to the JSON report to write.")
+	summaryOut := flags.String("summary", "", "Path to the human-readable summary to write.")
+	diff := flags.Bool("diff", false, "Compare two JSON reports given as positional arguments.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *diff {
+		if flags.NArg() != 2 {
+			return errors.New("-diff requires two reports: old.json new.json")
+		}
+		oldReport, err := readSizeReport(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		newReport, err := readSizeReport(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		writeSizeDiff(os.Stdout, oldReport, newReport)
+		return nil
+	}
+
+	if err := goenv.checkFlags(); err != nil {
+		return err
+	}
+	if *binary == "" {
+		return errors.New("-binary must be set")
+	}
+	labels := map[string]string{"main": *label}
+	for _, p := range pkgs {
+		i := strings.Index(p, "=")
+		if i < 0 {
+			return fmt.Errorf("badly formed -pkg flag: %s", p)
+		}
+		labels[p[:i]] = p[i+1:]
+	}
+	if *packageList != "" {
+		std, err := ioutil.ReadFile(*packageList)
+		if err != nil {
+			return err
+		}
+		for _, pkg := range strings.Fields(string(std)) {
+			if _, ok := labels[pkg]; !ok {
+				labels[pkg] = stdlibLabel
+			}
+		}
+	}
+
+	fi, err := os.Stat(*binary)
+	if err != nil {
+		return err
+	}
+	syms, err := readSymbols(goenv, *binary)
+	if err != nil {
+		return err
+	}
+	report := buildSizeReport(*label, fi.Size(), syms, labels)
+
+	if *jsonOut != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*jsonOut, append(data, '\n'), 0666); err != nil {
+			return err
+		}
+	}
+	if *summaryOut != "" {
+		buf := &bytes.Buffer{}
+		writeSizeSummary(buf, report)
+		if err := ioutil.WriteFile(*summaryOut, buf.Bytes(), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildSizeReport attributes the sizes of syms to packages and labels.
+// labels maps package paths to the labels that provided them.
+func buildSizeReport(label string, fileSize int64, syms []symbol, labels map[string]string) *sizeReport {
+	report := &sizeReport{Label: label, FileSize: fileSize}
+	pkgSizes := map[string]int64{}
+	for _, sym := range syms {
+		report.SymbolSize += sym.size
+		pkgSizes[symbolPackage(sym.name)] += sym.size
+	}
+
+	labelSizes := map[string]*labelSize{}
+	for pkg, size := range pkgSizes {
+		l, ok := labels[pkg]
+		if !ok {
+			l = unattributedLabel
+		}
+		report.Packages = append(report.Packages, packageSize{Package: pkg, Label: l, Size: size})
+		ls := labelSizes[l]
+		if ls == nil {
+			ls = &labelSize{Label: l}
+			labelSizes[l] = ls
+		}
+		ls.Size += size
+		ls.Packages++
+	}
+	for _, ls := range labelSizes {
+		report.Labels = append(report.Labels, *ls)
+	}
+	sort.Slice(report.Packages, func(i, j int) bool {
+		pi, pj := report.Packages[i], report.Packages[j]
+		if pi.Size != pj.Size {
+			return pi.Size > pj.Size
+		}
+		return pi.Package < pj.Package
+	})
+	sort.Slice(report.Labels, func(i, j int) bool {
+		li, lj := report.Labels[i], report.Labels[j]
+		if li.Size != lj.Size {
+			return li.Size > lj.Size
+		}
+		return li.Label < lj.Label
+	})
+	return report
+}
+
+func writeSizeSummary(w io.Writer, report *sizeReport) {
+	fmt.Fprintf(w, "Size of %s\n", report.Label)
+	fmt.Fprintf(w, "File size:   %s\n", formatSize(report.FileSize))
+	fmt.Fprintf(w, "Symbol size: %s\n\n", formatSize(report.SymbolSize))
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "SIZE\tPERCENT\tPACKAGES\t  LABEL\n")
+	for _, ls := range report.Labels {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t  %s\n", formatSize(ls.Size), formatPercent(ls.Size, report.SymbolSize), ls.Packages, ls.Label)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "SIZE\tPERCENT\t  PACKAGE\n")
+	for _, ps := range report.Packages {
+		pkg := ps.Package
+		if pkg == "" {
+			pkg = unattributedLabel
+		}
+		fmt.Fprintf(tw, "%s\t%s\t  %s\n", formatSize(ps.Size), formatPercent(ps.Size, report.SymbolSize), pkg)
+	}
+	tw.Flush()
+}
+
+func readSizeReport(path string) (*sizeReport, error) {
+	if !filepath.IsAbs(path) {
+		// When run with "bazel run", paths are relative to the directory
+		// Bazel was invoked in.
+		if wd := os.Getenv("BUILD_WORKING_DIRECTORY"); wd != "" {
+			path = filepath.Join(wd, path)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &sizeReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return report, nil
+}
+
+// sizeChange is a difference in size between two reports.
+type sizeChange struct {
+	name                 string
+	oldSize, newSize     int64
+	oldExists, newExists bool
+}
+
+func (c sizeChange) delta() int64 {
+	return c.newSize - c.oldSize
+}
+
+// writeSizeDiff prints the differences in size between two reports, by label
+// and by package, largest first. Unchanged labels and packages are omitted.
+func writeSizeDiff(w io.Writer, oldReport, newReport *sizeReport) {
+	fmt.Fprintf(w, "File size:   %s -> %s (%s)\n", formatSize(oldReport.FileSize), formatSize(newReport.FileSize), formatDelta(newReport.FileSize-oldReport.FileSize))
+	fmt.Fprintf(w, "Symbol size: %s -> %s (%s)\n", formatSize(oldReport.SymbolSize), formatSize(newReport.SymbolSize), formatDelta(newReport.SymbolSize-oldReport.SymbolSize))
+
+	labelChanges := map[string]*sizeChange{}
+	for _, ls := range oldReport.Labels {
+		labelChanges[ls.Label] = &sizeChange{name: ls.Label, oldSize: ls.Size, oldExists: true}
+	}
+	for _, ls := range newReport.Labels {
+		c := labelChanges[ls.Label]
+		if c == nil {
+			c = &sizeChange{name: ls.Label}
+			labelChanges[ls.Label] = c
+		}
+		c.newSize, c.newExists = ls.Size, true
+	}
+	pkgChanges := map[string]*sizeChange{}
+	for _, ps := range oldReport.Packages {
+		pkgChanges[ps.Package] = &sizeChange{name: ps.Package, oldSize: ps.Size, oldExists: true}
+	}
+	for _, ps := range newReport.Packages {
+		c := pkgChanges[ps.Package]
+		if c == nil {
+			c = &sizeChange{name: ps.Package}
+			pkgChanges[ps.Package] = c
+		}
+		c.newSize, c.newExists = ps.Size, true
+	}
+
+	writeChanges(w, "LABEL", labelChanges)
+	writeChanges(w, "PACKAGE", pkgChanges)
+}
+
+func writeChanges(w io.Writer, heading string, changes map[string]*sizeChange) {
+	var sorted []sizeChange
+	for _, c := range changes {
+		if c.delta() != 0 || c.oldExists != c.newExists {
+			sorted = append(sorted, *c)
+		}
+	}
+	if len(sorted) == 0 {
+		return
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		di, dj := sorted[i].delta(), sorted[j].delta()
+		if di < 0 {
+			di = -di
+		}
+		if dj < 0 {
+			dj = -dj
+		}
+		if di != dj {
+			return di > dj
+		}
+		return sorted[i].name < sorted[j].name
+	})
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "CHANGE\tOLD\tNEW\t  %s\n", heading)
+	for _, c := range sorted {
+		name := c.name
+		if name == "" {
+			name = unattributedLabel
+		}
+		oldSize, newSize := formatSize(c.oldSize), formatSize(c.newSize)
+		if !c.oldExists {
+			oldSize = "-"
+		}
+		if !c.newExists {
+			newSize = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t  %s\n", formatDelta(c.delta()), oldSize, newSize, name)
+	}
+	tw.Flush()
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+func formatDelta(n int64) string {
+	if n < 0 {
+		return "-" + formatSize(-n)
+	}
+	return "+" + formatSize(n)
+}
+
+func formatPercent(n, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("GoSizeReport: ")
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNm(t *testing.T) {
+	out := `  4a1000         12 T main.main
+  4b2000        340 R type.struct { F example.com/a.T }
+                  0 U _cgo_panic
+  4c3000         64 D example.com/a.(*T).M
+`
+	got, err := parseNm(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []symbol{
+		{name: "main.main", size: 12},
+		{name: "type.struct { F example.com/a.T }", size: 340},
+		{name: "example.com/a.(*T).M", size: 64},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestSymbolPackage(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"main.main", "main"},
+		{"runtime.mallocgc", "runtime"},
+		{"example.com/a.Foo.func1", "example.com/a"},
+		{"example.com/a.(*T).M", "example.com/a"},
+		{"example.com/m/yaml%2ev2.Marshal", "example.com/m/yaml.v2"},
+		{"type.*example.com/m/yaml%2ev2.Node", "example.com/m/yaml.v2"},
+		{"vendor/golang.org/x/net/http2.(*Framer).WriteData", "vendor/golang.org/x/net/http2"},
+		{"type.*example.com/a.T", "example.com/a"},
+		{"type..eq.example.com/a.T", "example.com/a"},
+		{"go.itab.*example.com/a.T,io.Reader", "example.com/a"},
+		{"go.buildid", ""},
+		{"type.struct { F int }", ""},
+		{"_cgo_panic", ""},
+	} {
+		if got := symbolPackage(tc.name); got != tc.want {
+			t.Errorf("symbolPackage(%q): got %q; want %q", tc.name, got, tc.want)
+		}
+	}
+}
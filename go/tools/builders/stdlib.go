@@ -19,6 +19,7 @@ import (
 	"flag"
 	"fmt"
 	"go/build"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -38,6 +39,10 @@ func run(args []string) error {
 	race := flags.Bool("race", false, "Build in race mode")
 	shared := flags.Bool("shared", false, "Build in shared mode")
 	dynlink := flags.Bool("dynlink", false, "Build in dynlink mode")
+	var gcflagsExtra, asmflagsExtra, patches multiFlag
+	flags.Var(&gcflagsExtra, "gcflag", "Additional flag to pass to the compiler")
+	flags.Var(&asmflagsExtra, "asmflag", "Additional flag to pass to the assembler")
+	flags.Var(&patches, "patch", "Patch file to apply to the standard library sources")
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
@@ -55,6 +60,19 @@ func run(args []string) error {
 		return err
 	}
 
+	// Apply patches to the copied sources. Patches are applied from the root
+	// of GOROOT like "patch -p1", which matches the output of "git diff" in
+	// the Go repository. The sources are copies, so the SDK isn't modified.
+	for _, patch := range patches {
+		data, err := ioutil.ReadFile(patch)
+		if err != nil {
+			return err
+		}
+		if err := applyPatch(output, data); err != nil {
+			return fmt.Errorf("error applying patch %s: %v", patch, err)
+		}
+	}
+
 	output, err = processPath(output)
 	if err != nil {
 		return err
@@ -98,6 +116,8 @@ func run(args []string) error {
 		ldflags = append(ldflags, "-dynlink")
 		asmflags = append(asmflags, "-dynlink")
 	}
+	gcflags = append(gcflags, gcflagsExtra...)
+	asmflags = append(asmflags, asmflagsExtra...)
 
 	// Since Go 1.10, an all= prefix indicates the flags should apply to the package
 	// and its dependencies, rather than just the package itself. This was the
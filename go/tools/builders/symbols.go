@@ -0,0 +1,106 @@
+// Copyright 2018 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// readSymbols lists the symbols in binary with "go tool nm -size".
+func readSymbols(goenv *env, binary string) ([]symbol, error) {
+	nmOut := &bytes.Buffer{}
+	if err := goenv.runCommandToFile(nmOut, goenv.goTool("nm", "-size", binary)); err != nil {
+		return nil, err
+	}
+	return parseNm(nmOut)
+}
+
+// symbol is a symbol listed by "go tool nm -size".
+type symbol struct {
+	name string
+	size int64
+}
+
+// parseNm parses the output of "go tool nm -size". Each line has an address,
+// a size, a type, and a name, which may contain spaces. Undefined symbols,
+// which have no address, are skipped.
+func parseNm(r io.Reader) ([]symbol, error) {
+	var syms []symbol
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		var fields []string
+		rest := line
+		for i := 0; i < 3; i++ {
+			rest = strings.TrimLeft(rest, " ")
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				break
+			}
+			fields = append(fields, rest[:end])
+			rest = rest[end:]
+		}
+		if len(fields) < 2 || fields[1] == "U" {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("bad line in nm output: %q", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad line in nm output: %q", line)
+		}
+		syms = append(syms, symbol{name: strings.TrimLeft(rest, " "), size: size})
+	}
+	return syms, s.Err()
+}
+
+// symbolPackage returns the path of the package a symbol belongs to, or ""
+// if it can't be determined.
+func symbolPackage(name string) string {
+	for _, prefix := range []string{"go.itab.", "go:itab.", "type..eq.", "type..hash.", "type.", "type:eq.", "type:hash.", "type:"} {
+		if strings.HasPrefix(name, prefix) {
+			name = name[len(prefix):]
+			break
+		}
+	}
+	name = strings.TrimLeft(name, "*")
+	if i := strings.IndexAny(name, "([{ ,;"); i >= 0 {
+		name = name[:i]
+	}
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot <= 0 {
+		return ""
+	}
+	pkg := name[:slash+1+dot]
+	// The linker escapes dots in the last element of a package path (and
+	// some other characters) as %xx, so the path can be found in symbol names.
+	if unescaped, err := url.PathUnescape(pkg); err == nil {
+		pkg = unescaped
+	}
+	if pkg == "go" || pkg == "type" || strings.HasPrefix(pkg, "go:") {
+		// Symbols synthesized by the compiler or linker, like go.buildid.
+		return ""
+	}
+	return pkg
+}
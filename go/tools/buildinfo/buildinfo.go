@@ -0,0 +1,113 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package buildinfo provides information about how the running binary was
+// built. The Go rules set this information automatically when linking any
+// go_binary or go_test that depends on this package.
+//
+// The label, mode, and dependencies of the binary are always set. The Git
+// commit and build time are only set when building with --stamp. They're
+// read from the workspace status command: the commit is the value of
+// STABLE_GIT_COMMIT (or BUILD_SCM_REVISION, if that's not set), and the
+// build time is the value of BUILD_TIMESTAMP, which Bazel always sets.
+// Without --stamp, these values are empty, and binaries are not re-linked
+// when they change.
+package buildinfo
+
+import (
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These variables are set by the linker. deps is a list of packages,
+// separated by newlines. Each line contains a package path and the label of
+// the rule that provided it, separated by '='.
+var (
+	label, mode, deps    string
+	gitCommit, timestamp string
+)
+
+// Info describes how a binary was built.
+type Info struct {
+	// Label is the label of the go_binary or go_test rule that built the
+	// binary. It's empty when the binary was not built by the Go rules.
+	Label string
+
+	// Mode describes the configuration the binary was built in, for example,
+	// "linux_amd64" or "darwin_amd64_race".
+	Mode string
+
+	// GoVersion is the version of Go the binary was built with.
+	GoVersion string
+
+	// GitCommit is the Git commit the binary was built from. It's empty
+	// unless the binary was built with --stamp.
+	GitCommit string
+
+	// Timestamp is the time the binary was built. It's the zero time unless
+	// the binary was built with --stamp.
+	Timestamp time.Time
+
+	// Deps lists the Go packages linked into the binary, sorted by package
+	// path. The standard library is not included.
+	Deps []Dep
+}
+
+// Dep is a Go package linked into a binary.
+type Dep struct {
+	// PackagePath is the path the package was linked with. This is usually
+	// its import path, but it may differ for vendored packages or libraries
+	// with an importmap.
+	PackagePath string
+
+	// Label is the label of the rule that provided the package.
+	Label string
+}
+
+// Get returns information about how the running binary was built.
+func Get() Info {
+	return parse(label, mode, deps, gitCommit, timestamp)
+}
+
+// Stamped returns whether the binary was built with --stamp.
+func (i Info) Stamped() bool {
+	return i.GitCommit != "" || !i.Timestamp.IsZero()
+}
+
+func parse(label, mode, deps, gitCommit, timestamp string) Info {
+	info := Info{
+		Label:     label,
+		Mode:      mode,
+		GoVersion: runtime.Version(),
+		GitCommit: gitCommit,
+	}
+	if secs, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		info.Timestamp = time.Unix(secs, 0)
+	}
+	for _, line := range strings.Split(deps, "\n") {
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		info.Deps = append(info.Deps, Dep{PackagePath: line[:i], Label: line[i+1:]})
+	}
+	sort.Slice(info.Deps, func(i, j int) bool {
+		return info.Deps[i].PackagePath < info.Deps[j].PackagePath
+	})
+	return info
+}
@@ -0,0 +1,73 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildinfo
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		desc                                 string
+		label, mode, deps, commit, timestamp string
+		want                                 Info
+		wantStamped                          bool
+	}{
+		{
+			desc: "empty",
+			want: Info{GoVersion: runtime.Version()},
+		}, {
+			desc:  "unstamped",
+			label: "//cmd:cmd",
+			mode:  "linux_amd64_race",
+			deps:  "example.com/b=//b:go_default_library\nexample.com/a=@a//:go_default_library",
+			want: Info{
+				Label:     "//cmd:cmd",
+				Mode:      "linux_amd64_race",
+				GoVersion: runtime.Version(),
+				Deps: []Dep{
+					{PackagePath: "example.com/a", Label: "@a//:go_default_library"},
+					{PackagePath: "example.com/b", Label: "//b:go_default_library"},
+				},
+			},
+		}, {
+			desc:        "stamped",
+			label:       "//cmd:cmd",
+			commit:      "0123abc",
+			timestamp:   "1540000000",
+			wantStamped: true,
+			want: Info{
+				Label:     "//cmd:cmd",
+				GoVersion: runtime.Version(),
+				GitCommit: "0123abc",
+				Timestamp: time.Unix(1540000000, 0),
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := parse(tc.label, tc.mode, tc.deps, tc.commit, tc.timestamp)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v\nwant %#v", got, tc.want)
+			}
+			if got.Stamped() != tc.wantStamped {
+				t.Errorf("got Stamped() %v; want %v", got.Stamped(), tc.wantStamped)
+			}
+		})
+	}
+}
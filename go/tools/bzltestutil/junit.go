@@ -0,0 +1,77 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// mergeJUnitFiles combines the reports in paths into a single report
+// written to outPath. Test suites with the same name are merged. Missing
+// reports are ignored, and nothing is written if all are missing. The
+// input files are removed.
+func mergeJUnitFiles(outPath string, paths []string) error {
+	var merged junitTestSuites
+	found := false
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		found = true
+		var report junitTestSuites
+		if err := xml.Unmarshal(data, &report); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		os.Remove(path)
+		for _, s := range report.Suites {
+			i := 0
+			for i < len(merged.Suites) && merged.Suites[i].Name != s.Name {
+				i++
+			}
+			if i == len(merged.Suites) {
+				merged.Suites = append(merged.Suites, s)
+				continue
+			}
+			m := &merged.Suites[i]
+			m.Tests += s.Tests
+			m.Failures += s.Failures
+			m.Skipped += s.Skipped
+			m.Time = addSeconds(m.Time, s.Time)
+			m.Cases = append(m.Cases, s.Cases...)
+		}
+	}
+	if !found {
+		return nil
+	}
+	data, err := xml.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outPath, append([]byte(xml.Header), data...), 0666)
+}
+
+func addSeconds(a, b string) string {
+	x, _ := strconv.ParseFloat(a, 64)
+	y, _ := strconv.ParseFloat(b, 64)
+	return fmt.Sprintf("%.3f", x+y)
+}
@@ -0,0 +1,95 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeJUnitFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "junit_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, report junitTestSuites) string {
+		data, err := xml.Marshal(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a := write("a.xml", junitTestSuites{Suites: []junitTestSuite{
+		{Name: "pkg", Tests: 1, Failures: 1, Time: "0.500", Cases: []junitTestCase{{Name: "TestA"}}},
+	}})
+	b := write("b.xml", junitTestSuites{Suites: []junitTestSuite{
+		{Name: "pkg", Tests: 1, Skipped: 1, Time: "0.250", Cases: []junitTestCase{{Name: "TestB"}}},
+		{Name: "pkg (quarantined)", Tests: 1, Time: "1.000", Cases: []junitTestCase{{Name: "TestC"}}},
+	}})
+	missing := filepath.Join(dir, "missing.xml")
+	out := filepath.Join(dir, "out.xml")
+	if err := mergeJUnitFiles(out, []string{a, missing, b}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got junitTestSuites
+	if err := xml.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := junitTestSuites{
+		XMLName: xml.Name{Local: "testsuites"},
+		Suites: []junitTestSuite{
+			{Name: "pkg", Tests: 2, Failures: 1, Skipped: 1, Time: "0.750", Cases: []junitTestCase{{Name: "TestA"}, {Name: "TestB"}}},
+			{Name: "pkg (quarantined)", Tests: 1, Time: "1.000", Cases: []junitTestCase{{Name: "TestC"}}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	for _, path := range []string{a, b} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", path)
+		}
+	}
+}
+
+func TestMergeJUnitFilesAllMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "junit_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out.xml")
+	if err := mergeJUnitFiles(out, []string{filepath.Join(dir, "missing.xml")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("%s was written with no reports", out)
+	}
+}
@@ -0,0 +1,151 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/rules_go/go/tools/coverdata"
+)
+
+// coverageEnv names the file Bazel expects coverage data to be written to
+// when a test is run with "bazel coverage".
+const coverageEnv = "COVERAGE_OUTPUT_FILE"
+
+// coverageEnabled returns whether the test should write an LCOV report. The
+// report is written even if the test itself has no coverage instrumentation,
+// since binaries it runs may write reports that should be included.
+func coverageEnabled() bool {
+	return os.Getenv(coverageEnv) != ""
+}
+
+// CoverProfile returns the file the testing package should write a Go
+// coverage profile to, or "" if it shouldn't write one. Only child processes
+// started by Wrap write profiles; Wrap merges their counts and writes the
+// LCOV report. A test that runs in a single process writes its LCOV report
+// directly from its counters (see Finish).
+func CoverProfile() string {
+	if os.Getenv(wrapEnv) != "0" {
+		return ""
+	}
+	return os.Getenv(coverageEnv)
+}
+
+// Finish is called by the generated test main with the status returned by
+// testing.M.Run when the test runs in a single process. If coverage is
+// being collected, Finish writes the LCOV report and checks minimum
+// coverage. It returns the status the test binary should exit with.
+func Finish(opts Options, code int) int {
+	if !coverageEnabled() || os.Getenv(wrapEnv) == "0" {
+		return code
+	}
+	if !finishCoverage(opts, os.Getenv(coverageEnv), os.Stdout) && code == 0 {
+		code = 1
+	}
+	return code
+}
+
+// finishCoverage writes the coverage recorded in coverdata.Cover to outPath
+// as an LCOV report and checks it against the minimums in opts. Messages
+// are written to w. It returns false if the test should fail.
+func finishCoverage(opts Options, outPath string, w io.Writer) bool {
+	if err := writeCoverageReport(outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not write coverage report: %v\n", err)
+		if hasCoverageThresholds(opts) {
+			fmt.Fprintf(w, "coverage: could not check minimum coverage\n")
+			return false
+		}
+		return true
+	}
+	if msg := checkCoverage(opts, coverdata.Summarize()); msg != "" {
+		fmt.Fprint(w, msg)
+		return false
+	}
+	return true
+}
+
+// mergeProfiles adds the counts in the Go coverage profiles written by child
+// processes to coverdata.Cover. Counts recorded by this process are
+// discarded first, since each child runs the same initialization. Missing
+// profiles are ignored; a test may exit before writing one. The profiles
+// are removed.
+func mergeProfiles(profilePaths []string) error {
+	coverdata.ResetCounters()
+	for _, path := range profilePaths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		err = coverdata.MergeProfile(f)
+		f.Close()
+		os.Remove(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// writeCoverageReport converts the coverage recorded in coverdata.Cover to
+// LCOV, merges in the reports written by instrumented binaries the test ran
+// (see coverdata.BinaryMain), and writes the result to outPath. Files are
+// named by their source paths (see coverdata.SourcePath), so reports from
+// different tests can be combined by Bazel.
+func writeCoverageReport(outPath string) error {
+	report := coverdata.CoverReport()
+	binaryReports, err := binaryReportPaths()
+	if err != nil {
+		return err
+	}
+	for _, path := range binaryReports {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		binReport, err := coverdata.ParseLcov(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		// Binaries are instrumented in the same mode as the test.
+		report.Merge(binReport, coverdata.Cover.Mode)
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteLcov(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// binaryReportPaths returns the paths of reports written to COVERAGE_DIR by
+// instrumented binaries.
+func binaryReportPaths() ([]string, error) {
+	dir := os.Getenv("COVERAGE_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	return filepath.Glob(filepath.Join(dir, coverdata.BinaryReportPrefix+"*"+coverdata.BinaryReportSuffix))
+}
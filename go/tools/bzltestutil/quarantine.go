@@ -0,0 +1,443 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// quarantineEnv tells a child process started by Wrap which tests to run
+// when tests are quarantined. When it's "-", quarantined tests are left out.
+// Otherwise, it names the one quarantined test to run.
+const quarantineEnv = "GO_TEST_QUARANTINE"
+
+// quarantine runs quarantined tests with retries. Each attempt runs in its
+// own child process, so a failed attempt (or a panic) doesn't affect other
+// tests. Results of quarantined tests are written as JUnit XML to a
+// temporary directory, to be merged with the results of other tests.
+type quarantine struct {
+	tests   []string
+	retries int
+	dir     string
+	results *junitRecorder
+
+	// deadline is when attempts must stop so that results can be reported
+	// before Bazel's test timeout expires. It's zero if there's no timeout.
+	deadline time.Time
+}
+
+// newQuarantine returns a quarantine for the tests named in opts, or nil if
+// no tests are quarantined. The caller should call cleanup when done.
+func newQuarantine(opts Options) (*quarantine, error) {
+	if len(opts.Quarantine) == 0 {
+		return nil, nil
+	}
+	dir, err := ioutil.TempDir("", "go_test_quarantine")
+	if err != nil {
+		return nil, err
+	}
+	q := &quarantine{
+		tests:   opts.Quarantine,
+		retries: opts.QuarantineRetries,
+		dir:     dir,
+		results: &junitRecorder{
+			pkg:     opts.Package,
+			xmlPath: filepath.Join(dir, "quarantined.xml"),
+		},
+	}
+	if q.retries < 0 {
+		q.retries = 0
+	}
+	if timeout, ok := testTimeout(); ok {
+		q.deadline = time.Now().Add(timeout - timeoutMargin(timeout))
+	}
+	return q, nil
+}
+
+func (q *quarantine) cleanup() {
+	os.RemoveAll(q.dir)
+}
+
+// run runs each quarantined test alone in a child process, up to
+// q.retries+1 times, until it passes. env is the environment of the child
+// that ran the other tests. The output of the attempt that counts is
+// written to out as is. Output of failed attempts is written with each
+// line prefixed, so it isn't mistaken for the result of a test. If
+// profilePath is not empty, each attempt writes a coverage profile, and run
+// returns their paths.
+//
+// Each attempt is given the time left before q.deadline, through
+// TEST_TIMEOUT and -test.timeout, so that retries don't run past Bazel's
+// test timeout. No more attempts are started once the time is used up.
+func (q *quarantine) run(exe string, args, env []string, out io.Writer, verbose bool, profilePath string) []string {
+	// An attempt is a single run of the test.
+	args = removeFlags(args, "test.count", "test.cpu")
+	var profilePaths []string
+	attempts := q.retries + 1
+	for _, name := range q.tests {
+		start := time.Now()
+		for i := 1; i <= attempts; i++ {
+			attemptArgs := args
+			attemptEnv := append(env[:len(env):len(env)], quarantineEnv+"="+name)
+			if !q.deadline.IsZero() {
+				remaining := time.Until(q.deadline)
+				if remaining <= 0 {
+					fmt.Fprintf(out, "quarantined test %s: no time left for attempt %d of %d\n", name, i, attempts)
+					q.fail(out, name, time.Since(start), i-1, attempts, verbose)
+					break
+				}
+				attemptArgs = limitTimeout(args, remaining)
+				secs := int(remaining / time.Second)
+				if secs < 1 {
+					secs = 1
+				}
+				attemptEnv = append(attemptEnv, fmt.Sprintf("TEST_TIMEOUT=%d", secs))
+			}
+			xmlPath := filepath.Join(q.dir, fmt.Sprintf("%s.%d.xml", name, i))
+			attemptEnv = append(attemptEnv, "XML_OUTPUT_FILE="+xmlPath)
+			if profilePath != "" {
+				p := fmt.Sprintf("%s.%s.%d", profilePath, name, i)
+				attemptEnv = append(attemptEnv, coverageEnv+"="+p)
+				profilePaths = append(profilePaths, p)
+			}
+			buf := &bytes.Buffer{}
+			code := runChild(exe, attemptArgs, attemptEnv, buf)
+			ran, failed := readAttempt(xmlPath, name)
+			if !ran && code == 0 {
+				// The test was not selected, for example, by -test.run or
+				// because it's in another shard or package.
+				break
+			}
+			if !failed && code == 0 {
+				if i > 1 {
+					fmt.Fprintf(out, "quarantined test %s passed on attempt %d of %d\n", name, i, attempts)
+				}
+				out.Write(buf.Bytes())
+				q.results.record(testResult{
+					name:        name,
+					elapsed:     time.Since(start),
+					quarantined: true,
+					attempts:    i,
+					maxAttempts: attempts,
+				})
+				break
+			}
+			writePrefixed(out, buf.Bytes(), fmt.Sprintf("attempt %d: ", i))
+			if i == attempts {
+				q.fail(out, name, time.Since(start), attempts, attempts, verbose)
+			}
+		}
+	}
+	return profilePaths
+}
+
+// fail reports that a quarantined test failed each of the attempts that
+// were made. Fewer than maxAttempts are made if time runs out.
+func (q *quarantine) fail(out io.Writer, name string, elapsed time.Duration, attempts, maxAttempts int, verbose bool) {
+	msg := fmt.Sprintf("quarantined test %s failed all %d attempts; ignoring the failure", name, attempts)
+	if attempts < maxAttempts {
+		msg = fmt.Sprintf("quarantined test %s failed %d of %d attempts before the test timeout; ignoring the failure", name, attempts, maxAttempts)
+	}
+	if verbose {
+		fmt.Fprintf(out, "=== RUN   %s\n--- SKIP: %s (%.2fs)\n    %s\n", name, name, elapsed.Seconds(), msg)
+	} else {
+		fmt.Fprintln(out, msg)
+	}
+	q.results.record(testResult{
+		name:        name,
+		elapsed:     elapsed,
+		failed:      true,
+		quarantined: true,
+		attempts:    attempts,
+		maxAttempts: maxAttempts,
+	})
+}
+
+// readAttempt reads the report written by a child that ran a quarantined
+// test. It returns whether the test ran and whether it failed.
+func readAttempt(xmlPath, name string) (ran, failed bool) {
+	data, err := ioutil.ReadFile(xmlPath)
+	if err != nil {
+		return false, false
+	}
+	os.Remove(xmlPath)
+	var report junitTestSuites
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return false, false
+	}
+	for _, s := range report.Suites {
+		for _, c := range s.Cases {
+			if c.Name == name {
+				ran = true
+				failed = failed || c.Failure != nil
+			}
+		}
+	}
+	return ran, failed
+}
+
+// writePrefixed writes each line of data to w with prefix before it.
+func writePrefixed(w io.Writer, data []byte, prefix string) {
+	buf := &bytes.Buffer{}
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		buf.WriteString(prefix)
+		buf.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			buf.WriteByte('\n')
+		}
+	}
+	w.Write(buf.Bytes())
+}
+
+// removeFlags returns a copy of args without the named flags, which must
+// take values.
+func removeFlags(args []string, names ...string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(kept, args[i:]...)
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		removed := false
+		for _, n := range names {
+			if name == n {
+				// The value is the next argument.
+				i++
+				removed = true
+			} else if strings.HasPrefix(name, n+"=") {
+				removed = true
+			}
+		}
+		if !removed {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+// limitTimeout returns args with -test.timeout set to remaining, unless
+// args already set a shorter timeout.
+func limitTimeout(args []string, remaining time.Duration) []string {
+	if v, ok := flagValue(args, "test.timeout"); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 && d <= remaining {
+			return args
+		}
+	}
+	timeout := fmt.Sprintf("-test.timeout=%v", remaining.Round(time.Millisecond))
+	return append([]string{timeout}, removeFlags(args, "test.timeout")...)
+}
+
+// flagValue returns the value of the last occurrence of the named flag in
+// args, which must take a value, and whether the flag was found.
+func flagValue(args []string, name string) (string, bool) {
+	value, found := "", false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if arg == name && i+1 < len(args) {
+			i++
+			value, found = args[i], true
+		} else if strings.HasPrefix(arg, name+"=") {
+			value, found = arg[len(name)+1:], true
+		}
+	}
+	return value, found
+}
+
+// quarantineTests returns the tests a child process started by Wrap should
+// run, according to quarantineEnv.
+func quarantineTests(opts Options, tests []testing.InternalTest) []testing.InternalTest {
+	sel := os.Getenv(quarantineEnv)
+	if sel == "" || len(opts.Quarantine) == 0 {
+		return tests
+	}
+	quarantined := map[string]bool{}
+	for _, name := range opts.Quarantine {
+		quarantined[name] = true
+	}
+	var selected []testing.InternalTest
+	for _, t := range tests {
+		if (sel == "-" && !quarantined[t.Name]) || t.Name == sel {
+			selected = append(selected, t)
+		}
+	}
+	return selected
+}
+
+// junitRecorder records the results of top-level tests and writes them as
+// JUnit XML to a file. When tests are quarantined, Bazel's XML output
+// (XML_OUTPUT_FILE) is written this way instead of by Bazel, so that
+// quarantined tests can be reported separately.
+type junitRecorder struct {
+	pkg     string
+	xmlPath string
+
+	mu      sync.Mutex
+	results []testResult
+}
+
+type testResult struct {
+	name                  string
+	elapsed               time.Duration
+	failed, skipped       bool
+	quarantined           bool
+	attempts, maxAttempts int
+}
+
+// newJUnitRecorder returns a recorder that writes to XML_OUTPUT_FILE, or nil
+// if no tests are quarantined or the file is not set.
+func newJUnitRecorder(opts Options) *junitRecorder {
+	xmlPath := os.Getenv("XML_OUTPUT_FILE")
+	if len(opts.Quarantine) == 0 || xmlPath == "" {
+		return nil
+	}
+	return &junitRecorder{pkg: opts.Package, xmlPath: xmlPath}
+}
+
+// wrap returns a test function that records the result of f.
+func (r *junitRecorder) wrap(name string, f func(*testing.T)) func(*testing.T) {
+	return func(t *testing.T) {
+		start := time.Now()
+		defer func() {
+			r.record(testResult{
+				name:    name,
+				elapsed: time.Since(start),
+				failed:  t.Failed(),
+				skipped: t.Skipped(),
+			})
+		}()
+		f(t)
+	}
+}
+
+// record saves the result of a test and rewrites the XML report. The report
+// is written after each test so that it's complete even if TestMain exits
+// without returning.
+func (r *junitRecorder) record(res testResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, res)
+	data, err := xml.MarshalIndent(r.junitReport(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not encode test results: %v\n", err)
+		return
+	}
+	data = append([]byte(xml.Header), data...)
+	if err := ioutil.WriteFile(r.xmlPath, data, 0666); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not write test results: %v\n", err)
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// junitReport builds a report with a test suite for ordinary tests, named
+// after the package, and a test suite for quarantined tests. Suites without
+// results are left out. Quarantined tests that never passed are reported as
+// skipped, since they don't fail the target.
+func (r *junitRecorder) junitReport() junitTestSuites {
+	normal := junitTestSuite{Name: r.pkg}
+	quarantined := junitTestSuite{Name: r.pkg + " (quarantined)"}
+	var normalTime, quarantinedTime time.Duration
+	for _, res := range r.results {
+		tc := junitTestCase{
+			Name:      res.name,
+			ClassName: r.pkg,
+			Time:      formatSeconds(res.elapsed),
+		}
+		suite := &normal
+		if res.quarantined {
+			suite = &quarantined
+			quarantinedTime += res.elapsed
+			if res.failed {
+				msg := fmt.Sprintf("quarantined test failed all %d attempts", res.attempts)
+				if res.attempts < res.maxAttempts {
+					msg = fmt.Sprintf("quarantined test failed %d of %d attempts before the test timeout", res.attempts, res.maxAttempts)
+				}
+				tc.Skipped = &junitMessage{msg}
+				suite.Skipped++
+			} else {
+				tc.SystemOut = fmt.Sprintf("passed on attempt %d of %d", res.attempts, res.maxAttempts)
+			}
+		} else {
+			normalTime += res.elapsed
+			switch {
+			case res.failed:
+				tc.Failure = &junitMessage{"Failed"}
+				suite.Failures++
+			case res.skipped:
+				tc.Skipped = &junitMessage{"Skipped"}
+				suite.Skipped++
+			}
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+	normal.Time = formatSeconds(normalTime)
+	quarantined.Time = formatSeconds(quarantinedTime)
+	var report junitTestSuites
+	for _, s := range []junitTestSuite{normal, quarantined} {
+		if s.Tests > 0 {
+			report.Suites = append(report.Suites, s)
+		}
+	}
+	return report
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
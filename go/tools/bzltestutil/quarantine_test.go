@@ -0,0 +1,85 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRemoveFlags(t *testing.T) {
+	args := []string{"-test.v", "-test.count=3", "--test.cpu", "1,2", "-test.run", "X", "--", "-test.count=2"}
+	got := removeFlags(args, "test.count", "test.cpu")
+	want := []string{"-test.v", "-test.run", "X", "--", "-test.count=2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestLimitTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		args []string
+		want []string
+	}{
+		{"unset", []string{"-test.v"}, []string{"-test.timeout=1m30s", "-test.v"}},
+		{"longer", []string{"-test.timeout=10m", "-test.v"}, []string{"-test.timeout=1m30s", "-test.v"}},
+		{"longer_separate", []string{"--test.timeout", "10m", "-test.v"}, []string{"-test.timeout=1m30s", "-test.v"}},
+		{"shorter", []string{"-test.timeout=30s", "-test.v"}, []string{"-test.timeout=30s", "-test.v"}},
+		{"after_dashes", []string{"-test.v", "--", "-test.timeout=30s"}, []string{"-test.timeout=1m30s", "-test.v", "--", "-test.timeout=30s"}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := limitTimeout(tc.args, 90*time.Second); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWritePrefixed(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writePrefixed(buf, []byte("--- FAIL: TestX\nFAIL"), "attempt 1: ")
+	want := "attempt 1: --- FAIL: TestX\nattempt 1: FAIL\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestQuarantineTests(t *testing.T) {
+	opts := Options{Quarantine: []string{"TestFlaky"}}
+	tests := []testing.InternalTest{{Name: "TestA"}, {Name: "TestFlaky"}, {Name: "TestB"}}
+	for _, tc := range []struct {
+		sel  string
+		want []string
+	}{
+		{"", []string{"TestA", "TestFlaky", "TestB"}},
+		{"-", []string{"TestA", "TestB"}},
+		{"TestFlaky", []string{"TestFlaky"}},
+	} {
+		os.Setenv(quarantineEnv, tc.sel)
+		var got []string
+		for _, test := range quarantineTests(opts, tests) {
+			got = append(got, test.Name)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s=%q: got %q; want %q", quarantineEnv, tc.sel, got, tc.want)
+		}
+	}
+	os.Unsetenv(quarantineEnv)
+}
@@ -0,0 +1,273 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a single test event. It has the same JSON encoding as the events
+// printed by "go test -json" (see "go doc cmd/test2json"), so tools that
+// consume those events can consume ours unchanged.
+type Event struct {
+	Time    *time.Time `json:",omitempty"`
+	Action  string
+	Package string   `json:",omitempty"`
+	Test    string   `json:",omitempty"`
+	Elapsed *float64 `json:",omitempty"`
+	Output  string   `json:",omitempty"`
+}
+
+// converter translates the verbose (-test.v) output of a test binary into a
+// stream of JSON-encoded events. It is a simplified version of
+// cmd/internal/test2json. Output is processed one line at a time; a trailing
+// partial line is flushed by exited.
+type converter struct {
+	enc   *json.Encoder
+	pkg   string
+	now   func() time.Time
+	start time.Time
+
+	buf []byte
+
+	// current is the name of the test named by the most recent "=== RUN" or
+	// "=== CONT" line. Unattributed output is reported for this test.
+	current string
+
+	// report holds the names of tests whose results were just reported,
+	// indexed by nesting depth. The testing package prints log output
+	// after a test's result line, indented one level deeper than the result.
+	report []string
+
+	// result is "pass" or "fail" once the final line of output from the
+	// testing package has been seen.
+	result string
+}
+
+var (
+	runPrefix   = "=== RUN   "
+	pausePrefix = "=== PAUSE "
+	contPrefix  = "=== CONT  "
+
+	resultPrefixes = []struct{ prefix, action string }{
+		{"--- PASS: ", "pass"},
+		{"--- FAIL: ", "fail"},
+		{"--- SKIP: ", "skip"},
+		{"--- BENCH: ", "bench"},
+	}
+)
+
+func newConverter(w io.Writer, pkg string) *converter {
+	c := &converter{
+		enc: json.NewEncoder(w),
+		pkg: pkg,
+		now: time.Now,
+	}
+	c.start = c.now()
+	return c
+}
+
+// Write processes output from the test binary. It never returns an error;
+// output that can't be parsed is reported as an "output" event.
+func (c *converter) Write(b []byte) (int, error) {
+	c.buf = append(c.buf, b...)
+	rest := c.buf
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+		c.handleLine(string(rest[:i+1]))
+		rest = rest[i+1:]
+	}
+	c.buf = append(c.buf[:0], rest...)
+	return len(b), nil
+}
+
+// exited flushes any partial line and reports the final result of the
+// test binary. If the binary failed (exited with a non-zero status), the
+// result is "fail", even if the testing package reported success.
+func (c *converter) exited(failed bool) {
+	if len(c.buf) > 0 {
+		c.emit(Event{Action: "output", Test: c.current, Output: string(c.buf)})
+		c.buf = nil
+	}
+	result := c.result
+	if failed {
+		result = "fail"
+	} else if result == "" {
+		result = "pass"
+	}
+	elapsed := c.now().Sub(c.start).Seconds()
+	c.emit(Event{Action: result, Elapsed: &elapsed})
+}
+
+func (c *converter) handleLine(line string) {
+	text := strings.TrimRight(line, "\r\n")
+
+	for _, p := range []struct{ prefix, action string }{
+		{runPrefix, "run"},
+		{pausePrefix, "pause"},
+		{contPrefix, "cont"},
+	} {
+		if strings.HasPrefix(text, p.prefix) {
+			name := strings.TrimSpace(text[len(p.prefix):])
+			if p.action != "pause" {
+				c.current = name
+			}
+			c.report = nil
+			c.emit(Event{Action: p.action, Test: name})
+			c.emit(Event{Action: "output", Test: name, Output: line})
+			return
+		}
+	}
+
+	indent := 0
+	for strings.HasPrefix(text[indent:], "    ") {
+		indent += 4
+	}
+	depth := indent / 4
+	for _, p := range resultPrefixes {
+		if !strings.HasPrefix(text[indent:], p.prefix) {
+			continue
+		}
+		name, elapsed := parseResult(text[indent+len(p.prefix):])
+		if depth < len(c.report) {
+			c.report = c.report[:depth]
+		}
+		for len(c.report) < depth {
+			c.report = append(c.report, "")
+		}
+		c.report = append(c.report, name)
+		c.emit(Event{Action: "output", Test: name, Output: line})
+		c.emit(Event{Action: p.action, Test: name, Elapsed: elapsed})
+		return
+	}
+
+	if depth == 0 {
+		switch {
+		case text == "PASS" || strings.HasPrefix(text, "ok  \t"):
+			c.result = "pass"
+			c.current, c.report = "", nil
+			c.emit(Event{Action: "output", Output: line})
+			return
+		case text == "FAIL" || strings.HasPrefix(text, "FAIL\t"):
+			c.result = "fail"
+			c.current, c.report = "", nil
+			c.emit(Event{Action: "output", Output: line})
+			return
+		}
+	}
+
+	test := c.current
+	if depth > 0 && depth <= len(c.report) && c.report[depth-1] != "" {
+		test = c.report[depth-1]
+	}
+	c.emit(Event{Action: "output", Test: test, Output: line})
+}
+
+// parseResult splits the text after a result prefix like "--- PASS: " into
+// a test name and an elapsed time in seconds. The elapsed time is nil
+// if it could not be parsed.
+func parseResult(s string) (name string, elapsed *float64) {
+	i := strings.LastIndex(s, " (")
+	if i < 0 || !strings.HasSuffix(s, "s)") {
+		return strings.TrimSpace(s), nil
+	}
+	name = s[:i]
+	if secs, err := strconv.ParseFloat(s[i+len(" ("):len(s)-len("s)")], 64); err == nil {
+		elapsed = &secs
+	}
+	return name, elapsed
+}
+
+func (c *converter) emit(e Event) {
+	t := c.now()
+	e.Time = &t
+	e.Package = c.pkg
+	c.enc.Encode(&e)
+}
+
+// quietWriter filters the verbose (-test.v) output of a test binary so it
+// looks like non-verbose output. "=== RUN", "=== PAUSE", and "=== CONT" lines
+// are dropped, and so are the result lines of tests that passed or were
+// skipped, along with the log output indented below them. This lets Wrap
+// read verbose output for events without making the test log verbose.
+type quietWriter struct {
+	w   io.Writer
+	buf []byte
+
+	// dropIndent is the indentation of the result line whose log output is
+	// being dropped, or -1 if output is not being dropped.
+	dropIndent int
+}
+
+func newQuietWriter(w io.Writer) *quietWriter {
+	return &quietWriter{w: w, dropIndent: -1}
+}
+
+func (q *quietWriter) Write(b []byte) (int, error) {
+	q.buf = append(q.buf, b...)
+	rest := q.buf
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+		if err := q.handleLine(rest[:i+1]); err != nil {
+			return 0, err
+		}
+		rest = rest[i+1:]
+	}
+	q.buf = append(q.buf[:0], rest...)
+	return len(b), nil
+}
+
+// flush writes any partial line.
+func (q *quietWriter) flush() error {
+	if len(q.buf) == 0 {
+		return nil
+	}
+	_, err := q.w.Write(q.buf)
+	q.buf = nil
+	return err
+}
+
+func (q *quietWriter) handleLine(line []byte) error {
+	trimmed := bytes.TrimLeft(line, " \t")
+	indent := len(line) - len(trimmed)
+	if q.dropIndent >= 0 {
+		if indent > q.dropIndent && len(bytes.TrimSpace(line)) > 0 {
+			return nil
+		}
+		q.dropIndent = -1
+	}
+	text := string(trimmed)
+	if indent == 0 && (strings.HasPrefix(text, runPrefix) || strings.HasPrefix(text, pausePrefix) || strings.HasPrefix(text, contPrefix)) {
+		return nil
+	}
+	if strings.HasPrefix(text, "--- PASS: ") || strings.HasPrefix(text, "--- SKIP: ") {
+		q.dropIndent = indent
+		return nil
+	}
+	_, err := q.w.Write(line)
+	return err
+}
@@ -0,0 +1,216 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConverter(t *testing.T) {
+	for _, tc := range []struct {
+		desc, output string
+		failed       bool
+		want         []string
+	}{
+		{
+			desc: "pass",
+			output: `=== RUN   TestA
+--- PASS: TestA (0.50s)
+    a_test.go:10: hello
+PASS
+`,
+			want: []string{
+				`run TestA`,
+				`output TestA "=== RUN   TestA\n"`,
+				`output TestA "--- PASS: TestA (0.50s)\n"`,
+				`pass TestA 0.5`,
+				`output TestA "    a_test.go:10: hello\n"`,
+				`output "PASS\n"`,
+				`pass 1`,
+			},
+		}, {
+			desc: "subtests",
+			output: `=== RUN   TestA
+=== RUN   TestA/sub
+=== PAUSE TestA/sub
+=== CONT  TestA/sub
+--- FAIL: TestA (0.00s)
+    --- FAIL: TestA/sub (0.00s)
+        a_test.go:12: oops
+FAIL
+`,
+			want: []string{
+				`run TestA`,
+				`output TestA "=== RUN   TestA\n"`,
+				`run TestA/sub`,
+				`output TestA/sub "=== RUN   TestA/sub\n"`,
+				`pause TestA/sub`,
+				`output TestA/sub "=== PAUSE TestA/sub\n"`,
+				`cont TestA/sub`,
+				`output TestA/sub "=== CONT  TestA/sub\n"`,
+				`output TestA "--- FAIL: TestA (0.00s)\n"`,
+				`fail TestA 0`,
+				`output TestA/sub "    --- FAIL: TestA/sub (0.00s)\n"`,
+				`fail TestA/sub 0`,
+				`output TestA/sub "        a_test.go:12: oops\n"`,
+				`output "FAIL\n"`,
+				`fail 1`,
+			},
+		}, {
+			desc: "unattributed",
+			output: `=== RUN   TestA
+printed by TestA
+--- SKIP: TestA (0.00s)
+PASS
+`,
+			want: []string{
+				`run TestA`,
+				`output TestA "=== RUN   TestA\n"`,
+				`output TestA "printed by TestA\n"`,
+				`output TestA "--- SKIP: TestA (0.00s)\n"`,
+				`skip TestA 0`,
+				`output "PASS\n"`,
+				`pass 1`,
+			},
+		}, {
+			desc: "panic",
+			output: `=== RUN   TestA
+panic: boom
+
+goroutine 1 [running]:
+main.main()`,
+			failed: true,
+			want: []string{
+				`run TestA`,
+				`output TestA "=== RUN   TestA\n"`,
+				`output TestA "panic: boom\n"`,
+				`output TestA "\n"`,
+				`output TestA "goroutine 1 [running]:\n"`,
+				`output TestA "main.main()"`,
+				`fail 1`,
+			},
+		}, {
+			desc: "exit status overrides result",
+			output: `PASS
+`,
+			failed: true,
+			want: []string{
+				`output "PASS\n"`,
+				`fail 1`,
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			c := newConverter(buf, "example.com/a")
+			start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+			c.start = start
+			c.now = func() time.Time { return start.Add(time.Second) }
+
+			// Write output a few bytes at a time to check that lines are
+			// reassembled.
+			for out := tc.output; out != ""; {
+				n := 7
+				if n > len(out) {
+					n = len(out)
+				}
+				io.WriteString(c, out[:n])
+				out = out[n:]
+			}
+			c.exited(tc.failed)
+
+			var got []string
+			dec := json.NewDecoder(buf)
+			for {
+				var e Event
+				if err := dec.Decode(&e); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if e.Package != "example.com/a" {
+					t.Errorf("event has package %q; want %q", e.Package, "example.com/a")
+				}
+				if e.Time == nil {
+					t.Errorf("event has no time")
+				}
+				got = append(got, formatEvent(e))
+			}
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("got events:\n%s\n\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func formatEvent(e Event) string {
+	parts := []string{e.Action}
+	if e.Test != "" {
+		parts = append(parts, e.Test)
+	}
+	if e.Output != "" {
+		parts = append(parts, fmt.Sprintf("%q", e.Output))
+	}
+	if e.Elapsed != nil {
+		parts = append(parts, fmt.Sprint(*e.Elapsed))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestQuietWriter(t *testing.T) {
+	verbose := `=== RUN   TestA
+--- PASS: TestA (0.00s)
+    a_test.go:10: passing log
+=== RUN   TestB
+=== RUN   TestB/sub
+=== RUN   TestB/skipped
+printed by TestB
+--- FAIL: TestB (0.00s)
+    --- PASS: TestB/sub (0.00s)
+        b_test.go:5: passing subtest log
+    --- SKIP: TestB/skipped (0.00s)
+        b_test.go:8: skipped
+    b_test.go:12: oops
+=== RUN   TestC
+--- SKIP: TestC (0.00s)
+FAIL
+partial`
+	want := `printed by TestB
+--- FAIL: TestB (0.00s)
+    b_test.go:12: oops
+FAIL
+partial`
+	buf := &bytes.Buffer{}
+	q := newQuietWriter(buf)
+	// Write in small pieces to check that lines are reassembled.
+	for i := 0; i < len(verbose); i += 7 {
+		end := i + 7
+		if end > len(verbose) {
+			end = len(verbose)
+		}
+		q.Write([]byte(verbose[i:end]))
+	}
+	q.flush()
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\n\nwant:\n%s", got, want)
+	}
+}
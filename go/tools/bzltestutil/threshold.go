@@ -0,0 +1,85 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/bazelbuild/rules_go/go/tools/coverdata"
+)
+
+// hasCoverageThresholds returns whether opts sets any minimum coverage.
+func hasCoverageThresholds(opts Options) bool {
+	return opts.CoverageThreshold > 0 || len(opts.PackageCoverageThresholds) > 0
+}
+
+// checkCoverage compares the coverage in summaries with the minimums in
+// opts. If coverage is below a minimum, checkCoverage returns a message
+// explaining which minimums were not met, with a breakdown by package and
+// file. Otherwise, it returns "".
+func checkCoverage(opts Options, summaries []coverdata.PackageSummary) string {
+	buf := &bytes.Buffer{}
+	if opts.CoverageThreshold > 0 {
+		total := coverdata.PackageSummary{}
+		for _, ps := range summaries {
+			total.Statements += ps.Statements
+			total.Covered += ps.Covered
+		}
+		if pct := total.Percent(); pct < opts.CoverageThreshold {
+			fmt.Fprintf(buf, "coverage: %.1f%% of statements is below the minimum of %.1f%%\n", pct, opts.CoverageThreshold)
+			writeCoverageBreakdown(buf, summaries)
+		}
+	}
+
+	pkgPaths := make([]string, 0, len(opts.PackageCoverageThresholds))
+	for pkgPath := range opts.PackageCoverageThresholds {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+	for _, pkgPath := range pkgPaths {
+		min := opts.PackageCoverageThresholds[pkgPath]
+		var ps *coverdata.PackageSummary
+		for i := range summaries {
+			if summaries[i].PackagePath == pkgPath {
+				ps = &summaries[i]
+				break
+			}
+		}
+		if ps == nil {
+			fmt.Fprintf(buf, "coverage: package %s has a minimum coverage of %.1f%%, but no coverage data was recorded for it\n", pkgPath, min)
+			continue
+		}
+		if pct := ps.Percent(); pct < min {
+			fmt.Fprintf(buf, "coverage: package %s: %.1f%% of statements is below the minimum of %.1f%%\n", pkgPath, pct, min)
+			writeCoverageBreakdown(buf, []coverdata.PackageSummary{*ps})
+		}
+	}
+	return buf.String()
+}
+
+func writeCoverageBreakdown(buf *bytes.Buffer, summaries []coverdata.PackageSummary) {
+	w := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	for _, ps := range summaries {
+		fmt.Fprintf(w, "    %s\t%.1f%%\t(%d of %d statements)\n", ps.PackagePath, ps.Percent(), ps.Covered, ps.Statements)
+		for _, fs := range ps.Files {
+			fmt.Fprintf(w, "        %s\t%.1f%%\t(%d of %d statements)\n", fs.SourcePath, fs.Percent(), fs.Covered, fs.Statements)
+		}
+	}
+	w.Flush()
+}
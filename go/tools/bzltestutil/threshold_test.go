@@ -0,0 +1,80 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/coverdata"
+)
+
+func TestCheckCoverage(t *testing.T) {
+	// example.com/a is 75% covered, example.com/b is 0% covered, and
+	// together they're 50% covered.
+	var sum coverdata.Summarizer
+	sum.AddBlock("example.com/a/x.go", 2, 1)
+	sum.AddBlock("example.com/a/y.go", 1, 1)
+	sum.AddBlock("example.com/a/y.go", 1, 0)
+	sum.AddBlock("example.com/b/z.go", 2, 0)
+	summaries := sum.Summaries()
+	for _, tc := range []struct {
+		desc string
+		opts Options
+		want []string
+	}{
+		{
+			desc: "none",
+		}, {
+			desc: "overall met",
+			opts: Options{CoverageThreshold: 50},
+		}, {
+			desc: "overall not met",
+			opts: Options{CoverageThreshold: 60},
+			want: []string{
+				"coverage: 50.0% of statements is below the minimum of 60.0%",
+				"example.com/a/y.go  50.0%   (1 of 2 statements)",
+				"example.com/b           0.0%    (0 of 2 statements)",
+			},
+		}, {
+			desc: "package met",
+			opts: Options{PackageCoverageThresholds: map[string]float64{"example.com/a": 75}},
+		}, {
+			desc: "package not met",
+			opts: Options{PackageCoverageThresholds: map[string]float64{
+				"example.com/a": 80,
+				"example.com/c": 10,
+			}},
+			want: []string{
+				"coverage: package example.com/a: 75.0% of statements is below the minimum of 80.0%",
+				"example.com/a/x.go  100.0%  (2 of 2 statements)",
+				"coverage: package example.com/c has a minimum coverage of 10.0%, but no coverage data was recorded for it",
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := checkCoverage(tc.opts, summaries)
+			if len(tc.want) == 0 && got != "" {
+				t.Errorf("got message:\n%s\nwant none", got)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("message does not contain %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzltestutil
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// timeoutFileName is the name of the file written to the undeclared outputs
+// directory when the watchdog fires.
+const timeoutFileName = "test_timeout_stacks.txt"
+
+// running tracks top-level tests that have started but not finished.
+var running = struct {
+	sync.Mutex
+	tests map[string]time.Time
+}{tests: map[string]time.Time{}}
+
+// TrackTests wraps each test so that it's recorded as running while its
+// function executes. Running tests are named in the watchdog report and
+// when a test panics. If opts names quarantined tests, TrackTests also
+// selects the tests a child process started by Wrap should run, and the
+// results of tests are recorded as JUnit XML (see Options.Quarantine).
+func TrackTests(opts Options, tests []testing.InternalTest) []testing.InternalTest {
+	tests = quarantineTests(opts, tests)
+	rec := newJUnitRecorder(opts)
+	tracked := make([]testing.InternalTest, len(tests))
+	for i, t := range tests {
+		f := t.F
+		if rec != nil {
+			f = rec.wrap(t.Name, f)
+		}
+		tracked[i] = testing.InternalTest{Name: t.Name, F: trackTest(t.Name, f)}
+	}
+	return tracked
+}
+
+func trackTest(name string, f func(*testing.T)) func(*testing.T) {
+	return func(t *testing.T) {
+		running.Lock()
+		running.tests[name] = time.Now()
+		running.Unlock()
+		defer func() {
+			running.Lock()
+			delete(running.tests, name)
+			running.Unlock()
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				// The testing package reports the test that panicked, but not
+				// other tests running in parallel, which may be responsible.
+				// Deferred functions run before the stack is unwound, so the
+				// original stack is still reported when we panic again.
+				fmt.Fprintf(os.Stderr, "\npanic in test %s\n%s", name, formatRunningTests())
+				panic(r)
+			}
+		}()
+		f(t)
+	}
+}
+
+// formatRunningTests returns a list of running tests and how long they have
+// been running, one per line.
+func formatRunningTests() string {
+	running.Lock()
+	defer running.Unlock()
+	if len(running.tests) == 0 {
+		return "no tests are running\n"
+	}
+	names := make([]string, 0, len(running.tests))
+	for name := range running.tests {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return running.tests[names[i]].Before(running.tests[names[j]])
+	})
+	now := time.Now()
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "running tests:\n")
+	for _, name := range names {
+		fmt.Fprintf(buf, "\t%s (%v)\n", name, now.Sub(running.tests[name]).Round(time.Millisecond))
+	}
+	return buf.String()
+}
+
+// StartWatchdog starts a timer that fires shortly before Bazel's test
+// timeout (TEST_TIMEOUT) expires. When it fires, the names of running
+// tests and the stacks of all goroutines are written to standard error
+// and to a file in the undeclared outputs directory. The test continues
+// to run; Bazel will still report a timeout if it doesn't finish.
+//
+// StartWatchdog does nothing if TEST_TIMEOUT is not set.
+func StartWatchdog() {
+	timeout, ok := testTimeout()
+	if !ok {
+		return
+	}
+	margin := timeoutMargin(timeout)
+	time.AfterFunc(timeout-margin, func() {
+		reportTimeout(timeout, margin)
+	})
+}
+
+// testTimeout returns Bazel's test timeout, read from TEST_TIMEOUT, and
+// whether it's set.
+func testTimeout() (time.Duration, bool) {
+	secs, err := strconv.Atoi(os.Getenv("TEST_TIMEOUT"))
+	if err != nil || secs <= 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// timeoutMargin returns how long before the test timeout the watchdog
+// fires.
+func timeoutMargin(timeout time.Duration) time.Duration {
+	margin := 5 * time.Second
+	if margin > timeout/2 {
+		margin = timeout / 2
+	}
+	return margin
+}
+
+func reportTimeout(timeout, margin time.Duration) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "\n*** The test timeout (%v) expires in %v. Bazel will stop the test then.\n", timeout, margin)
+	buf.WriteString(formatRunningTests())
+	buf.WriteString("\ngoroutine stacks:\n")
+	buf.Write(allStacks())
+
+	if dir := os.Getenv("TEST_UNDECLARED_OUTPUTS_DIR"); dir != "" {
+		path := filepath.Join(dir, timeoutFileName)
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err == nil {
+			fmt.Fprintf(buf, "\nThis report was also written to %s in the test's undeclared outputs.\n", timeoutFileName)
+		}
+	}
+	os.Stderr.Write(buf.Bytes())
+}
+
+// allStacks returns the stacks of all goroutines, as formatted by
+// runtime.Stack.
+func allStacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
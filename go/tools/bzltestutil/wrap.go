@@ -0,0 +1,313 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bzltestutil provides run-time support for test binaries built
+// with go_test. It is imported by the main package generated for each test.
+//
+// Some features (for example, test2json event output and quarantined tests)
+// need to observe everything a test writes, including output written
+// directly by the testing package, or need to run tests more than once.
+// When one of these features is enabled, the test binary runs itself again
+// in a child process and processes the child's output in the parent. See
+// Wrap.
+//
+// This package is part of the Bazel Go rules, and its interface
+// should not be considered public. It may change without notice.
+package bzltestutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// wrapEnv is set to "0" in the environment of the child process started
+	// by Wrap, so the child runs tests instead of wrapping itself again.
+	wrapEnv = "GO_TEST_WRAP"
+
+	// jsonEnv controls test2json event output. When it's "stdout", events
+	// are written to standard output instead of the usual test output. When
+	// it's any other non-empty value, test output is written as usual,
+	// and events are written to jsonFileName in the undeclared outputs
+	// directory.
+	jsonEnv = "GO_TEST_JSON"
+
+	jsonFileName = "test.json"
+
+	// packageEnv names the package whose tests a child process should run
+	// when TestMain functions are composed. See Options.TestMainPackages.
+	packageEnv = "GO_TEST_PACKAGE"
+)
+
+// Options describes the test being run. It is filled in by the generated
+// test main.
+type Options struct {
+	// Package is the import path of the package under test. It's reported
+	// in the Package field of each event.
+	Package string
+
+	// Quarantine is a list of names of top-level tests that are known to be
+	// flaky. A quarantined test is retried up to QuarantineRetries times
+	// after it fails, and it does not cause the test binary to fail, even
+	// if every attempt fails. Each attempt runs in its own child process.
+	// When tests are quarantined, results for all tests are written as
+	// JUnit XML to XML_OUTPUT_FILE, with quarantined tests reported in a
+	// separate test suite.
+	Quarantine        []string
+	QuarantineRetries int
+
+	// TestMain is true if the package under test has a TestMain function.
+	// The generated test main can't run code after TestMain exits, so
+	// when coverage is collected, tests are run in a child process, and
+	// Wrap writes the coverage report.
+	TestMain bool
+
+	// TestMainPackages lists the packages with TestMain functions when more
+	// than one package has one (the internal and external test packages).
+	// Each package's tests are run in a separate child process under that
+	// package's TestMain, and the results are combined. The generated test
+	// main selects a package's tests using TestMainPackage.
+	TestMainPackages []string
+
+	// CoverageThreshold is the minimum percentage of statements that must
+	// be covered in all instrumented packages together.
+	// PackageCoverageThresholds sets minimums for individual packages, keyed
+	// by package path. The test fails if coverage is below a minimum.
+	// Minimums are only checked when coverage is collected (see
+	// coverageEnabled). Zero means there's no minimum.
+	CoverageThreshold         float64
+	PackageCoverageThresholds map[string]float64
+}
+
+// ShouldWrap returns whether the test binary should run its tests in a child
+// process started with Wrap.
+func ShouldWrap(opts Options) bool {
+	if os.Getenv(wrapEnv) == "0" {
+		return false
+	}
+	if coverageEnabled() && opts.TestMain {
+		return true
+	}
+	return os.Getenv(jsonEnv) != "" || len(opts.TestMainPackages) > 1 || len(opts.Quarantine) > 0
+}
+
+// TestMainPackage returns the name of the package whose tests should be run
+// by a child process started by Wrap when TestMain functions are composed.
+// See Options.TestMainPackages.
+func TestMainPackage() string {
+	return os.Getenv(packageEnv)
+}
+
+// Wrap runs the test binary again in a child process with the same arguments
+// and processes its output. If opts.TestMainPackages names more than one
+// package, a child process is started for each package, one after another.
+// Wrap returns the status the test binary should exit with.
+func Wrap(opts Options) int {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	args := os.Args[1:]
+	jsonMode := os.Getenv(jsonEnv)
+	verbose := hasFlag(args, "test.v")
+
+	var out io.Writer = os.Stdout
+	var conv *converter
+	var quiet *quietWriter
+	if jsonMode == "stdout" {
+		conv = newConverter(os.Stdout, opts.Package)
+		out = conv
+	} else if jsonMode != "" {
+		if dir := os.Getenv("TEST_UNDECLARED_OUTPUTS_DIR"); dir == "" {
+			fmt.Fprintf(os.Stderr, "warning: %s is set, but TEST_UNDECLARED_OUTPUTS_DIR is not; not writing test events\n", jsonEnv)
+		} else if f, err := os.Create(filepath.Join(dir, jsonFileName)); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not create test event file: %v\n", err)
+		} else {
+			defer f.Close()
+			conv = newConverter(f, opts.Package)
+			var log io.Writer = os.Stdout
+			if !verbose {
+				// The test log should look the same as it does without
+				// events, so verbose output is filtered out of it.
+				quiet = newQuietWriter(os.Stdout)
+				log = quiet
+			}
+			out = io.MultiWriter(log, conv)
+		}
+	}
+	if conv != nil && !verbose {
+		// Events are parsed from verbose output.
+		args = append([]string{"-test.v"}, args...)
+	}
+
+	q, err := newQuarantine(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not set up quarantined tests: %v\n", err)
+	} else if q != nil {
+		defer q.cleanup()
+	}
+
+	pkgs := opts.TestMainPackages
+	if len(pkgs) < 2 {
+		pkgs = []string{""}
+	}
+	xmlPath := os.Getenv("XML_OUTPUT_FILE")
+	var xmlPaths []string
+	var coveragePath string
+	var profilePaths []string
+	if coverageEnabled() {
+		// The test changes directories before writing its profile, so the
+		// path must be absolute.
+		coveragePath, err = filepath.Abs(os.Getenv(coverageEnv))
+		if err != nil {
+			coveragePath = os.Getenv(coverageEnv)
+		}
+	}
+	code := 0
+	for _, pkg := range pkgs {
+		env := append(os.Environ(), wrapEnv+"=0")
+		profilePath := ""
+		if coveragePath != "" {
+			// Each child writes a Go coverage profile. The counts are merged
+			// into this process's counters, which are converted to LCOV
+			// below.
+			profilePath = coveragePath + ".goprofile"
+			if pkg != "" {
+				profilePath += "." + pkg
+			}
+			env = append(env, coverageEnv+"="+profilePath)
+			profilePaths = append(profilePaths, profilePath)
+		}
+		if pkg != "" {
+			env = append(env, packageEnv+"="+pkg)
+		}
+		if xmlPath != "" && (pkg != "" || q != nil) {
+			// Each child writes its own report (if it writes one at all).
+			// The reports are merged below.
+			childXMLPath := xmlPath + ".tests"
+			if pkg != "" {
+				childXMLPath = xmlPath + "." + pkg
+			}
+			env = append(env, "XML_OUTPUT_FILE="+childXMLPath)
+			xmlPaths = append(xmlPaths, childXMLPath)
+		}
+		childEnv := env
+		if q != nil {
+			// Quarantined tests are run separately, below.
+			childEnv = append(env[:len(env):len(env)], quarantineEnv+"=-")
+		}
+		if c := runChild(exe, args, childEnv, out); code == 0 {
+			code = c
+		}
+		if q != nil {
+			// Quarantined tests are reported in verbose form when events
+			// are parsed from the output, like the tests themselves.
+			paths := q.run(exe, args, env, out, verbose || conv != nil, profilePath)
+			profilePaths = append(profilePaths, paths...)
+		}
+	}
+	if q != nil && xmlPath != "" {
+		xmlPaths = append(xmlPaths, q.results.xmlPath)
+	}
+	if len(xmlPaths) > 0 {
+		if err := mergeJUnitFiles(xmlPath, xmlPaths); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not merge test results: %v\n", err)
+		}
+	}
+	if coveragePath != "" {
+		if err := mergeProfiles(profilePaths); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not read coverage data: %v\n", err)
+		}
+		if !finishCoverage(opts, coveragePath, out) && code == 0 {
+			code = 1
+		}
+	}
+
+	if quiet != nil {
+		quiet.flush()
+	}
+	if conv != nil {
+		conv.exited(code != 0)
+	}
+	return code
+}
+
+// runChild runs the test binary with the given arguments and environment,
+// writing its standard output and error to out. It returns the child's
+// exit status.
+func runChild(exe string, args, env []string, out io.Writer) int {
+	cmd := exec.Command(exe, args...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	// Standard output and error are merged, as they are in the test log.
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "error starting test: %v\n", err)
+		return 1
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	err := cmd.Wait()
+	signal.Stop(sigs)
+	close(sigs)
+	return exitCode(err)
+}
+
+// hasFlag returns whether args contains the named flag, with one or two
+// leading dashes and optionally a value.
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if arg == name || strings.HasPrefix(arg, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// exitCode returns the exit status of a child process that finished with
+// the given error from exec.Cmd.Wait.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "error running test: %v\n", err)
+	}
+	return 1
+}
@@ -0,0 +1,120 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coverdata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// BinaryOutputEnv names the file an instrumented binary writes its
+	// coverage report to.
+	BinaryOutputEnv = "GO_COVERAGE_OUTPUT_FILE"
+
+	// BinaryReportPrefix and BinaryReportSuffix surround the names of
+	// reports written to COVERAGE_DIR by instrumented binaries when
+	// BinaryOutputEnv is not set. Tests collect these reports and merge them
+	// into their own.
+	BinaryReportPrefix = "go_binary_"
+	BinaryReportSuffix = ".lcov"
+)
+
+// BinaryMain is called at the beginning of the main function of a binary
+// built with coverage instrumentation, and the function it returns is
+// deferred. The binary's coverage report is written when main returns and
+// when the binary receives SIGINT or SIGTERM, whichever happens first. After
+// writing the report for a signal, the signal's default action is restored
+// and the signal is sent again, so the binary still stops. Coverage is not
+// written if the binary exits by calling os.Exit.
+//
+// The report is written as an LCOV tracefile to the file named by
+// GO_COVERAGE_OUTPUT_FILE. If that's not set, but COVERAGE_DIR is (it's set
+// by Bazel when tests are run with "bazel coverage"), the report is written
+// to a file in that directory, named after the binary and process ID. Tests
+// built with go_test merge these reports into their own. If neither is set,
+// nothing is written.
+func BinaryMain() func() {
+	path := binaryReportPath()
+	if path == "" {
+		return func() {}
+	}
+
+	var once sync.Once
+	write := func() {
+		once.Do(func() { writeBinaryReport(path) })
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		write()
+		signal.Reset(sig)
+		p, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil {
+			// Signals can't always be sent, for example, on Windows.
+			os.Exit(1)
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		write()
+	}
+}
+
+func binaryReportPath() string {
+	if path := os.Getenv(BinaryOutputEnv); path != "" {
+		return path
+	}
+	dir := os.Getenv("COVERAGE_DIR")
+	if dir == "" {
+		return ""
+	}
+	base := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	return filepath.Join(dir, fmt.Sprintf("%s%s.%d%s", BinaryReportPrefix, base, os.Getpid(), BinaryReportSuffix))
+}
+
+func writeBinaryReport(path string) {
+	// Write to a temporary file and rename it, so a report that's being read
+	// is never incomplete.
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "coverage: could not write report: %v\n", err)
+		return
+	}
+	err = CoverReport().WriteLcov(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		fmt.Fprintf(os.Stderr, "coverage: could not write report: %v\n", err)
+	}
+}
@@ -0,0 +1,77 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coverdata
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// binaryHelperEnv is set when the test binary is run as an instrumented
+// binary that stops itself with SIGTERM.
+const binaryHelperEnv = "COVERDATA_BINARY_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(binaryHelperEnv) != "" {
+		reset()
+		register("example.com/a", "example.com/a/a.go", "a/a.go", 1)
+		defer BinaryMain()()
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			p.Signal(syscall.SIGTERM)
+		}
+		time.Sleep(10 * time.Second)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestBinaryMainSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals can't be sent on Windows")
+	}
+	dir, err := ioutil.TempDir("", "binary_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	report := filepath.Join(dir, "coverage.lcov")
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), binaryHelperEnv+"=1", BinaryOutputEnv+"="+report)
+	err = cmd.Run()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("got %v; want the binary to be stopped by SIGTERM", err)
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); !ok || !status.Signaled() || status.Signal() != syscall.SIGTERM {
+		t.Errorf("got %v; want the binary to be stopped by SIGTERM", err)
+	}
+
+	data, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "SF:a/a.go\n") {
+		t.Errorf("report does not contain a/a.go:\n%s", data)
+	}
+}
@@ -14,7 +14,7 @@ limitations under the License.
 */
 
 // Package coverdata provides a registration function for files with
-// coverage instrumentation.
+// coverage instrumentation, and functions for writing coverage reports.
 //
 // This package is part of the Bazel Go rules, and its interface
 // should not be considered public. It may change without notice.
@@ -22,10 +22,15 @@ package coverdata
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
 )
 
-// Cover contains all coverage data for the program.
+// Cover contains all coverage data for the program. Its Mode is the mode
+// registered files were instrumented with ("set", "count", or "atomic").
 var Cover = testing.Cover{
 	Mode:            "set",
 	CoveredPackages: "",
@@ -33,19 +38,59 @@ var Cover = testing.Cover{
 	Blocks:          map[string][]testing.CoverBlock{},
 }
 
+// registered is true once a file has been registered, and Cover.Mode has
+// been set.
+var registered bool
+
+// registeredFile describes a file registered with RegisterFile.
+type registeredFile struct {
+	pkgPath, name, srcPath string
+}
+
+// files lists registered files in the order they were registered. byName
+// maps the names of registered files in Cover to the files.
+var (
+	files  []*registeredFile
+	byName = map[string]*registeredFile{}
+)
+
 // RegisterFile causes the coverage data recorded for a file to be included
 // in program-wide coverage reports. This should be called from init functions
 // in packages with coverage instrumentation.
-func RegisterFile(fileName string, counter []uint32, pos []uint32, numStmts []uint16) {
+//
+// pkgPath is the path of the package the file belongs to, as it's linked
+// into the program. fileName is the name used in Go coverage profiles
+// (normally based on the importpath). srcPath is the path of the original
+// source file, relative to the execution root, which is used in LCOV reports.
+// mode is the mode the file was instrumented with. All files in a program
+// must be instrumented with the same mode.
+//
+// Files are identified by package path and source path. Two files may be
+// given the same name, for example, when generated files with the same name
+// are compiled into different packages with the same import path. When that
+// happens, the file registered later is given a different name in Cover, and
+// a message is printed to standard error.
+func RegisterFile(pkgPath, fileName, srcPath, mode string, counter []uint32, pos []uint32, numStmts []uint16) {
 	if 3*len(counter) != len(pos) || len(counter) != len(numStmts) {
 		panic("coverage: mismatched sizes")
 	}
-	if Cover.Counters[fileName] != nil {
-		// Already registered.
-		fmt.Printf("Already covered %s\n", fileName)
-		return
+	if !registered {
+		Cover.Mode = mode
+		registered = true
+	} else if mode != Cover.Mode {
+		panic(fmt.Sprintf("coverage: %s was instrumented in mode %q, but other files were instrumented in mode %q", fileName, mode, Cover.Mode))
 	}
-	Cover.Counters[fileName] = counter
+
+	f := &registeredFile{pkgPath: pkgPath, name: fileName, srcPath: srcPath}
+	if prev, ok := byName[fileName]; ok {
+		f.name = uniqueName(f)
+		fmt.Fprintf(os.Stderr, "coverage: %s in package %s and %s in package %s are both named %s; reporting the latter as %s\n",
+			prev.srcPath, prev.pkgPath, srcPath, pkgPath, fileName, f.name)
+	}
+	files = append(files, f)
+	byName[f.name] = f
+
+	Cover.Counters[f.name] = counter
 	block := make([]testing.CoverBlock, len(counter))
 	for i := range counter {
 		block[i] = testing.CoverBlock{
@@ -56,5 +101,165 @@ func RegisterFile(fileName string, counter []uint32, pos []uint32, numStmts []ui
 			Stmts: numStmts[i],
 		}
 	}
-	Cover.Blocks[fileName] = block
+	Cover.Blocks[f.name] = block
+}
+
+// uniqueName returns a name for f that isn't used by another registered
+// file. The source path is preferred, since it's unique within a build
+// unless the same file is compiled into more than one package.
+func uniqueName(f *registeredFile) string {
+	for _, name := range []string{f.srcPath, f.pkgPath + "/" + f.srcPath} {
+		if _, ok := byName[name]; !ok && name != "" {
+			return name
+		}
+	}
+	for i := 2; ; i++ {
+		name := fmt.Sprintf("%s#%d", f.name, i)
+		if _, ok := byName[name]; !ok {
+			return name
+		}
+	}
+}
+
+// SourcePath returns the path, relative to the execution root, of the source
+// file registered with the given name. If the file was registered without
+// a path, or was not registered, fileName is returned.
+func SourcePath(fileName string) string {
+	if f, ok := byName[fileName]; ok && f.srcPath != "" {
+		return f.srcPath
+	}
+	return fileName
+}
+
+// counterValue returns the value of a coverage counter. Counters are
+// updated concurrently in atomic mode, so they must be loaded atomically.
+func counterValue(counter *uint32) uint32 {
+	if Cover.Mode == "atomic" {
+		return atomic.LoadUint32(counter)
+	}
+	return *counter
+}
+
+// FileSummary reports how many statements in a file have been executed.
+type FileSummary struct {
+	// Name is the name of the file in Cover and in Go coverage profiles.
+	Name string
+
+	// SourcePath is the path of the file relative to the execution root.
+	SourcePath string
+
+	Statements, Covered int
+}
+
+// Percent returns the percentage of statements in the file that have been
+// executed. It returns 100 if the file has no statements.
+func (s FileSummary) Percent() float64 {
+	return percent(s.Covered, s.Statements)
+}
+
+// PackageSummary reports how many statements in a package have been
+// executed.
+type PackageSummary struct {
+	// PackagePath is the path of the package, as it's linked into the
+	// program.
+	PackagePath string
+
+	// Files summarizes each file in the package, sorted by name.
+	Files []FileSummary
+
+	Statements, Covered int
+}
+
+// Percent returns the percentage of statements in the package that have
+// been executed. It returns 100 if the package has no statements.
+func (s PackageSummary) Percent() float64 {
+	return percent(s.Covered, s.Statements)
+}
+
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// Summarize returns a summary of the coverage recorded so far for each
+// package with registered files, sorted by package path.
+func Summarize() []PackageSummary {
+	var sum Summarizer
+	for _, f := range files {
+		counters := Cover.Counters[f.name]
+		for i, b := range Cover.Blocks[f.name] {
+			sum.AddBlock(f.name, int(b.Stmts), int64(counterValue(&counters[i])))
+		}
+	}
+	return sum.Summaries()
+}
+
+// PackagePath returns the path of the package the file registered with the
+// given name belongs to. If the file was not registered, the directory part
+// of fileName is returned.
+func PackagePath(fileName string) string {
+	if f, ok := byName[fileName]; ok {
+		return f.pkgPath
+	}
+	if i := strings.LastIndex(fileName, "/"); i >= 0 {
+		return fileName[:i]
+	}
+	return ""
+}
+
+// Summarizer builds coverage summaries from blocks of statements. It's used
+// by Summarize, and it may be used to summarize coverage read from a profile.
+// The zero value is ready to use.
+type Summarizer struct {
+	files map[string]*FileSummary
+}
+
+// AddBlock records that a block of stmts statements in the file registered
+// with the given name was executed count times.
+func (s *Summarizer) AddBlock(fileName string, stmts int, count int64) {
+	if s.files == nil {
+		s.files = map[string]*FileSummary{}
+	}
+	fs := s.files[fileName]
+	if fs == nil {
+		fs = &FileSummary{Name: fileName, SourcePath: SourcePath(fileName)}
+		s.files[fileName] = fs
+	}
+	fs.Statements += stmts
+	if count > 0 {
+		fs.Covered += stmts
+	}
+}
+
+// Summaries returns a summary for each package with recorded blocks, sorted
+// by package path. Files in each package are sorted by name.
+func (s *Summarizer) Summaries() []PackageSummary {
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	byPkg := map[string]*PackageSummary{}
+	var pkgPaths []string
+	for _, name := range names {
+		fs := s.files[name]
+		pkgPath := PackagePath(name)
+		ps := byPkg[pkgPath]
+		if ps == nil {
+			ps = &PackageSummary{PackagePath: pkgPath}
+			byPkg[pkgPath] = ps
+			pkgPaths = append(pkgPaths, pkgPath)
+		}
+		ps.Files = append(ps.Files, *fs)
+		ps.Statements += fs.Statements
+		ps.Covered += fs.Covered
+	}
+	sort.Strings(pkgPaths)
+	summaries := make([]PackageSummary, len(pkgPaths))
+	for i, pkgPath := range pkgPaths {
+		summaries[i] = *byPkg[pkgPath]
+	}
+	return summaries
 }
@@ -0,0 +1,108 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coverdata
+
+import (
+	"reflect"
+	"testing"
+)
+
+// reset clears registered files.
+func reset() {
+	Cover.Mode = "set"
+	Cover.Counters = map[string][]uint32{}
+	Cover.Blocks = map[string][]testing.CoverBlock{}
+	registered = false
+	files = nil
+	byName = map[string]*registeredFile{}
+}
+
+// register registers a file with one block per element of counts. Block i
+// spans line i+1 and has i+1 statements.
+func register(pkgPath, name, srcPath string, counts ...uint32) {
+	pos := make([]uint32, 3*len(counts))
+	stmts := make([]uint16, len(counts))
+	for i := range counts {
+		pos[3*i] = uint32(i + 1)
+		pos[3*i+1] = uint32(i + 1)
+		pos[3*i+2] = 1 | 10<<16
+		stmts[i] = uint16(i + 1)
+	}
+	RegisterFile(pkgPath, name, srcPath, "set", counts, pos, stmts)
+}
+
+func TestRegisterFileCollision(t *testing.T) {
+	reset()
+	defer reset()
+	register("example.com/a", "example.com/a/gen.go", "bazel-out/x/a/gen.go", 1)
+	register("vendor/example.com/a", "example.com/a/gen.go", "bazel-out/x/vendor/a/gen.go", 0)
+	register("other/example.com/a", "example.com/a/gen.go", "bazel-out/x/vendor/a/gen.go", 0)
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+	want := []string{
+		"example.com/a/gen.go",
+		"bazel-out/x/vendor/a/gen.go",
+		"other/example.com/a/bazel-out/x/vendor/a/gen.go",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got names %q; want %q", names, want)
+	}
+	for _, name := range want {
+		if Cover.Counters[name] == nil {
+			t.Errorf("no counters registered for %s", name)
+		}
+	}
+	if got := SourcePath("bazel-out/x/vendor/a/gen.go"); got != "bazel-out/x/vendor/a/gen.go" {
+		t.Errorf("SourcePath: got %q; want %q", got, "bazel-out/x/vendor/a/gen.go")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	reset()
+	defer reset()
+	register("example.com/b", "example.com/b/b.go", "b/b.go", 1, 0)
+	register("example.com/a", "example.com/a/y.go", "a/y.go", 0, 0, 1)
+	register("example.com/a", "example.com/a/x.go", "a/x.go", 1)
+
+	got := Summarize()
+	want := []PackageSummary{
+		{
+			PackagePath: "example.com/a",
+			Files: []FileSummary{
+				{Name: "example.com/a/x.go", SourcePath: "a/x.go", Statements: 1, Covered: 1},
+				{Name: "example.com/a/y.go", SourcePath: "a/y.go", Statements: 6, Covered: 3},
+			},
+			Statements: 7,
+			Covered:    4,
+		}, {
+			PackagePath: "example.com/b",
+			Files: []FileSummary{
+				{Name: "example.com/b/b.go", SourcePath: "b/b.go", Statements: 3, Covered: 1},
+			},
+			Statements: 3,
+			Covered:    1,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+	if p := got[1].Percent(); p < 33.3 || p > 33.4 {
+		t.Errorf("got percent %v; want 33.3", p)
+	}
+}
@@ -0,0 +1,332 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coverdata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Report is the coverage data in an LCOV tracefile: line counts and
+// branch counts for each source file.
+type Report struct {
+	Lines    LineCounts
+	Branches BranchCounts
+}
+
+// NewReport returns an empty report.
+func NewReport() *Report {
+	return &Report{Lines: LineCounts{}, Branches: BranchCounts{}}
+}
+
+// LineCounts records how many times each line of each source file was
+// executed. It maps source paths (see SourcePath) to line numbers to counts.
+type LineCounts map[string]map[int]int64
+
+// Add records that a block spanning lines line0 through line1 of the file
+// at path was executed count times. Go coverage is recorded for blocks of
+// statements, and a line may be spanned by more than one block, so each line
+// is given the highest count of any block that spans it.
+func (lc LineCounts) Add(path string, line0, line1 int, count int64) {
+	lines := lc[path]
+	if lines == nil {
+		lines = map[int]int64{}
+		lc[path] = lines
+	}
+	for l := line0; l <= line1; l++ {
+		if c, ok := lines[l]; !ok || count > c {
+			lines[l] = count
+		}
+	}
+}
+
+// Branch identifies a branch in an LCOV report by the line of the decision
+// it belongs to, the number of the decision on that line (Block), and the
+// number of the branch within the decision.
+type Branch struct {
+	Line, Block, Branch int
+}
+
+// BranchCounts records how many times each branch in each source file was
+// taken. It maps source paths to branches to counts. A count of -1 means
+// the decision was never reached; it's written as "-" in LCOV.
+type BranchCounts map[string]map[Branch]int64
+
+// Merge adds counts recorded by another process to r. In "set" mode, a line
+// or branch was executed if it was executed in either process. In other
+// modes, counts are added.
+func (r *Report) Merge(other *Report, mode string) {
+	for path, otherLines := range other.Lines {
+		lines := r.Lines[path]
+		if lines == nil {
+			lines = map[int]int64{}
+			r.Lines[path] = lines
+		}
+		for l, c := range otherLines {
+			lines[l] = mergeCount(lines[l], c, mode)
+		}
+	}
+	for path, otherBranches := range other.Branches {
+		branches := r.Branches[path]
+		if branches == nil {
+			branches = map[Branch]int64{}
+			r.Branches[path] = branches
+		}
+		for b, c := range otherBranches {
+			if prev, ok := branches[b]; ok {
+				c = mergeCount(prev, c, mode)
+			}
+			branches[b] = c
+		}
+	}
+}
+
+func mergeCount(a, b int64, mode string) int64 {
+	if a < 0 && b < 0 {
+		return -1
+	}
+	if a < 0 {
+		a = 0
+	}
+	if b < 0 {
+		b = 0
+	}
+	if mode == "set" {
+		if b > a {
+			return b
+		}
+		return a
+	}
+	return a + b
+}
+
+// WriteLcov writes r as an LCOV tracefile. Files, branches, and lines are
+// written in sorted order.
+func (r *Report) WriteLcov(w io.Writer) error {
+	pathSet := map[string]bool{}
+	for path := range r.Lines {
+		pathSet[path] = true
+	}
+	for path := range r.Branches {
+		pathSet[path] = true
+	}
+	paths := make([]string, 0, len(pathSet))
+	for path := range pathSet {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	bw := bufio.NewWriter(w)
+	for _, path := range paths {
+		fmt.Fprintf(bw, "SF:%s\n", path)
+
+		branches := r.Branches[path]
+		keys := make([]Branch, 0, len(branches))
+		for b := range branches {
+			keys = append(keys, b)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].Line != keys[j].Line {
+				return keys[i].Line < keys[j].Line
+			}
+			if keys[i].Block != keys[j].Block {
+				return keys[i].Block < keys[j].Block
+			}
+			return keys[i].Branch < keys[j].Branch
+		})
+		branchesHit := 0
+		for _, b := range keys {
+			c := branches[b]
+			taken := "-"
+			if c >= 0 {
+				taken = strconv.FormatInt(c, 10)
+			}
+			if c > 0 {
+				branchesHit++
+			}
+			fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", b.Line, b.Block, b.Branch, taken)
+		}
+		if len(keys) > 0 {
+			fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", len(keys), branchesHit)
+		}
+
+		lines := r.Lines[path]
+		nums := make([]int, 0, len(lines))
+		for l := range lines {
+			nums = append(nums, l)
+		}
+		sort.Ints(nums)
+		hit := 0
+		for _, l := range nums {
+			c := lines[l]
+			if c > 0 {
+				hit++
+			}
+			fmt.Fprintf(bw, "DA:%d,%d\n", l, c)
+		}
+		fmt.Fprintf(bw, "LH:%d\nLF:%d\nend_of_record\n", hit, len(nums))
+	}
+	return bw.Flush()
+}
+
+// ParseLcov reads a report from an LCOV tracefile. Only SF, DA, and BRDA
+// records are read; other records are ignored.
+func ParseLcov(r io.Reader) (*Report, error) {
+	report := NewReport()
+	path := ""
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			path = strings.TrimPrefix(line, "SF:")
+			if report.Lines[path] == nil {
+				report.Lines[path] = map[int]int64{}
+			}
+		case strings.HasPrefix(line, "DA:"):
+			if path == "" {
+				return nil, fmt.Errorf("DA record before SF record: %q", line)
+			}
+			nums, err := parseRecord(line, "DA:", 2)
+			if err != nil {
+				return nil, err
+			}
+			report.Lines[path][int(nums[0])] += nums[1]
+		case strings.HasPrefix(line, "BRDA:"):
+			if path == "" {
+				return nil, fmt.Errorf("BRDA record before SF record: %q", line)
+			}
+			nums, err := parseRecord(line, "BRDA:", 4)
+			if err != nil {
+				return nil, err
+			}
+			branches := report.Branches[path]
+			if branches == nil {
+				branches = map[Branch]int64{}
+				report.Branches[path] = branches
+			}
+			b := Branch{Line: int(nums[0]), Block: int(nums[1]), Branch: int(nums[2])}
+			if prev, ok := branches[b]; ok {
+				nums[3] = mergeCount(prev, nums[3], "count")
+			}
+			branches[b] = nums[3]
+		case line == "end_of_record":
+			path = ""
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// parseRecord parses the comma-separated numbers in a record. "-" is read
+// as -1. Extra fields (like the checksum in a DA record) are ignored.
+func parseRecord(line, prefix string, n int) ([]int64, error) {
+	fields := strings.Split(strings.TrimPrefix(line, prefix), ",")
+	if len(fields) < n {
+		return nil, fmt.Errorf("bad %s record: %q", strings.TrimSuffix(prefix, ":"), line)
+	}
+	nums := make([]int64, n)
+	for i := range nums {
+		if fields[i] == "-" {
+			nums[i] = -1
+			continue
+		}
+		x, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s record: %q", strings.TrimSuffix(prefix, ":"), line)
+		}
+		nums[i] = x
+	}
+	return nums, nil
+}
+
+// CoverReport returns a report of the coverage recorded so far in Cover.
+// Files are named by their source paths.
+func CoverReport() *Report {
+	r := NewReport()
+	for name, counters := range Cover.Counters {
+		path := SourcePath(name)
+		blocks := Cover.Blocks[name]
+		counts := make([]int64, len(counters))
+		for i := range counters {
+			counts[i] = int64(counterValue(&counters[i]))
+			r.Lines.Add(path, int(blocks[i].Line0), int(blocks[i].Line1), counts[i])
+		}
+		if branches := blockBranches(blocks, counts); len(branches) > 0 {
+			r.Branches[path] = branches
+		}
+	}
+	return r
+}
+
+// blockBranches derives branches from the blocks of a file. Go coverage
+// doesn't record branches directly, but a block that starts at a deeper
+// column than the blocks before it is almost always the body of an if, for,
+// switch, or select statement (or a case clause) that starts where an
+// enclosing block ends. Each such block is reported as a branch of the line
+// where the enclosing block ends, taken as many times as the block was
+// executed. Branches of an enclosing block that was never executed are
+// reported as not reached.
+func blockBranches(blocks []testing.CoverBlock, counts []int64) map[Branch]int64 {
+	order := make([]int, len(blocks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := blocks[order[i]], blocks[order[j]]
+		if a.Line0 != b.Line0 {
+			return a.Line0 < b.Line0
+		}
+		return a.Col0 < b.Col0
+	})
+
+	branches := map[Branch]int64{}
+	decisions := map[int]Branch{}    // enclosing block -> its line, block, and next branch number
+	decisionsOnLine := map[int]int{} // line -> number of decisions
+	var enclosing []int
+	for _, i := range order {
+		b := blocks[i]
+		for len(enclosing) > 0 && blocks[enclosing[len(enclosing)-1]].Col0 >= b.Col0 {
+			enclosing = enclosing[:len(enclosing)-1]
+		}
+		if len(enclosing) > 0 {
+			parent := enclosing[len(enclosing)-1]
+			if pb := blocks[parent]; pb.Line1 <= b.Line0 {
+				d, ok := decisions[parent]
+				if !ok {
+					line := int(pb.Line1)
+					d = Branch{Line: line, Block: decisionsOnLine[line]}
+					decisionsOnLine[line]++
+				}
+				taken := counts[i]
+				if counts[parent] == 0 {
+					taken = -1
+				}
+				branches[d] = taken
+				d.Branch++
+				decisions[parent] = d
+			}
+		}
+		enclosing = append(enclosing, i)
+	}
+	return branches
+}
@@ -0,0 +1,236 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coverdata
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// registerBlocks registers a file with blocks given as
+// {line0, col0, line1, col1, count}.
+func registerBlocks(name, srcPath, mode string, blocks ...[5]uint32) {
+	counts := make([]uint32, len(blocks))
+	pos := make([]uint32, 3*len(blocks))
+	stmts := make([]uint16, len(blocks))
+	for i, b := range blocks {
+		pos[3*i] = b[0]
+		pos[3*i+1] = b[2]
+		pos[3*i+2] = b[1] | b[3]<<16
+		stmts[i] = 1
+		counts[i] = b[4]
+	}
+	RegisterFile("example.com/a", name, srcPath, mode, counts, pos, stmts)
+}
+
+// registerExample registers blocks for this file:
+//
+//	 3 func Abs(x int) int {
+//	 4 	if x < 0 {
+//	 5 		return -x
+//	 6 	}
+//	 7 	return x
+//	 8 }
+//	 9
+//	10 func Sign(x int) int {
+//	11 	switch {
+//	12 	case x < 0:
+//	13 		return -1
+//	14 	case x > 0:
+//	15 		return 1
+//	16 	}
+//	17 	return 0
+//	18 }
+//
+// Abs and Sign have each been called once with a positive number.
+func registerExample(mode string) {
+	registerBlocks("example.com/a/a.go", "a/a.go", mode,
+		[5]uint32{4, 2, 4, 11, 1},
+		[5]uint32{5, 3, 6, 1, 0},
+		[5]uint32{7, 2, 7, 10, 1},
+		[5]uint32{11, 2, 11, 9, 1},
+		[5]uint32{13, 3, 13, 12, 0},
+		[5]uint32{15, 3, 15, 11, 1},
+		[5]uint32{17, 2, 17, 10, 0},
+	)
+}
+
+func TestCoverReport(t *testing.T) {
+	reset()
+	defer reset()
+	registerExample("set")
+	// Loop has not been called:
+	//
+	//	21 	for i := 0; i < n; i++ {
+	//	22 		s += i
+	//	23 	}
+	registerBlocks("example.com/a/loop.go", "a/loop.go", "set",
+		[5]uint32{21, 2, 21, 25, 0},
+		[5]uint32{22, 3, 23, 1, 0},
+	)
+
+	buf := &bytes.Buffer{}
+	if err := CoverReport().WriteLcov(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `SF:a/a.go
+BRDA:4,0,0,0
+BRDA:11,0,0,0
+BRDA:11,0,1,1
+BRF:3
+BRH:1
+DA:4,1
+DA:5,0
+DA:6,0
+DA:7,1
+DA:11,1
+DA:13,0
+DA:15,1
+DA:17,0
+LH:4
+LF:8
+end_of_record
+SF:a/loop.go
+BRDA:21,0,0,-
+BRF:1
+BRH:0
+DA:21,0
+DA:22,0
+DA:23,0
+LH:0
+LF:3
+end_of_record
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMergeLcov(t *testing.T) {
+	a := `SF:a.go
+BRDA:1,0,0,-
+BRDA:1,0,1,2
+DA:1,1
+DA:2,0
+end_of_record
+`
+	b := `SF:a.go
+BRDA:1,0,0,1
+BRDA:1,0,1,3
+DA:1,4
+DA:2,0
+end_of_record
+SF:b.go
+DA:1,1,checksum
+end_of_record
+`
+	for _, tc := range []struct {
+		mode, want string
+	}{
+		{
+			mode: "set",
+			want: `SF:a.go
+BRDA:1,0,0,1
+BRDA:1,0,1,3
+BRF:2
+BRH:2
+DA:1,4
+DA:2,0
+LH:1
+LF:2
+end_of_record
+SF:b.go
+DA:1,1
+LH:1
+LF:1
+end_of_record
+`,
+		}, {
+			mode: "count",
+			want: `SF:a.go
+BRDA:1,0,0,1
+BRDA:1,0,1,5
+BRF:2
+BRH:2
+DA:1,5
+DA:2,0
+LH:1
+LF:2
+end_of_record
+SF:b.go
+DA:1,1
+LH:1
+LF:1
+end_of_record
+`,
+		},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			ra, err := ParseLcov(strings.NewReader(a))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rb, err := ParseLcov(strings.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ra.Merge(rb, tc.mode)
+			buf := &bytes.Buffer{}
+			if err := ra.WriteLcov(buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeProfile(t *testing.T) {
+	reset()
+	defer reset()
+	registerExample("count")
+	ResetCounters()
+	for _, p := range []string{`mode: count
+example.com/a/a.go:4.2,4.11 1 2
+example.com/a/a.go:5.3,6.1 1 1
+`, `mode: count
+example.com/a/a.go:4.2,4.11 1 1
+example.com/a/a.go:7.2,7.10 1 1
+`} {
+		if err := MergeProfile(strings.NewReader(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := Cover.Counters["example.com/a/a.go"]
+	want := []uint32{3, 1, 1, 0, 0, 0, 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got counters %v; want %v", got, want)
+	}
+
+	for _, p := range []string{
+		"mode: set\n",
+		"mode: count\nexample.com/a/a.go:1.1,1.10 1 1\n",
+		"mode: count\nexample.com/a/a.go 1 1\n",
+		"example.com/a/a.go:4.2,4.11 1 1\n",
+	} {
+		if err := MergeProfile(strings.NewReader(p)); err == nil {
+			t.Errorf("MergeProfile(%q): got success; want error", p)
+		}
+	}
+}
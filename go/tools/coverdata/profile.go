@@ -0,0 +1,107 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package coverdata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ResetCounters sets all coverage counters in Cover to zero.
+func ResetCounters() {
+	for _, counters := range Cover.Counters {
+		for i := range counters {
+			counters[i] = 0
+		}
+	}
+}
+
+// MergeProfile adds the counts in a Go coverage profile to the counters in
+// Cover. The profile must have been written by another process running the
+// same program (for example, a child process running tests), so every
+// block in the profile is a block registered in Cover. In "set" mode, a
+// block was executed if it was executed in either process. In other modes,
+// counts are added.
+func MergeProfile(r io.Reader) error {
+	type blockPos struct {
+		name                     string
+		line0, col0, line1, col1 int
+	}
+	index := map[blockPos]*uint32{}
+	for name, blocks := range Cover.Blocks {
+		counters := Cover.Counters[name]
+		for i, b := range blocks {
+			index[blockPos{name, int(b.Line0), int(b.Col0), int(b.Line1), int(b.Col1)}] = &counters[i]
+		}
+	}
+
+	s := bufio.NewScanner(r)
+	first := true
+	for s.Scan() {
+		line := s.Text()
+		if first {
+			first = false
+			mode := strings.TrimPrefix(line, "mode: ")
+			if mode == line {
+				return fmt.Errorf("bad mode line: %q", line)
+			}
+			if mode != Cover.Mode {
+				return fmt.Errorf("profile has coverage mode %q, but this program has mode %q", mode, Cover.Mode)
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+
+		// Lines have the form "name.go:line0.col0,line1.col1 stmts count".
+		bad := fmt.Errorf("bad coverage profile line: %q", line)
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			return bad
+		}
+		fields := strings.FieldsFunc(line[i+1:], func(r rune) bool {
+			return r == '.' || r == ',' || r == ' '
+		})
+		if len(fields) != 6 {
+			return bad
+		}
+		var nums [6]int
+		for j, f := range fields {
+			n, err := strconv.Atoi(f)
+			if err != nil {
+				return bad
+			}
+			nums[j] = n
+		}
+		counter := index[blockPos{line[:i], nums[0], nums[1], nums[2], nums[3]}]
+		if counter == nil {
+			return fmt.Errorf("coverage profile has a block that's not registered in this program: %q", line)
+		}
+		count := uint32(nums[5])
+		if Cover.Mode == "set" {
+			if count > *counter {
+				*counter = count
+			}
+		} else {
+			*counter += count
+		}
+	}
+	return s.Err()
+}
@@ -8,22 +8,34 @@
 //
 // These differences help us to manage external Go repositories in the manner of
 // Bazel.
+//
+// When --version is set, fetch_repo downloads a module instead. The module
+// named by --importpath is fetched from a module proxy (--proxy or GOPROXY),
+// checked against the go.sum hash given with --sum, and extracted into --dest.
+// --sum is required unless --allow_unverified is set.
+// Version control tools are not needed in this mode, and file:// proxies may
+// be used to fetch modules without network access.
 package main
 
 import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"golang.org/x/tools/go/vcs"
 )
 
 var (
-	remote     = flag.String("remote", "", "The URI of the remote repository. Must be used with the --vcs flag.")
-	cmd        = flag.String("vcs", "", "Version control system to use to fetch the repository. Should be one of: git,hg,svn,bzr. Must be used with the --remote flag.")
-	rev        = flag.String("rev", "", "target revision")
-	dest       = flag.String("dest", "", "destination directory")
-	importpath = flag.String("importpath", "", "Go importpath to the repository fetch")
+	remote          = flag.String("remote", "", "The URI of the remote repository. Must be used with the --vcs flag.")
+	cmd             = flag.String("vcs", "", "Version control system to use to fetch the repository. Should be one of: git,hg,svn,bzr. Must be used with the --remote flag.")
+	rev             = flag.String("rev", "", "target revision")
+	dest            = flag.String("dest", "", "destination directory")
+	importpath      = flag.String("importpath", "", "Go importpath to the repository fetch")
+	version         = flag.String("version", "", "module version to fetch from a module proxy. Must be used with --importpath, which names the module.")
+	sum             = flag.String("sum", "", "hash of the module, as listed in go.sum (h1:...). Required with --version unless --allow_unverified is set.")
+	allowUnverified = flag.Bool("allow_unverified", false, "fetch a module with --version without checking it against --sum. Its hash is printed instead.")
+	proxy           = flag.String("proxy", os.Getenv("GOPROXY"), "list of module proxy URLs (http, https, or file) separated by ',' or '|', like GOPROXY, used with --version. Defaults to GOPROXY.")
 
 	// Used for overriding in tests to disable network calls.
 	repoRootForImportPath = vcs.RepoRootForImportPath
@@ -59,6 +71,13 @@ func getRepoRoot(remote, cmd, importpath string) (*vcs.RepoRoot, error) {
 }
 
 func run() error {
+	if *version != "" {
+		if *remote != "" || *cmd != "" || *rev != "" {
+			return fmt.Errorf("--version can't be used with --remote, --vcs, or --rev")
+		}
+		return fetchModule(*proxy, *importpath, *version, *sum, *allowUnverified, *dest)
+	}
+
 	r, err := getRepoRoot(*remote, *cmd, *importpath)
 	if err != nil {
 		return err
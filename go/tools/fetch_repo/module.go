@@ -0,0 +1,334 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// errNotFound is returned by download when a proxy doesn't have a module.
+var errNotFound = errors.New("not found")
+
+// httpClient is used to download modules from proxies. Its timeout covers
+// the whole download, so a proxy that stops responding doesn't hang the
+// repository rule.
+var httpClient = &http.Client{Timeout: 10 * time.Minute}
+
+// fetchModule downloads a module zip file from a proxy that implements the
+// GOPROXY protocol, checks it against sum, and extracts it into dest.
+//
+// proxies is a list of proxy URLs, like GOPROXY. They're tried in order until
+// one has the module. file:// URLs name a directory laid out like a proxy,
+// for example, the module download cache in $GOPATH/pkg/mod/cache/download.
+// As with the go command, a proxy followed by a comma is skipped only if it
+// responds with 404 or 410, and a proxy followed by "|" is skipped after any
+// error. "direct" and "off" are only reported as errors if they're reached,
+// since modules can't be fetched from version control here.
+//
+// sum is the hash of the module's files, as listed in go.sum, for example,
+// "h1:...". If sum is empty, fetchModule reports an error with the module's
+// hash so it can be added, unless allowUnverified is set, in which case the
+// hash is printed and the module is extracted without being verified.
+func fetchModule(proxies, modPath, version, sum string, allowUnverified bool, dest string) error {
+	if strings.TrimSpace(proxies) == "" {
+		return errors.New("no module proxy: set --proxy or GOPROXY")
+	}
+	if err := checkModulePath(modPath); err != nil {
+		return err
+	}
+	if version == "" || strings.Contains(version, "/") || version == "." || version == ".." {
+		return fmt.Errorf("invalid module version %q", version)
+	}
+
+	tmp, err := ioutil.TempFile("", "fetch_repo")
+	if err != nil {
+		return err
+	}
+	zipPath := tmp.Name()
+	defer os.Remove(zipPath)
+	err = downloadZip(tmp, proxies, modPath, version)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	hash, err := hashZip(zipPath)
+	if err != nil {
+		return err
+	}
+	if sum == "" {
+		if !allowUnverified {
+			return fmt.Errorf("%s %s: --sum was not set; the module's sum is %s\n\tuse --allow_unverified to fetch it without verification", modPath, version, hash)
+		}
+		fmt.Fprintf(os.Stderr, "fetch_repo: %s %s: not verified; sum is %s\n", modPath, version, hash)
+	} else if sum != hash {
+		return fmt.Errorf("%s %s: checksum mismatch\n\tdownloaded: %s\n\texpected:   %s", modPath, version, hash, sum)
+	}
+	return extractZip(zipPath, modPath, version, dest)
+}
+
+// proxySpec is an element of a GOPROXY list.
+type proxySpec struct {
+	url string
+
+	// fallBackOnError is true if the next proxy should be tried after any
+	// error, not only when the module is not found. It's true for proxies
+	// followed by "|".
+	fallBackOnError bool
+}
+
+// parseProxies splits a GOPROXY list into its elements, which are
+// separated by commas or "|".
+func parseProxies(proxies string) []proxySpec {
+	var specs []proxySpec
+	for proxies != "" {
+		var elem string
+		fallBackOnError := false
+		if i := strings.IndexAny(proxies, ",|"); i >= 0 {
+			elem, fallBackOnError, proxies = proxies[:i], proxies[i] == '|', proxies[i+1:]
+		} else {
+			elem, proxies = proxies, ""
+		}
+		if elem = strings.TrimSpace(elem); elem != "" {
+			specs = append(specs, proxySpec{url: elem, fallBackOnError: fallBackOnError})
+		}
+	}
+	return specs
+}
+
+// downloadZip writes the zip file for a module version to f, trying each
+// proxy in order. f is truncated before each attempt, so it only contains
+// the file from the proxy that succeeded.
+func downloadZip(f *os.File, proxies, modPath, version string) error {
+	escPath, err := escapeModulePath(modPath)
+	if err != nil {
+		return err
+	}
+	escVersion, err := escapeModulePath(version)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, proxy := range parseProxies(proxies) {
+		switch proxy.url {
+		case "direct":
+			errs = append(errs, "direct: fetching modules from version control is not supported with --version; use --vcs and --remote")
+			return fmt.Errorf("%s %s not found:\n\t%s", modPath, version, strings.Join(errs, "\n\t"))
+		case "off":
+			errs = append(errs, "off: module downloads are disabled by GOPROXY=off")
+			return fmt.Errorf("%s %s not found:\n\t%s", modPath, version, strings.Join(errs, "\n\t"))
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		zipURL := strings.TrimSuffix(proxy.url, "/") + "/" + escPath + "/@v/" + escVersion + ".zip"
+		err := download(f, zipURL)
+		if err == nil {
+			return nil
+		}
+		if err == errNotFound {
+			errs = append(errs, zipURL+": not found")
+			continue
+		}
+		if !proxy.fallBackOnError {
+			return err
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("%s %s not found in any proxy:\n\t%s", modPath, version, strings.Join(errs, "\n\t"))
+}
+
+// download copies the file at an http://, https://, or file:// URL to w.
+// It returns errNotFound if the file does not exist.
+func download(w io.Writer, rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "file" {
+		p := u.Path
+		if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+			// file:///C:/path on Windows.
+			p = p[1:]
+		}
+		f, err := os.Open(filepath.FromSlash(p))
+		if os.IsNotExist(err) {
+			return errNotFound
+		} else if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	}
+
+	resp, err := httpClient.Get(rawurl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("%s: %s", rawurl, resp.Status)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("%s: %v", rawurl, err)
+	}
+	return nil
+}
+
+// escapeModulePath escapes a module path or version for use in a proxy URL.
+// Upper-case letters are replaced by "!" followed by the lower-case letter,
+// so that paths differing only in case map to different files on
+// case-insensitive file systems.
+func escapeModulePath(s string) (string, error) {
+	buf := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '!' || r >= utf8.RuneSelf:
+			return "", fmt.Errorf("invalid character %q in %q", r, s)
+		case 'A' <= r && r <= 'Z':
+			buf = append(buf, '!', byte(r+'a'-'A'))
+		default:
+			buf = append(buf, byte(r))
+		}
+	}
+	return string(buf), nil
+}
+
+func checkModulePath(modPath string) error {
+	if modPath == "" || path.Clean(modPath) != modPath || strings.HasPrefix(modPath, "/") || strings.HasPrefix(modPath, "../") || modPath == ".." || strings.Contains(modPath, "@") {
+		return fmt.Errorf("invalid module path %q", modPath)
+	}
+	return nil
+}
+
+// hashZip returns the "h1:" hash of the files in a module zip file, as
+// recorded in go.sum. It's the SHA-256 of a summary with one line for each
+// file, sorted by name. Each line has the hex SHA-256 of the file's content,
+// two spaces, and the file's name in the zip file.
+func hashZip(zipPath string) (string, error) {
+	z, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return "", err
+	}
+	defer z.Close()
+	files := make(map[string]*zip.File)
+	names := make([]string, 0, len(z.File))
+	for _, f := range z.File {
+		if strings.Contains(f.Name, "\n") {
+			return "", fmt.Errorf("%s: file name %q contains a newline", zipPath, f.Name)
+		}
+		if _, ok := files[f.Name]; ok {
+			return "", fmt.Errorf("%s: duplicate file %s", zipPath, f.Name)
+		}
+		files[f.Name] = f
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	summary := sha256.New()
+	for _, name := range names {
+		r, err := files[name].Open()
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(summary, "%x  %s\n", h.Sum(nil), name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
+
+// extractZip extracts a module zip file into dest. Every file in the zip
+// must be in a directory named "modPath@version", which is not included in
+// the extracted paths. All names are checked before anything is written, so
+// a zip with a file that would be written outside dest is rejected without
+// extracting any files.
+func extractZip(zipPath, modPath, version, dest string) error {
+	z, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	prefix := modPath + "@" + version + "/"
+	var files []*zip.File
+	var names []string
+	for _, f := range z.File {
+		if !strings.HasPrefix(f.Name, prefix) {
+			return fmt.Errorf("%s: file %s is not in %s", zipPath, f.Name, prefix)
+		}
+		name := f.Name[len(prefix):]
+		if name == "" || strings.HasSuffix(name, "/") {
+			// Directories are created as needed for files.
+			continue
+		}
+		if !isValidZipName(name) {
+			return fmt.Errorf("%s: invalid file name %s", zipPath, f.Name)
+		}
+		files = append(files, f)
+		names = append(names, name)
+	}
+
+	for i, f := range files {
+		outPath := filepath.Join(dest, filepath.FromSlash(names[i]))
+		if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
+			return err
+		}
+		if err := extractFile(f, outPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isValidZipName reports whether name, a slash-separated path relative to
+// the module root, names a file inside the module root.
+func isValidZipName(name string) bool {
+	if path.Clean(name) != name || name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return false
+	}
+	// Backslashes and drive letters would be path separators or volume
+	// names on Windows.
+	return !strings.ContainsAny(name, `\:`)
+}
+
+func extractFile(f *zip.File, outPath string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var moduleFiles = []struct{ name, content string }{
+	{"example.com/Foo@v1.0.0/go.mod", "module example.com/Foo\n"},
+	{"example.com/Foo@v1.0.0/foo.go", "package foo\n"},
+	{"example.com/Foo@v1.0.0/sub/sub.go", "package sub\n"},
+}
+
+// moduleSum is the go.sum hash of moduleFiles, computed independently.
+const moduleSum = "h1:hOhvgCkPaOorFK74ecyvaUNEU9EUQcMNtAzO/L272Cg="
+
+// makeProxy creates a directory laid out like a module proxy containing
+// example.com/Foo@v1.0.0.
+func makeProxy(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fetch_repo_proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "example.com", "!foo", "@v", "v1.0.0.zip")
+	if err := os.MkdirAll(filepath.Dir(zipPath), 0777); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, mf := range moduleFiles {
+		w, err := zw.Create(mf.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(mf.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func checkModule(t *testing.T, dest string) {
+	for _, mf := range moduleFiles {
+		name := strings.SplitN(mf.name, "/", 3)[2]
+		data, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+		} else if string(data) != mf.content {
+			t.Errorf("%s: got %q; want %q", name, data, mf.content)
+		}
+	}
+}
+
+func TestFetchModule(t *testing.T) {
+	proxyDir := makeProxy(t)
+	defer os.RemoveAll(proxyDir)
+	fileProxy := "file://" + filepath.ToSlash(proxyDir)
+	if !strings.HasPrefix(fileProxy, "file:///") {
+		fileProxy = "file:///" + strings.TrimPrefix(fileProxy, "file://")
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(proxyDir)))
+	defer server.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Write part of a file before failing, so fallbacks must discard it.
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+	}))
+	defer broken.Close()
+
+	for _, tc := range []struct {
+		desc, proxies, sum string
+		allowUnverified    bool
+		wantErr            bool
+	}{
+		{desc: "file", proxies: fileProxy, sum: moduleSum},
+		{desc: "http", proxies: server.URL, sum: moduleSum},
+		{desc: "fallback", proxies: server.URL + "/missing," + fileProxy, sum: moduleSum},
+		{desc: "no_sum", proxies: fileProxy, wantErr: true},
+		{desc: "unverified", proxies: fileProxy, allowUnverified: true},
+		{desc: "mismatch", proxies: fileProxy, sum: "h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", wantErr: true},
+		{desc: "not_found", proxies: server.URL + "/missing", sum: moduleSum, wantErr: true},
+		{desc: "pipe_fallback", proxies: broken.URL + "|" + fileProxy, sum: moduleSum},
+		{desc: "comma_no_fallback", proxies: broken.URL + "," + fileProxy, sum: moduleSum, wantErr: true},
+		{desc: "pipe_then_comma", proxies: broken.URL + "|" + server.URL + "/missing," + fileProxy, sum: moduleSum},
+		{desc: "before_direct", proxies: fileProxy + ",direct", sum: moduleSum},
+		{desc: "direct", proxies: "direct", sum: moduleSum, wantErr: true},
+		{desc: "missing_then_direct", proxies: server.URL + "/missing,direct", sum: moduleSum, wantErr: true},
+		{desc: "off", proxies: "off", sum: moduleSum, wantErr: true},
+		{desc: "no_proxy", sum: moduleSum, wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dest, err := ioutil.TempDir("", "fetch_repo_dest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dest)
+			err = fetchModule(tc.proxies, "example.com/Foo", "v1.0.0", tc.sum, tc.allowUnverified, dest)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("got success; want error")
+				}
+				if _, err := os.Stat(filepath.Join(dest, "go.mod")); err == nil {
+					t.Error("module was extracted after an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkModule(t, dest)
+		})
+	}
+}
+
+func TestEscapeModulePath(t *testing.T) {
+	for _, tc := range []struct {
+		path, want string
+		wantErr    bool
+	}{
+		{path: "github.com/bazelbuild/rules_go", want: "github.com/bazelbuild/rules_go"},
+		{path: "github.com/Azure/azure-sdk-for-go", want: "github.com/!azure/azure-sdk-for-go"},
+		{path: "v1.0.0-RC1", want: "v1.0.0-!r!c1"},
+		{path: "example.com/!bad", wantErr: true},
+	} {
+		got, err := escapeModulePath(tc.path)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("escapeModulePath(%q): got %q; want error", tc.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("escapeModulePath(%q): %v", tc.path, err)
+		} else if got != tc.want {
+			t.Errorf("escapeModulePath(%q): got %q; want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	got := parseProxies(" https://a.example.com,https://b.example.com|file:///c ,, direct")
+	want := []proxySpec{
+		{url: "https://a.example.com"},
+		{url: "https://b.example.com", fallBackOnError: true},
+		{url: "file:///c"},
+		{url: "direct"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	defer func(timeout time.Duration) { httpClient.Timeout = timeout }(httpClient.Timeout)
+	httpClient.Timeout = 100 * time.Millisecond
+
+	if err := download(ioutil.Discard, server.URL+"/m/@v/v1.0.0.zip"); err == nil || err == errNotFound {
+		t.Errorf("got %v; want timeout error", err)
+	}
+}
+
+func TestExtractZipBadName(t *testing.T) {
+	for _, name := range []string{"..", "../evil.go", "sub/../../evil.go", "/evil.go", `sub\evil.go`, "c:evil.go", "./evil.go"} {
+		t.Run(name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "fetch_repo_zip")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			zipPath := filepath.Join(tmpDir, "m.zip")
+			f, err := os.Create(zipPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw := zip.NewWriter(f)
+			// The valid file comes first, so it would be extracted if names
+			// weren't checked before writing.
+			for _, n := range []string{"go.mod", name} {
+				w, err := zw.Create("example.com/m@v1.0.0/" + n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write([]byte("data")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			dest := filepath.Join(tmpDir, "dest")
+			if err := extractZip(zipPath, "example.com/m", "v1.0.0", dest); err == nil {
+				t.Fatal("got success; want error")
+			}
+			if _, err := os.Stat(dest); !os.IsNotExist(err) {
+				t.Errorf("files were extracted from an invalid zip: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractZipBadPrefix(t *testing.T) {
+	proxyDir := makeProxy(t)
+	defer os.RemoveAll(proxyDir)
+	zipPath := filepath.Join(proxyDir, "example.com", "!foo", "@v", "v1.0.0.zip")
+	dest, err := ioutil.TempDir("", "fetch_repo_dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+	if err := extractZip(zipPath, "example.com/Foo", "v1.1.0", dest); err == nil {
+		t.Error("got success for a zip with files for another version; want error")
+	}
+}
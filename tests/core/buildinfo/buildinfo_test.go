@@ -0,0 +1,33 @@
+package buildinfo_test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/buildinfo"
+)
+
+func TestBuildinfo(t *testing.T) {
+	info := buildinfo.Get()
+	if want := "//tests/core/buildinfo:buildinfo_test"; info.Label != want {
+		t.Errorf("got label %q; want %q", info.Label, want)
+	}
+	if want := runtime.GOOS + "_" + runtime.GOARCH; len(info.Mode) < len(want) || info.Mode[:len(want)] != want {
+		t.Errorf("got mode %q; want a mode starting with %q", info.Mode, want)
+	}
+	found := false
+	for _, dep := range info.Deps {
+		if dep.PackagePath == "github.com/bazelbuild/rules_go/go/tools/buildinfo" {
+			found = true
+			if want := "//go/tools/buildinfo:go_default_library"; dep.Label != want {
+				t.Errorf("got label %q for buildinfo; want %q", dep.Label, want)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("buildinfo not found in deps: %v", info.Deps)
+	}
+	if info.Stamped() {
+		t.Errorf("binary is stamped, but it was not built with --stamp")
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "fmt"
+
+func main() {
+	fmt.Println(greeting())
+}
+
+func greeting() string {
+	return "hello"
+}
+
+func unused() string {
+	return "goodbye"
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bazelbuild/rules_go/tests/core/coverage/c"
+)
+
+func main() {
+	if c.CLive() == 12 {
+		fmt.Println("hello")
+	}
+}
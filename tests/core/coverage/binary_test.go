@@ -0,0 +1,23 @@
+package binary_test
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+)
+
+func TestBinary(t *testing.T) {
+	path, err := bazel.Runfile(os.Args[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if string(out) != "hello\n" {
+		t.Errorf("got %q; want %q", out, "hello\n")
+	}
+}
@@ -0,0 +1,9 @@
+package branch
+
+import "testing"
+
+func TestAbs(t *testing.T) {
+	if Abs(1) != 1 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,9 @@
+package a
+
+import "testing"
+
+func TestLiveThreeTimes(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		ALive()
+	}
+}
@@ -0,0 +1,17 @@
+package alias
+
+import "testing"
+
+type T = testing.T
+
+var ran bool
+
+func TestAlias(t *T) {
+	ran = true
+}
+
+func TestAliasRan(t *testing.T) {
+	if !ran {
+		t.Error("TestAlias was not run")
+	}
+}
@@ -0,0 +1,19 @@
+package compose_test
+
+import (
+	"os"
+	"testing"
+)
+
+var setup string
+
+func TestExternal(t *testing.T) {
+	if setup != "external" {
+		t.Errorf("got setup %q; want %q", setup, "external")
+	}
+}
+
+func TestMain(m *testing.M) {
+	setup = "external"
+	os.Exit(m.Run())
+}
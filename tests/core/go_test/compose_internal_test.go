@@ -0,0 +1,19 @@
+package compose
+
+import (
+	"os"
+	"testing"
+)
+
+var setup string
+
+func TestInternal(t *testing.T) {
+	if setup != "internal" {
+		t.Errorf("got setup %q; want %q", setup, "internal")
+	}
+}
+
+func TestMain(m *testing.M) {
+	setup = "internal"
+	os.Exit(m.Run())
+}
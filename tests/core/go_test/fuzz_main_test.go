@@ -0,0 +1,20 @@
+package fuzz
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// wantSeeds is set by fuzz targets that are only built with Go 1.18 and
+// later, which added fuzzing.
+var wantSeeds, seeds int
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 && seeds != wantSeeds {
+		fmt.Fprintf(os.Stderr, "fuzz targets ran %d seed inputs; want %d\n", seeds, wantSeeds)
+		code = 1
+	}
+	os.Exit(code)
+}
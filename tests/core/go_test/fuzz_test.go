@@ -0,0 +1,29 @@
+//go:build go1.18
+// +build go1.18
+
+package fuzz
+
+import "testing"
+
+func init() {
+	wantSeeds = 2
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add("abc")
+	f.Add("racecar")
+	f.Fuzz(func(t *testing.T, s string) {
+		seeds++
+		if got := reverse(reverse(s)); got != s {
+			t.Errorf("reverse(reverse(%q)) = %q", s, got)
+		}
+	})
+}
+
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
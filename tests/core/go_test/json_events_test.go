@@ -0,0 +1,11 @@
+package json_events
+
+import "testing"
+
+func TestPass(t *testing.T) {
+	t.Log("passing")
+}
+
+func TestSkip(t *testing.T) {
+	t.Skip("skipping")
+}
@@ -0,0 +1,33 @@
+package quarantine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFlaky fails on its first attempt and passes on its second.
+func TestFlaky(t *testing.T) {
+	path := filepath.Join(os.Getenv("TEST_TMPDIR"), "attempts")
+	data, _ := ioutil.ReadFile(path)
+	data = append(data, 'x')
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 2 {
+		t.Fatal("failing first attempt")
+	}
+}
+
+func TestBroken(t *testing.T) {
+	t.Error("always fails")
+}
+
+// TestPanics crashes the process running it. Only the quarantined test's
+// own process should be affected.
+func TestPanics(t *testing.T) {
+	panic("always panics")
+}
+
+func TestOrdinary(t *testing.T) {}
@@ -0,0 +1,5 @@
+package wrong_signature
+
+type T struct{}
+
+func TestWrong(t *T) {}
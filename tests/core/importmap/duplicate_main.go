@@ -0,0 +1,12 @@
+package main
+
+import (
+	"fmt"
+
+	a "dup/a"
+	b "dup/b"
+)
+
+func main() {
+	fmt.Println(a.Get(), b.Get())
+}
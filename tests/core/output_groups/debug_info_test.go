@@ -0,0 +1,87 @@
+package output_groups
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDebugInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("debug information is only split from ELF binaries")
+	}
+
+	var binPath, debugPath string
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Base(path) {
+		case "split_bin":
+			if !info.IsDir() {
+				binPath = path
+			}
+		case "split_bin.debug":
+			debugPath = path
+		}
+		return nil
+	})
+	if binPath == "" || debugPath == "" {
+		t.Fatalf("files not found: binary %q, debug file %q", binPath, debugPath)
+	}
+
+	bin, err := elf.Open(binPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bin.Close()
+	debug, err := elf.Open(debugPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer debug.Close()
+
+	if hasDWARF(bin) {
+		t.Errorf("%s: found DWARF sections; want none", binPath)
+	}
+	if !hasDWARF(debug) {
+		t.Errorf("%s: no DWARF sections found", debugPath)
+	}
+
+	binID := sectionData(t, bin, ".note.gnu.build-id")
+	debugID := sectionData(t, debug, ".note.gnu.build-id")
+	if binID == nil || !bytes.Equal(binID, debugID) {
+		t.Errorf("got build ID notes %x and %x; want equal, non-empty notes", binID, debugID)
+	}
+
+	link := sectionData(t, bin, ".gnu_debuglink")
+	if i := bytes.IndexByte(link, 0); i < 0 || string(link[:i]) != "split_bin.debug" {
+		t.Errorf("got .gnu_debuglink %q; want split_bin.debug", link)
+	}
+}
+
+func hasDWARF(f *elf.File) bool {
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_NOBITS && (strings.HasPrefix(s.Name, ".debug_") || strings.HasPrefix(s.Name, ".zdebug_")) {
+			return true
+		}
+	}
+	return false
+}
+
+func sectionData(t *testing.T, f *elf.File, name string) []byte {
+	s := f.Section(name)
+	if s == nil {
+		t.Errorf("section %s not found", name)
+		return nil
+	}
+	data, err := s.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,8 @@
+package main
+
+import (
+	_ "lib"
+	_ "notice"
+)
+
+func main() {}
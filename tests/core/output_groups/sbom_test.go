@@ -0,0 +1,74 @@
+package output_groups
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSBOM(t *testing.T) {
+	var sbomPath string
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, "/sbom_bin.spdx.json") {
+			sbomPath = path
+		}
+		return nil
+	})
+	if sbomPath == "" {
+		t.Fatal("bill of materials not found")
+	}
+
+	data, err := ioutil.ReadFile(sbomPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		SPDXVersion string `json:"spdxVersion"`
+		Name        string `json:"name"`
+		Packages    []struct {
+			Name            string `json:"name"`
+			VersionInfo     string `json:"versionInfo"`
+			SourceInfo      string `json:"sourceInfo"`
+			LicenseDeclared string `json:"licenseDeclared"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SPDXVersion != "SPDX-2.2" {
+		t.Errorf("got version %q; want SPDX-2.2", doc.SPDXVersion)
+	}
+	if !strings.HasSuffix(doc.Name, "//tests/core/output_groups:sbom_bin") {
+		t.Errorf("got name %q; want //tests/core/output_groups:sbom_bin", doc.Name)
+	}
+	found := map[string]string{}
+	licenses := map[string]string{}
+	for _, p := range doc.Packages {
+		found[p.Name] = p.SourceInfo
+		licenses[p.Name] = p.LicenseDeclared
+		if p.Name == "runtime" && !strings.HasPrefix(p.VersionInfo, "go") {
+			t.Errorf("got standard library version %q", p.VersionInfo)
+		}
+	}
+	if info, ok := found["lib"]; !ok || !strings.HasSuffix(info, "//tests/core/output_groups:lib") {
+		t.Errorf("package lib: got source %q; want //tests/core/output_groups:lib", info)
+	}
+	if l := licenses["lib"]; l != "NOASSERTION" {
+		t.Errorf("package lib: got license %q; want NOASSERTION", l)
+	}
+	if l := licenses["notice"]; l != "LicenseRef-notice" {
+		t.Errorf("package notice: got license %q; want LicenseRef-notice", l)
+	}
+	if _, ok := found["runtime"]; !ok {
+		t.Error("standard library package runtime not found")
+	}
+	if _, ok := found["std"]; ok {
+		t.Error("standard library listed as a single package")
+	}
+}
@@ -0,0 +1,71 @@
+package output_groups
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSizeReport(t *testing.T) {
+	var jsonPath, summaryPath string
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(path, "/bin.size.json"):
+			jsonPath = path
+		case strings.HasSuffix(path, "/bin.size.txt"):
+			summaryPath = path
+		}
+		return nil
+	})
+	if jsonPath == "" || summaryPath == "" {
+		t.Fatalf("size report not found: json %q, summary %q", jsonPath, summaryPath)
+	}
+
+	data, err := ioutil.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Label      string `json:"label"`
+		FileSize   int64  `json:"file_size"`
+		SymbolSize int64  `json:"symbol_size"`
+		Packages   []struct {
+			Package string `json:"package"`
+			Label   string `json:"label"`
+			Size    int64  `json:"size"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(report.Label, "//tests/core/output_groups:bin") {
+		t.Errorf("got label %q; want //tests/core/output_groups:bin", report.Label)
+	}
+	if report.SymbolSize <= 0 || report.SymbolSize > report.FileSize {
+		t.Errorf("got symbol size %d and file size %d", report.SymbolSize, report.FileSize)
+	}
+	found := map[string]string{}
+	for _, p := range report.Packages {
+		found[p.Package] = p.Label
+	}
+	if l := found["main"]; l != report.Label {
+		t.Errorf("got label %q for package main; want %q", l, report.Label)
+	}
+	if l := found["runtime"]; l != "(standard library)" {
+		t.Errorf("got label %q for package runtime; want (standard library)", l)
+	}
+
+	summary, err := ioutil.ReadFile(summaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(summary), "Symbol size:") {
+		t.Errorf("summary does not look right:\n%s", summary)
+	}
+}
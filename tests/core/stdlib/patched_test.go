@@ -0,0 +1,70 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patched_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPatched(t *testing.T) {
+	// strings.Patched only exists if strings.patch was applied.
+	if !strings.Patched {
+		t.Error("standard library was not patched")
+	}
+}
+
+func TestArchiveDiffers(t *testing.T) {
+	// The argument is a file listing the strings archive built from the
+	// patched sources and the one precompiled in the SDK.
+	if flag.NArg() != 1 {
+		t.Fatalf("got %d arguments; want 1", flag.NArg())
+	}
+	manifest, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archives := make(map[string][]byte)
+	for _, line := range strings.Split(strings.TrimSpace(string(manifest)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("malformed line in %s: %q", flag.Arg(0), line)
+		}
+		data, err := ioutil.ReadFile(fields[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives[fields[0]] = data
+	}
+	built, prebuilt := archives["built"], archives["prebuilt"]
+	if built == nil || prebuilt == nil {
+		t.Fatalf("%s does not list built and prebuilt archives", flag.Arg(0))
+	}
+
+	if bytes.Equal(built, prebuilt) {
+		t.Error("strings archive is the same as the one in the SDK")
+	}
+	// The export data names the constant added by the patch.
+	if !bytes.Contains(built, []byte("Patched")) {
+		t.Error("built strings archive does not contain Patched")
+	}
+	if bytes.Contains(prebuilt, []byte("Patched")) {
+		t.Error("SDK strings archive contains Patched")
+	}
+}
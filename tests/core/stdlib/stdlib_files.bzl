@@ -33,3 +33,29 @@ stdlib_files = go_rule(
         "race": attr.string(default = "off"),
     },
 )
+
+def _stdlib_archives_impl(ctx):
+    go = go_context(ctx)
+    suffix = "/" + ctx.attr.package + ".a"
+    built = [f for f in go.stdlib.libs if f.path.endswith(suffix)]
+    prebuilt = [f for f in go.sdk.libs if f.path.endswith(suffix)]
+    if len(built) != 1 or len(prebuilt) != 1:
+        fail("could not find archives for %s" % ctx.attr.package)
+    manifest = go.declare_file(go, ext = ".txt")
+    ctx.actions.write(manifest, "built {}\nprebuilt {}\n".format(
+        built[0].short_path,
+        prebuilt[0].short_path,
+    ))
+    return [DefaultInfo(
+        files = depset([manifest]),
+        runfiles = ctx.runfiles(files = [manifest, built[0], prebuilt[0]]),
+    )]
+
+# stdlib_archives lists the archive for a standard library package built by
+# the toolchain and the one precompiled in the SDK.
+stdlib_archives = go_rule(
+    _stdlib_archives_impl,
+    attrs = {
+        "package": attr.string(mandatory = True),
+    },
+)